@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"inventory-optimisation-server/internal/audit"
+	"inventory-optimisation-server/internal/platform/db"
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+
+	"github.com/pkg/errors"
+)
+
+// Audit represents the Audit API method handler set.
+type Audit struct {
+	MasterDB *db.DB
+}
+
+// List returns the audit events matching the actor, action, since, until and
+// limit query parameters. Times are expected in RFC 3339 format.
+func (a *Audit) List(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	dbConn := a.MasterDB.Copy()
+	defer dbConn.Close()
+
+	qry := r.URL.Query()
+
+	flt := audit.Filter{
+		Actor:  qry.Get("actor"),
+		Action: qry.Get("action"),
+	}
+
+	var inv web.InvalidError
+	if s := qry.Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			inv = append(inv, web.Invalid{Fld: "since", Err: "rfc3339"})
+		}
+		flt.Since = t
+	}
+	if s := qry.Get("until"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			inv = append(inv, web.Invalid{Fld: "until", Err: "rfc3339"})
+		}
+		flt.Until = t
+	}
+	if s := qry.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			inv = append(inv, web.Invalid{Fld: "limit", Err: "min=1"})
+		}
+		flt.Limit = n
+	}
+	if inv != nil {
+		return inv
+	}
+
+	events, err := audit.List(ctx, dbConn, flt)
+	if err != nil {
+		return errors.Wrapf(err, "Filter: %+v", flt)
+	}
+
+	web.Respond(ctx, log, w, events, http.StatusOK)
+	return nil
+}
+
+// auditTimeout bounds recording an audit event.
+const auditTimeout = 5 * time.Second
+
+// record appends an event to the audit trail for the request. The outcome is
+// derived from err. Failures to record are logged rather than returned so an
+// unavailable audit store does not change the result of the request.
+func record(ctx context.Context, log *log.Logger, dbConn *db.DB, r *http.Request, actor, action, target string, err error) {
+	v := ctx.Value(web.KeyValues).(*web.Values)
+
+	// The event must be recorded even when the client has gone away or the
+	// request ran out of time, which is when failures happen.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditTimeout)
+	defer cancel()
+
+	ne := audit.FromRequest(r, v.RequestID)
+	ne.Actor = actor
+	ne.Action = action
+	ne.Target = target
+	ne.Outcome = audit.OutcomeSuccess
+	if err != nil {
+		ne.Outcome = audit.OutcomeFailure
+	}
+
+	if _, err := audit.Record(ctx, dbConn, ne, v.Now); err != nil {
+		log.Error("recording audit event", "action", action, "error", err)
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/openapi"
+	"inventory-optimisation-server/internal/platform/web"
+
+	"github.com/pkg/errors"
+)
+
+// Docs serves the API documentation generated from the mounted routes.
+type Docs struct {
+	Info   openapi.Info
+	Prefix string
+	App    *web.App
+
+	once sync.Once
+	doc  *openapi.Document
+}
+
+// Spec returns the OpenAPI document.
+func (d *Docs) Spec(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	web.Respond(ctx, log, w, d.document(), http.StatusOK)
+	return nil
+}
+
+// Page returns a page rendering the OpenAPI document for people. It is
+// rendered here rather than by a script from a CDN, so it works offline and
+// runs no code we do not ship.
+func (d *Docs) Page(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	var page bytes.Buffer
+	if err := openapi.WriteHTML(&page, d.document()); err != nil {
+		return errors.Wrap(err, "rendering docs page")
+	}
+
+	v := ctx.Value(web.KeyValues).(*web.Values)
+	v.StatusCode = http.StatusOK
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", openapi.PagePolicy)
+	w.WriteHeader(http.StatusOK)
+	w.Write(page.Bytes())
+	return nil
+}
+
+// document returns the OpenAPI document. It is generated on first use so
+// every route has been mounted by then.
+func (d *Docs) document() *openapi.Document {
+	d.once.Do(func() {
+		d.doc = openapi.Generate(d.Info, d.Prefix, d.App.Routes())
+	})
+	return d.doc
+}
@@ -2,34 +2,42 @@ package handlers
 
 import (
 	"context"
-	"log"
 	"net/http"
 
-	"inventory-optimisation-server/internal/platform/db"
+	"inventory-optimisation-server/internal/platform/health"
+	"inventory-optimisation-server/internal/platform/log"
 	"inventory-optimisation-server/internal/platform/web"
 )
 
 // Health provides support for orchestration health checks.
 type Health struct {
-	MasterDB *db.DB
+	Checks *health.Registry
 }
 
-// Check validates the service is healthy and ready to accept requests.
-func (h *Health) Check(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
-
-	dbConn := h.MasterDB.Copy()
-	defer dbConn.Close()
+// Live reports whether the process is alive. The orchestrator restarts the
+// service when this fails.
+func (h *Health) Live(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	respondHealth(ctx, log, w, h.Checks.Live(ctx))
+	return nil
+}
 
-	if err := dbConn.StatusCheck(ctx); err != nil {
-		return err
-	}
+// Ready reports whether the service is ready to accept requests. The
+// orchestrator stops routing traffic to the service when this fails.
+func (h *Health) Ready(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	respondHealth(ctx, log, w, h.Checks.Ready(ctx))
+	return nil
+}
 
-	status := struct {
-		Status string `json:"status"`
-	}{
-		Status: "ok",
+// respondHealth sends the report with the detail of every check. A failed
+// report is sent with a 503 so the orchestrator does not need to read the
+// body.
+func respondHealth(ctx context.Context, log *log.Logger, w http.ResponseWriter, rep health.Report) {
+	status := http.StatusOK
+	if !rep.OK() {
+		status = http.StatusServiceUnavailable
+		log.Warn("health check failed", "report", rep)
 	}
 
-	web.Respond(ctx, log, w, status, http.StatusOK)
-	return nil
+	w.Header().Set("Cache-Control", "no-store")
+	web.Respond(ctx, log, w, rep, status)
 }
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"inventory-optimisation-server/internal/audit"
+	"inventory-optimisation-server/internal/platform/auth"
+	"inventory-optimisation-server/internal/platform/db"
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/oidc"
+	"inventory-optimisation-server/internal/platform/web"
+	"inventory-optimisation-server/internal/user"
+
+	"github.com/pkg/errors"
+)
+
+// oidcCookie holds the sealed login state between login and callback.
+const oidcCookie = "oidc_login"
+
+// OIDC represents the single sign-on API method handler set.
+type OIDC struct {
+	MasterDB       *db.DB
+	Provider       *oidc.Provider
+	TokenGenerator user.TokenGenerator
+
+	// CookieKey encrypts the login state cookie.
+	CookieKey []byte
+
+	// GroupRoles maps identity provider groups to our roles. Users that do
+	// not map to any role are refused.
+	GroupRoles map[string]string
+
+	// InsecureCookie drops the Secure attribute from the login state cookie
+	// for local development over plain HTTP.
+	InsecureCookie bool
+}
+
+// Login starts the authorization code flow by redirecting the user to the
+// identity provider.
+func (o *OIDC) Login(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v := ctx.Value(web.KeyValues).(*web.Values)
+
+	ls, err := oidc.NewLoginState(v.Now)
+	if err != nil {
+		return errors.Wrap(err, "creating login state")
+	}
+
+	sealed, err := oidc.Seal(o.CookieKey, ls)
+	if err != nil {
+		return errors.Wrap(err, "sealing login state")
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    sealed,
+		Path:     "/v1/auth/oidc",
+		MaxAge:   int(oidc.LoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   !o.InsecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	v.StatusCode = http.StatusFound
+	http.Redirect(w, r, o.Provider.AuthCodeURL(ls.State, ls.Nonce, ls.Verifier), http.StatusFound)
+	return nil
+}
+
+// Callback completes the authorization code flow. It validates the ID token,
+// provisions the user and responds with one of our own JWTs.
+func (o *OIDC) Callback(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	dbConn := o.MasterDB.Copy()
+	defer dbConn.Close()
+
+	v := ctx.Value(web.KeyValues).(*web.Values)
+
+	// The login state is single use.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Path:     "/v1/auth/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   !o.InsecureCookie,
+	})
+
+	qry := r.URL.Query()
+	if e := qry.Get("error"); e != "" {
+		return errors.Wrapf(web.ErrUnauthorized, "identity provider: %s %s", e, qry.Get("error_description"))
+	}
+
+	c, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return errors.Wrap(web.ErrUnauthorized, "missing login state")
+	}
+
+	ls, err := oidc.Open(o.CookieKey, c.Value, v.Now)
+	if err != nil {
+		return errors.Wrap(web.ErrUnauthorized, err.Error())
+	}
+
+	if qry.Get("state") != ls.State {
+		return errors.Wrap(web.ErrUnauthorized, "state mismatch")
+	}
+
+	rawIDToken, err := o.Provider.Exchange(ctx, qry.Get("code"), ls.Verifier)
+	if err != nil {
+		return errors.Wrap(web.ErrUnauthorized, err.Error())
+	}
+
+	id, err := o.Provider.Verify(ctx, rawIDToken, ls.Nonce)
+	if err != nil {
+		return errors.Wrap(web.ErrUnauthorized, err.Error())
+	}
+
+	// Until the user is provisioned they are only known to the identity
+	// provider, so failures are recorded against their identity there.
+	external := id.Issuer + "|" + id.Subject
+
+	// The email address is how people are told apart in the user list, an
+	// unverified one could be anybody's.
+	if id.Email == "" || !id.EmailVerified {
+		err := errors.Wrapf(web.ErrForbidden, "email %q is not verified", id.Email)
+		record(ctx, log, dbConn, r, external, audit.ActionLoginFailed, id.Email, err)
+		return err
+	}
+
+	roles := auth.MapRoles(id.Groups, o.GroupRoles)
+	if len(roles) == 0 {
+		err := errors.Wrapf(web.ErrForbidden, "no role for groups %v", id.Groups)
+		record(ctx, log, dbConn, r, external, audit.ActionLoginFailed, id.Email, err)
+		return err
+	}
+
+	eu := user.ExternalUser{
+		Issuer:  id.Issuer,
+		Subject: id.Subject,
+		Name:    id.Name,
+		Email:   id.Email,
+		Roles:   roles,
+	}
+
+	usr, err := user.Provision(ctx, dbConn, &eu, v.Now)
+	if err != nil {
+		record(ctx, log, dbConn, r, external, audit.ActionLoginFailed, id.Email, err)
+		return errors.Wrapf(err, "provisioning %s", id.Subject)
+	}
+
+	tkn, err := user.NewToken(o.TokenGenerator, usr, v.Now)
+	if err != nil {
+		return err
+	}
+
+	record(ctx, log, dbConn, r, usr.ID.Hex(), audit.ActionLogin, usr.ID.Hex(), nil)
+	record(ctx, log, dbConn, r, usr.ID.Hex(), audit.ActionTokenIssue, usr.ID.Hex(), nil)
+
+	web.Respond(ctx, log, w, tkn, http.StatusOK)
+	return nil
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
-	"log"
+	"encoding/json"
+	"mime/multipart"
 	"net/http"
 
 	"github.com/pkg/errors"
@@ -10,15 +12,24 @@ import (
 	// "github.com/aws/aws-sdk-go/aws/session"
 	// "github.com/aws/aws-sdk-go/service/s3/s3manager"
 
+	"inventory-optimisation-server/internal/audit"
 	"inventory-optimisation-server/internal/constants"
 	"inventory-optimisation-server/internal/optimisationRequest"
 	"inventory-optimisation-server/internal/platform/db"
+	"inventory-optimisation-server/internal/platform/log"
 	"inventory-optimisation-server/internal/platform/web"
 )
 
 // OptimisationRequest Handler
 type OptimisationRequest struct {
 	MasterDB *db.DB
+
+	// RequireIfMatch rejects changes sent without If-Match.
+	RequireIfMatch bool
+
+	// MaxMemory is how much of an uploaded form is held in memory, the
+	// rest is stored in temporary files.
+	MaxMemory int64
 }
 
 // Create will validate the request and then add it to queue
@@ -28,8 +39,8 @@ func (o *OptimisationRequest) Create(ctx context.Context, log *log.Logger, w htt
 
 	v := ctx.Value(web.KeyValues).(*web.Values)
 
-	err := r.ParseMultipartForm(100000)
-	if err != nil {
+	var form optimisationRequest.NewRequestForm
+	if err := web.UnmarshalForm(ctx, r, o.MaxMemory, &form); err != nil {
 		return errors.Wrap(err, "")
 	}
 
@@ -39,30 +50,39 @@ func (o *OptimisationRequest) Create(ctx context.Context, log *log.Logger, w htt
 	// bucket := ""
 
 	fileTypes := []string{constants.PRODUCT_DATA_FILE, constants.FACTORY_DATA_FILE}
+	files := map[string][]*multipart.FileHeader{
+		constants.PRODUCT_DATA_FILE: form.ProductData,
+		constants.FACTORY_DATA_FILE: form.FactoryData,
+	}
 	requestInput := []optimisationRequest.NewRequestInput{}
-	name := r.Form["name"][0]
 	for _, fileType := range fileTypes {
-		for _, file := range r.MultipartForm.File[fileType] {
+		for _, file := range files[fileType] {
 			errFile, valid := optimisationRequest.Validate(file, fileType)
 			if valid == false {
 				web.Respond(ctx, log, w, errFile, http.StatusUnprocessableEntity)
-			} else {
-				// Upload to S3 Code
-				requestInput = append(requestInput, optimisationRequest.NewRequestInput{
-					Type:     fileType,
-					Location: "s3-path",
-				})
+				return nil
 			}
+
+			// Upload to S3 Code
+			requestInput = append(requestInput, optimisationRequest.NewRequestInput{
+				Type:     fileType,
+				Location: "s3-path",
+			})
 		}
 	}
 
 	newRequest := optimisationRequest.NewRequest{
-		Name:  name,
+		Name:  form.Name,
 		Input: requestInput,
 	}
 
 	request, err := optimisationRequest.Create(ctx, dbConn, &newRequest, v.Now)
-	if err = translate(err); err != nil {
+	target := form.Name
+	if request != nil {
+		target = request.ID.Hex()
+	}
+	record(ctx, log, dbConn, r, actor(ctx), audit.ActionOptimisationSubmit, target, err)
+	if err != nil {
 		return errors.Wrapf(err, "Request: %+v", &request)
 	}
 
@@ -72,6 +92,10 @@ func (o *OptimisationRequest) Create(ctx context.Context, log *log.Logger, w htt
 
 // Validate will validate the excel input
 func (o *OptimisationRequest) Validate(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	if err := web.ParseForm(r, o.MaxMemory); err != nil {
+		return errors.Wrap(err, "")
+	}
+
 	fileType := r.URL.Query().Get("type")
 	if len(r.MultipartForm.File[fileType]) == 0 {
 		return web.ErrValidation
@@ -79,6 +103,7 @@ func (o *OptimisationRequest) Validate(ctx context.Context, log *log.Logger, w h
 	result, valid := optimisationRequest.Validate(r.MultipartForm.File[fileType][0], fileType)
 	if valid == false {
 		web.Respond(ctx, log, w, result, http.StatusUnprocessableEntity)
+		return nil
 	}
 	web.Respond(ctx, log, w, nil, http.StatusNoContent)
 	return nil
@@ -90,11 +115,26 @@ func (o *OptimisationRequest) List(ctx context.Context, log *log.Logger, w http.
 	dbConn := o.MasterDB.Copy()
 	defer dbConn.Close()
 
-	requests, err := optimisationRequest.List(ctx, dbConn)
-	if err = translate(err); err != nil {
+	incl, err := includeDeleted(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	requests, err := optimisationRequest.List(ctx, dbConn, incl)
+	if err != nil {
 		return errors.Wrap(err, "")
 	}
 
+	// Dashboards poll the list, most polls can be answered without a body.
+	etag, err := web.ContentETag(requests)
+	if err != nil {
+		return err
+	}
+	if web.NotModified(w, r, etag) {
+		web.Respond(ctx, log, w, nil, http.StatusNotModified)
+		return nil
+	}
+
 	web.Respond(ctx, log, w, requests, http.StatusOK)
 	return nil
 }
@@ -105,11 +145,134 @@ func (o *OptimisationRequest) Retrieve(ctx context.Context, log *log.Logger, w h
 	dbConn := o.MasterDB.Copy()
 	defer dbConn.Close()
 
-	request, err := optimisationRequest.Retrieve(ctx, dbConn, params["id"])
-	if err = translate(err); err != nil {
+	incl, err := includeDeleted(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	request, err := optimisationRequest.Retrieve(ctx, dbConn, params["id"], incl)
+	if err != nil {
 		return errors.Wrapf(err, "Id: %s", params["id"])
 	}
 
+	if web.NotModified(w, r, web.ETag(request.Version)) {
+		web.Respond(ctx, log, w, nil, http.StatusNotModified)
+		return nil
+	}
+
 	web.Respond(ctx, log, w, request, http.StatusOK)
 	return nil
 }
+
+// Update replaces the changeable fields of the specified request.
+func (o *OptimisationRequest) Update(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	dbConn := o.MasterDB.Copy()
+	defer dbConn.Close()
+
+	version, err := web.IfMatch(r, o.RequireIfMatch)
+	if err != nil {
+		return err
+	}
+
+	var upd optimisationRequest.UpdateRequest
+	if err := web.Decode(ctx, r, &upd); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	return o.update(ctx, log, dbConn, w, r, params["id"], &upd, version)
+}
+
+// Patch changes the specified request with a JSON Merge Patch or a JSON
+// Patch. The patch is applied to the request as it would be sent to Update.
+func (o *OptimisationRequest) Patch(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	dbConn := o.MasterDB.Copy()
+	defer dbConn.Close()
+
+	version, err := web.IfMatch(r, o.RequireIfMatch)
+	if err != nil {
+		return err
+	}
+
+	request, err := optimisationRequest.Retrieve(ctx, dbConn, params["id"], false)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+	if version != web.AnyVersion && version != request.Version {
+		return web.ErrPreconditionFailed
+	}
+
+	doc, err := json.Marshal(optimisationRequest.UpdateRequest{Name: request.Name})
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	doc, err = web.Patch(r, doc)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	var upd optimisationRequest.UpdateRequest
+	if err := web.Unmarshal(ctx, bytes.NewReader(doc), &upd); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	// The patch was applied to the version we retrieved so it must not be
+	// saved over a newer one.
+	return o.update(ctx, log, dbConn, w, r, params["id"], &upd, request.Version)
+}
+
+// update saves the replacement for the request once the request for it has
+// been decoded.
+func (o *OptimisationRequest) update(ctx context.Context, log *log.Logger, dbConn *db.DB, w http.ResponseWriter, r *http.Request, id string, upd *optimisationRequest.UpdateRequest, version int) error {
+
+	err := optimisationRequest.Update(ctx, dbConn, id, upd, version)
+	record(ctx, log, dbConn, r, actor(ctx), audit.ActionOptimisationUpdate, id, err)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s  Request: %+v", id, upd)
+	}
+
+	web.Respond(ctx, log, w, nil, http.StatusNoContent)
+	return nil
+}
+
+// Delete removes the specified request from the system. The request can be
+// brought back with Restore until it is purged.
+func (o *OptimisationRequest) Delete(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	dbConn := o.MasterDB.Copy()
+	defer dbConn.Close()
+
+	v := ctx.Value(web.KeyValues).(*web.Values)
+
+	version, err := web.IfMatch(r, o.RequireIfMatch)
+	if err != nil {
+		return err
+	}
+
+	err = optimisationRequest.Delete(ctx, dbConn, params["id"], version, v.Now)
+	record(ctx, log, dbConn, r, actor(ctx), audit.ActionOptimisationDelete, params["id"], err)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	web.Respond(ctx, log, w, nil, http.StatusNoContent)
+	return nil
+}
+
+// Restore brings back a deleted request.
+func (o *OptimisationRequest) Restore(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	dbConn := o.MasterDB.Copy()
+	defer dbConn.Close()
+
+	err := optimisationRequest.Restore(ctx, dbConn, params["id"])
+	record(ctx, log, dbConn, r, actor(ctx), audit.ActionOptimisationRestore, params["id"], err)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	web.Respond(ctx, log, w, nil, http.StatusNoContent)
+	return nil
+}
@@ -1,54 +1,306 @@
 package handlers
 
 import (
-	"log"
 	"net/http"
+	"time"
 
+	"inventory-optimisation-server/internal/audit"
 	"inventory-optimisation-server/internal/mid"
+	"inventory-optimisation-server/internal/optimisationRequest"
 	"inventory-optimisation-server/internal/platform/auth"
 	"inventory-optimisation-server/internal/platform/db"
+	"inventory-optimisation-server/internal/platform/health"
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/openapi"
+	"inventory-optimisation-server/internal/platform/ratelimit"
 	"inventory-optimisation-server/internal/platform/web"
+	"inventory-optimisation-server/internal/user"
 )
 
+// Limits are the rate limits applied to the routes. Logging in and
+// submitting optimisation requests are expensive so they have their own
+// budgets.
+type Limits struct {
+	Store   ratelimit.Store
+	Default ratelimit.Rate
+	Login   ratelimit.Rate
+	Submit  ratelimit.Rate
+}
+
+// BodyLimits are the largest request bodies accepted. Optimisation input
+// files are uploaded under their own limit, of which up to UploadMemory
+// bytes are held in memory.
+type BodyLimits struct {
+	Default      int64
+	Upload       int64
+	UploadMemory int64
+}
+
+// Timeouts bound how long clients have to send a request body, how long
+// routes have to handle a request and how long they then have to send the
+// response. Optimisation input uploads are large so Upload replaces the
+// read and handling limits on their routes.
+type Timeouts struct {
+	Read   time.Duration
+	Handle time.Duration
+	Write  time.Duration
+	Upload time.Duration
+}
+
+// Config is everything the routes need.
+type Config struct {
+	MasterDB         *db.DB
+	Checks           *health.Registry
+	Authenticator    *auth.Authenticator
+	CertificateRoles map[string]string
+	PasswordPolicy   user.PasswordPolicy
+	Hasher           user.Hasher
+
+	// RequireIfMatch makes changes to users and optimisation requests
+	// conditional on the version the client last retrieved.
+	RequireIfMatch bool
+
+	Limits   Limits
+	Bodies   BodyLimits
+	Timeouts Timeouts
+
+	// StrictDecoding rejects request bodies with fields the model does not
+	// have rather than ignoring them.
+	StrictDecoding bool
+
+	Idempotency *mid.Idempotency
+	CORS        *mid.CORS
+	Compress    *mid.Compress
+	Security    *mid.Security
+
+	// SSO is nil when no identity provider is configured.
+	SSO *OIDC
+}
+
 // API returns a handler for a set of routes.
-func API(log *log.Logger, masterDB *db.DB, authenticator *auth.Authenticator) http.Handler {
+func API(log *log.Logger, cfg Config) http.Handler {
 
 	// authmw is used for authentication/authorization middleware.
 	authmw := mid.Auth{
-		Authenticator: authenticator,
+		Authenticator:    cfg.Authenticator,
+		CertificateRoles: cfg.CertificateRoles,
 	}
 
-	app := web.New(log, mid.RequestLogger, mid.Metrics, mid.ErrorHandler)
+	// These limit each caller, they run after authentication so users are
+	// told apart from each other.
+	general := mid.RateLimit{Store: cfg.Limits.Store, Rate: cfg.Limits.Default, Budget: "default"}
+	login := mid.RateLimit{Store: cfg.Limits.Store, Rate: cfg.Limits.Login, Budget: "login"}
+	submit := mid.RateLimit{Store: cfg.Limits.Store, Rate: cfg.Limits.Submit, Budget: "submit"}
+
+	app := web.New(log, mid.RequestLogger, mid.Metrics, cfg.CORS.Handle, cfg.Compress.Handle, mid.ErrorHandler, cfg.Security.Handle, mid.Timeout)
+	app.LimitBody(cfg.Bodies.Default)
+	app.LimitRead(cfg.Timeouts.Read)
+	app.LimitTime(cfg.Timeouts.Handle, cfg.Timeouts.Write)
+	app.StrictDecoding(cfg.StrictDecoding)
+
+	// Version 1 is the default for clients that do not ask for a version.
+	// Version 2 serves the same resources while their contracts diverge,
+	// handlers tell them apart with web.Values.Version.
+	v1 := app.Version("v1")
+	v2 := app.Version("v2")
 
-	// Register health check endpoint. This route is not authenticated.
+	// Register health check endpoints. These routes are not authenticated.
+	// The original endpoint is kept as an alias for readiness.
 	h := Health{
-		MasterDB: masterDB,
+		Checks: cfg.Checks,
 	}
-	app.Handle("GET", "/v1/health", h.Check)
+	v1.Handle("GET", "/health", h.Ready).Describe(web.Doc{
+		Summary:  "Report whether the service is ready, alias of /health/ready",
+		Response: health.Report{},
+	})
+	v1.Handle("GET", "/health/live", h.Live).Describe(web.Doc{
+		Summary:  "Report whether the service is alive",
+		Response: health.Report{},
+	})
+	v1.Handle("GET", "/health/ready", h.Ready).Describe(web.Doc{
+		Summary:  "Report whether the service is ready to accept requests",
+		Response: health.Report{},
+	})
 
-	// Register user management and authentication endpoints.
-	u := User{
-		MasterDB:       masterDB,
-		TokenGenerator: authenticator,
+	// Register single sign-on endpoints when an identity provider is
+	// configured. These routes are not authenticated. They are only served
+	// under the version the identity provider redirects back to.
+	if cfg.SSO != nil {
+		v1.Handle("GET", "/auth/oidc/login", cfg.SSO.Login, login.Limit).Describe(web.Doc{
+			Summary: "Redirect to the identity provider to log in",
+			Status:  http.StatusFound,
+		})
+		v1.Handle("GET", "/auth/oidc/callback", cfg.SSO.Callback, login.Limit).Describe(web.Doc{
+			Summary:  "Complete a login at the identity provider and issue a token",
+			Query:    map[string]string{"code": "Authorization code.", "state": "Login state."},
+			Response: user.Token{},
+		})
 	}
 
-	app.Handle("GET", "/v1/users", u.List, authmw.Authenticate)
-	app.Handle("POST", "/v1/users", u.Create, authmw.Authenticate)
-	app.Handle("GET", "/v1/users/:id", u.Retrieve, authmw.Authenticate)
-	app.Handle("PUT", "/v1/users/:id", u.Update, authmw.Authenticate)
-	app.Handle("DELETE", "/v1/users/:id", u.Delete, authmw.Authenticate)
-
-	// This route is not authenticated
-	app.Handle("GET", "/v1/users/token", u.Token)
+	// Register the API documentation of each version. These routes are not
+	// authenticated.
+	for _, version := range []struct {
+		name  string
+		group *web.Group
+	}{{"v1", v1}, {"v2", v2}} {
+		d := Docs{
+			Info: openapi.Info{
+				Title:   "Inventory Optimisation API",
+				Version: version.name,
+			},
+			Prefix: "/" + version.name,
+			App:    app,
+		}
+		version.group.Handle("GET", "/openapi.json", d.Spec).Describe(web.Doc{
+			Summary: "Get this OpenAPI document",
+		})
+		version.group.Handle("GET", "/docs", d.Page).Describe(web.Doc{
+			Summary: "Browse this OpenAPI document",
+		})
+	}
 
+	u := User{
+		MasterDB:       cfg.MasterDB,
+		TokenGenerator: cfg.Authenticator,
+		PasswordPolicy: cfg.PasswordPolicy,
+		Hasher:         cfg.Hasher,
+		RequireIfMatch: cfg.RequireIfMatch,
+	}
+	a := Audit{
+		MasterDB: cfg.MasterDB,
+	}
 	o := OptimisationRequest{
-		MasterDB: masterDB,
+		MasterDB:       cfg.MasterDB,
+		RequireIfMatch: cfg.RequireIfMatch,
+		MaxMemory:      cfg.Bodies.UploadMemory,
 	}
-	app.Handle("GET", "/v1/validate", o.Validate)
-	app.Handle("POST", "/v1/optimisation-requests", o.Create)
 
-	app.Handle("GET", "/v1/optimisation-requests", o.List)
-	app.Handle("GET", "/v1/optimisation-requests/:id", o.Retrieve)
+	// include documents the query parameter that lets admins see deleted
+	// entities.
+	include := map[string]string{"include_deleted": "Set to true to include deleted entities. Admins only."}
+
+	for _, v := range []*web.Group{v1, v2} {
+
+		// Register user management and authentication endpoints.
+		users := v.Group("/users", authmw.Authenticate, general.Limit)
+		users.Handle("GET", "", u.List).Describe(web.Doc{
+			Summary:  "List users",
+			Query:    include,
+			Response: []user.User{},
+			Auth:     web.AuthBearer,
+		})
+		users.Handle("POST", "", u.Create, cfg.Idempotency.Handle).Describe(web.Doc{
+			Summary:  "Create a user",
+			Request:  user.NewUser{},
+			Response: user.User{},
+			Status:   http.StatusCreated,
+			Auth:     web.AuthBearer,
+		})
+		users.Handle("GET", "/:id", u.Retrieve).Describe(web.Doc{
+			Summary:  "Get a user",
+			Query:    include,
+			Response: user.User{},
+			Auth:     web.AuthBearer,
+		})
+		users.Handle("PUT", "/:id", u.Update).Describe(web.Doc{
+			Summary: "Replace a user",
+			Request: user.UpdateUser{},
+			Status:  http.StatusNoContent,
+			Auth:    web.AuthBearer,
+		})
+		users.Handle("PATCH", "/:id", u.Patch).Describe(web.Doc{
+			Summary: "Change a user with a JSON Merge Patch or a JSON Patch of the replacement",
+			Request: user.UpdateUser{},
+			Status:  http.StatusNoContent,
+			Auth:    web.AuthBearer,
+		})
+		users.Handle("DELETE", "/:id", u.Delete).Describe(web.Doc{
+			Summary: "Delete a user",
+			Status:  http.StatusNoContent,
+			Auth:    web.AuthBearer,
+		})
+		users.Handle("POST", "/:id/restore", u.Restore, authmw.HasRole(auth.RoleAdmin)).Describe(web.Doc{
+			Summary: "Restore a deleted user. Admins only",
+			Status:  http.StatusNoContent,
+			Auth:    web.AuthBearer,
+		})
+
+		// This route is not authenticated
+		v.Handle("GET", "/users/token", u.Token, login.Limit).Describe(web.Doc{
+			Summary:  "Issue a token for the user's email and password",
+			Response: user.Token{},
+			Auth:     web.AuthBasic,
+		})
+
+		// Register audit trail endpoints. These are restricted to admins.
+		v.Handle("GET", "/audit", a.List, authmw.Authenticate, authmw.HasRole(auth.RoleAdmin), general.Limit).Describe(web.Doc{
+			Summary: "List audit events, newest first. Admins only",
+			Query: map[string]string{
+				"actor":  "Only events by this actor.",
+				"action": "Only events of this action.",
+				"since":  "Only events at or after this RFC 3339 time.",
+				"until":  "Only events before this RFC 3339 time.",
+				"limit":  "Maximum number of events, 100 by default.",
+			},
+			Response: []audit.Event{},
+			Auth:     web.AuthBearer,
+		})
+
+		// Register optimisation request endpoints. Anyone can submit and
+		// read requests, only admins can change, delete and restore them.
+		v.Handle("GET", "/validate", o.Validate, general.Limit).Describe(web.Doc{
+			Summary: "Validate an input file sent as multipart form data",
+			Query:   map[string]string{"type": "Form field holding the file."},
+			Status:  http.StatusNoContent,
+		}).LimitBody(cfg.Bodies.Upload).LimitRead(cfg.Timeouts.Upload).LimitTime(cfg.Timeouts.Upload)
+
+		reqs := v.Group("/optimisation-requests")
+		reqs.Handle("POST", "", o.Create, authmw.Identify, submit.Limit, cfg.Idempotency.Handle).Describe(web.Doc{
+			Summary:      "Submit an optimisation request as multipart form data",
+			Response:     optimisationRequest.Request{},
+			Status:       http.StatusCreated,
+			Auth:         web.AuthBearer,
+			AuthOptional: true,
+		}).LimitBody(cfg.Bodies.Upload).LimitRead(cfg.Timeouts.Upload).LimitTime(cfg.Timeouts.Upload)
+		reqs.Handle("GET", "", o.List, authmw.Identify, general.Limit).Describe(web.Doc{
+			Summary:      "List optimisation requests",
+			Query:        include,
+			Response:     []optimisationRequest.Request{},
+			Auth:         web.AuthBearer,
+			AuthOptional: true,
+		})
+		reqs.Handle("GET", "/:id", o.Retrieve, authmw.Identify, general.Limit).Describe(web.Doc{
+			Summary:      "Get an optimisation request",
+			Query:        include,
+			Response:     optimisationRequest.Request{},
+			Auth:         web.AuthBearer,
+			AuthOptional: true,
+		})
+
+		admin := reqs.Group("", authmw.Authenticate, authmw.HasRole(auth.RoleAdmin), general.Limit)
+		admin.Handle("PUT", "/:id", o.Update).Describe(web.Doc{
+			Summary: "Replace an optimisation request. Admins only",
+			Request: optimisationRequest.UpdateRequest{},
+			Status:  http.StatusNoContent,
+			Auth:    web.AuthBearer,
+		})
+		admin.Handle("PATCH", "/:id", o.Patch).Describe(web.Doc{
+			Summary: "Change an optimisation request with a JSON Merge Patch or a JSON Patch of the replacement. Admins only",
+			Request: optimisationRequest.UpdateRequest{},
+			Status:  http.StatusNoContent,
+			Auth:    web.AuthBearer,
+		})
+		admin.Handle("DELETE", "/:id", o.Delete).Describe(web.Doc{
+			Summary: "Delete an optimisation request. Admins only",
+			Status:  http.StatusNoContent,
+			Auth:    web.AuthBearer,
+		})
+		admin.Handle("POST", "/:id/restore", o.Restore).Describe(web.Doc{
+			Summary: "Restore a deleted optimisation request. Admins only",
+			Status:  http.StatusNoContent,
+			Auth:    web.AuthBearer,
+		})
+	}
 
 	return app
 }
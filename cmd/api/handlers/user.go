@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
-	"log"
+	"encoding/json"
 	"net/http"
 
+	"inventory-optimisation-server/internal/audit"
 	"inventory-optimisation-server/internal/platform/auth"
 	"inventory-optimisation-server/internal/platform/db"
+	"inventory-optimisation-server/internal/platform/log"
 	"inventory-optimisation-server/internal/platform/web"
 	"inventory-optimisation-server/internal/user"
 
@@ -17,6 +20,12 @@ import (
 type User struct {
 	MasterDB       *db.DB
 	TokenGenerator user.TokenGenerator
+	PasswordPolicy user.PasswordPolicy
+	Hasher         user.Hasher
+
+	// RequireIfMatch rejects changes sent without If-Match so clients
+	// cannot overwrite each other's changes.
+	RequireIfMatch bool
 
 	// ADD OTHER STATE LIKE THE LOGGER AND CONFIG HERE.
 }
@@ -27,11 +36,25 @@ func (u *User) List(ctx context.Context, log *log.Logger, w http.ResponseWriter,
 	dbConn := u.MasterDB.Copy()
 	defer dbConn.Close()
 
-	usrs, err := user.List(ctx, dbConn)
-	if err = translate(err); err != nil {
+	incl, err := includeDeleted(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	usrs, err := user.List(ctx, dbConn, incl)
+	if err != nil {
 		return errors.Wrap(err, "")
 	}
 
+	etag, err := web.ContentETag(usrs)
+	if err != nil {
+		return err
+	}
+	if web.NotModified(w, r, etag) {
+		web.Respond(ctx, log, w, nil, http.StatusNotModified)
+		return nil
+	}
+
 	web.Respond(ctx, log, w, usrs, http.StatusOK)
 	return nil
 }
@@ -47,11 +70,21 @@ func (u *User) Retrieve(ctx context.Context, log *log.Logger, w http.ResponseWri
 		return web.ErrUnauthorized
 	}
 
-	usr, err := user.Retrieve(ctx, claims, dbConn, params["id"])
-	if err = translate(err); err != nil {
+	incl, err := includeDeleted(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	usr, err := user.Retrieve(ctx, claims, dbConn, params["id"], incl)
+	if err != nil {
 		return errors.Wrapf(err, "Id: %s", params["id"])
 	}
 
+	if web.NotModified(w, r, web.ETag(usr.Version)) {
+		web.Respond(ctx, log, w, nil, http.StatusNotModified)
+		return nil
+	}
+
 	web.Respond(ctx, log, w, usr, http.StatusOK)
 	return nil
 }
@@ -65,12 +98,21 @@ func (u *User) Create(ctx context.Context, log *log.Logger, w http.ResponseWrite
 	v := ctx.Value(web.KeyValues).(*web.Values)
 
 	var newU user.NewUser
-	if err := web.Unmarshal(r.Body, &newU); err != nil {
+	if err := web.Decode(ctx, r, &newU); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	if err := u.PasswordPolicy.Check(newU.Email, newU.Password); err != nil {
 		return errors.Wrap(err, "")
 	}
 
-	usr, err := user.Create(ctx, dbConn, &newU, v.Now)
-	if err = translate(err); err != nil {
+	usr, err := user.Create(ctx, dbConn, u.Hasher, &newU, v.Now)
+	target := newU.Email
+	if usr != nil {
+		target = usr.ID.Hex()
+	}
+	record(ctx, log, dbConn, r, actor(ctx), audit.ActionUserCreate, target, err)
+	if err != nil {
 		return errors.Wrapf(err, "User: %+v", &usr)
 	}
 
@@ -78,36 +120,153 @@ func (u *User) Create(ctx context.Context, log *log.Logger, w http.ResponseWrite
 	return nil
 }
 
-// Update updates the specified user in the system.
+// Update replaces the specified user in the system.
 func (u *User) Update(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
 
 	dbConn := u.MasterDB.Copy()
 	defer dbConn.Close()
 
-	v := ctx.Value(web.KeyValues).(*web.Values)
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.ErrUnauthorized
+	}
+
+	version, err := web.IfMatch(r, u.RequireIfMatch)
+	if err != nil {
+		return err
+	}
+
+	var upd user.UpdateUser
+	if err := web.Decode(ctx, r, &upd); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	usr, err := user.Retrieve(ctx, claims, dbConn, params["id"], false)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	return u.update(ctx, log, dbConn, w, r, claims, usr, &upd, version)
+}
+
+// Patch changes the specified user with a JSON Merge Patch or a JSON Patch.
+// The patch is applied to the user as it would be sent to Update.
+func (u *User) Patch(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	dbConn := u.MasterDB.Copy()
+	defer dbConn.Close()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.ErrUnauthorized
+	}
+
+	version, err := web.IfMatch(r, u.RequireIfMatch)
+	if err != nil {
+		return err
+	}
+
+	usr, err := user.Retrieve(ctx, claims, dbConn, params["id"], false)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+	if version != web.AnyVersion && version != usr.Version {
+		return web.ErrPreconditionFailed
+	}
+
+	cur := user.UpdateUser{
+		Name:  usr.Name,
+		Email: usr.Email,
+		Roles: usr.Roles,
+	}
+	if cur.Roles == nil {
+		cur.Roles = []string{}
+	}
+
+	doc, err := json.Marshal(cur)
+	if err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	doc, err = web.Patch(r, doc)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
 
 	var upd user.UpdateUser
-	if err := web.Unmarshal(r.Body, &upd); err != nil {
+	if err := web.Unmarshal(ctx, bytes.NewReader(doc), &upd); err != nil {
 		return errors.Wrap(err, "")
 	}
 
-	err := user.Update(ctx, dbConn, params["id"], &upd, v.Now)
-	if err = translate(err); err != nil {
-		return errors.Wrapf(err, "Id: %s  User: %+v", params["id"], &upd)
+	// The patch was applied to the version we retrieved so it must not be
+	// saved over a newer one.
+	return u.update(ctx, log, dbConn, w, r, claims, usr, &upd, usr.Version)
+}
+
+// update saves the replacement for the user once the request for it has
+// been decoded.
+func (u *User) update(ctx context.Context, log *log.Logger, dbConn *db.DB, w http.ResponseWriter, r *http.Request, claims auth.Claims, usr *user.User, upd *user.UpdateUser, version int) error {
+
+	v := ctx.Value(web.KeyValues).(*web.Values)
+	id := usr.ID.Hex()
+
+	// Users may change their own record so only admins may change roles or
+	// anyone could make themselves an admin.
+	if !claims.HasRole(auth.RoleAdmin) && !sameRoles(usr.Roles, upd.Roles) {
+		return web.Detail(web.ErrForbidden, "only admins may change roles")
+	}
+
+	if upd.Password != "" {
+		if err := u.PasswordPolicy.Check(upd.Email, upd.Password); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+
+	err := user.Update(ctx, dbConn, u.Hasher, id, upd, version, v.Now)
+	record(ctx, log, dbConn, r, actor(ctx), audit.ActionUserUpdate, id, err)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", id)
 	}
 
 	web.Respond(ctx, log, w, nil, http.StatusNoContent)
 	return nil
 }
 
-// Delete removes the specified user from the system.
+// Delete removes the specified user from the system. The user can be
+// brought back with Restore until it is purged.
 func (u *User) Delete(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
 
 	dbConn := u.MasterDB.Copy()
 	defer dbConn.Close()
 
-	err := user.Delete(ctx, dbConn, params["id"])
-	if err = translate(err); err != nil {
+	v := ctx.Value(web.KeyValues).(*web.Values)
+
+	version, err := web.IfMatch(r, u.RequireIfMatch)
+	if err != nil {
+		return err
+	}
+
+	err = user.Delete(ctx, dbConn, params["id"], version, v.Now)
+	record(ctx, log, dbConn, r, actor(ctx), audit.ActionUserDelete, params["id"], err)
+	if err != nil {
+		return errors.Wrapf(err, "Id: %s", params["id"])
+	}
+
+	web.Respond(ctx, log, w, nil, http.StatusNoContent)
+	return nil
+}
+
+// Restore brings back a deleted user.
+func (u *User) Restore(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	dbConn := u.MasterDB.Copy()
+	defer dbConn.Close()
+
+	v := ctx.Value(web.KeyValues).(*web.Values)
+
+	err := user.Restore(ctx, dbConn, params["id"], v.Now)
+	record(ctx, log, dbConn, r, actor(ctx), audit.ActionUserRestore, params["id"], err)
+	if err != nil {
 		return errors.Wrapf(err, "Id: %s", params["id"])
 	}
 
@@ -129,11 +288,64 @@ func (u *User) Token(ctx context.Context, log *log.Logger, w http.ResponseWriter
 		return web.ErrUnauthorized
 	}
 
-	tkn, err := user.Authenticate(ctx, dbConn, u.TokenGenerator, v.Now, email, pass)
-	if err = translate(err); err != nil {
+	tkn, err := user.Authenticate(ctx, dbConn, u.TokenGenerator, u.Hasher, v.Now, email, pass)
+	if err != nil {
+		record(ctx, log, dbConn, r, email, audit.ActionLoginFailed, email, err)
+	} else {
+		record(ctx, log, dbConn, r, email, audit.ActionLogin, email, nil)
+		record(ctx, log, dbConn, r, email, audit.ActionTokenIssue, email, nil)
+	}
+	if err != nil {
 		return errors.Wrap(err, "authenticating")
 	}
 
 	web.Respond(ctx, log, w, tkn, http.StatusOK)
 	return nil
 }
+
+// sameRoles reports whether a and b hold the same roles in any order.
+func sameRoles(a, b []string) bool {
+	set := make(map[string]bool)
+	for _, r := range a {
+		set[r] = true
+	}
+	for _, r := range b {
+		if !set[r] {
+			return false
+		}
+	}
+
+	for _, r := range b {
+		delete(set, r)
+	}
+	return len(set) == 0
+}
+
+// actor returns the subject of the authenticated user making the request, or
+// an empty string for unauthenticated requests.
+func actor(ctx context.Context) string {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return ""
+	}
+	return claims.Subject
+}
+
+// includeDeleted reports whether the request asked for soft deleted records
+// with ?include_deleted=true. Only admins may ask for them.
+func includeDeleted(ctx context.Context, r *http.Request) (bool, error) {
+	if r.URL.Query().Get("include_deleted") != "true" {
+		return false, nil
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return false, web.ErrUnauthorized
+	}
+
+	if !claims.HasRole(auth.RoleAdmin) {
+		return false, web.ErrForbidden
+	}
+
+	return true, nil
+}
@@ -0,0 +1,98 @@
+package handlers_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/cmd/api/handlers"
+	"inventory-optimisation-server/internal/platform/auth"
+	"inventory-optimisation-server/internal/platform/db"
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+	"inventory-optimisation-server/internal/user"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// TestUserRoles validates only admins can change the roles of a user. It
+// needs a Mongo server, set TEST_DB_HOST to run it.
+func TestUserRoles(t *testing.T) {
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		t.Skip("TEST_DB_HOST is not set")
+	}
+
+	masterDB, err := db.New(host, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer masterDB.Close()
+
+	nu := user.NewUser{
+		Name:            "Anna Walker",
+		Email:           bson.NewObjectId().Hex() + "@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "correct horse battery staple",
+		PasswordConfirm: "correct horse battery staple",
+	}
+	usr, err := user.Create(context.Background(), masterDB, user.Hasher{}, &nu, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := usr.ID.Hex()
+
+	self := auth.NewClaims(id, []string{auth.RoleUser}, time.Now(), time.Hour)
+	admin := auth.NewClaims(bson.NewObjectId().Hex(), []string{auth.RoleAdmin}, time.Now(), time.Hour)
+
+	u := handlers.User{MasterDB: masterDB}
+
+	tests := []struct {
+		name        string
+		claims      auth.Claims
+		method      string
+		contentType string
+		body        string
+		err         error
+	}{
+		{"a user raising their own roles with a patch", self, "PATCH", web.MergePatch, `{"roles":["ADMIN"]}`, web.ErrForbidden},
+		{"a user raising their own roles with a replacement", self, "PUT", web.FormatJSON, `{"name":"Anna Walker","email":"` + nu.Email + `","roles":["USER","ADMIN"]}`, web.ErrForbidden},
+		{"a user changing their own name", self, "PATCH", web.MergePatch, `{"name":"Anna Smith"}`, nil},
+		{"an admin raising the user's roles", admin, "PATCH", web.MergePatch, `{"roles":["USER","ADMIN"]}`, nil},
+	}
+
+	t.Log("Given the need to keep users from changing their own roles.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen %s.", tt.name)
+			{
+				v := web.Values{Now: time.Now()}
+				ctx := context.WithValue(context.Background(), web.KeyValues, &v)
+				ctx = context.WithValue(ctx, auth.Key, tt.claims)
+
+				r := httptest.NewRequest(tt.method, "/v1/users/"+id, strings.NewReader(tt.body))
+				r.Header.Set("Content-Type", tt.contentType)
+
+				h := u.Update
+				if tt.method == "PATCH" {
+					h = u.Patch
+				}
+				err := h(ctx, log.New(ioutil.Discard, log.ErrorLevel), httptest.NewRecorder(), r, map[string]string{"id": id})
+				if errors.Cause(err) != tt.err {
+					t.Fatalf("\t%s\tShould get error %v : got %v.", failed, tt.err, err)
+				}
+				t.Logf("\t%s\tShould get error %v.", success, tt.err)
+			}
+		}
+	}
+}
@@ -3,19 +3,33 @@ package main
 import (
 	"context"
 	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"inventory-optimisation-server/cmd/api/handlers"
+	"inventory-optimisation-server/internal/mid"
+	"inventory-optimisation-server/internal/optimisationRequest"
 	"inventory-optimisation-server/internal/platform/auth"
+	"inventory-optimisation-server/internal/platform/certs"
 	"inventory-optimisation-server/internal/platform/db"
 	"inventory-optimisation-server/internal/platform/flag"
+	"inventory-optimisation-server/internal/platform/health"
+	"inventory-optimisation-server/internal/platform/idempotency"
+	"inventory-optimisation-server/internal/platform/lifecycle"
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/metrics"
+	"inventory-optimisation-server/internal/platform/oidc"
+	"inventory-optimisation-server/internal/platform/ratelimit"
+	"inventory-optimisation-server/internal/platform/secrets"
+	"inventory-optimisation-server/internal/platform/trace"
+	"inventory-optimisation-server/internal/user"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/kelseyhightower/envconfig"
@@ -29,157 +43,611 @@ func main() {
 	// =========================================================================
 	// Logging
 
-	log := log.New(os.Stdout, "API : ", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
+	log := log.New(os.Stdout, log.InfoLevel, "service", "api")
 
 	// =========================================================================
 	// Configuration
 
 	var cfg struct {
+		Log struct {
+			Level string `default:"info" envconfig:"LEVEL" flagdesc:"Minimum level to log: debug, info, warn or error."`
+		}
 		Web struct {
 			APIHost         string        `default:"0.0.0.0:3001" envconfig:"API_HOST"`
 			DebugHost       string        `default:"0.0.0.0:4000" envconfig:"DEBUG_HOST"`
 			ReadTimeout     time.Duration `default:"5s" envconfig:"READ_TIMEOUT"`
-			WriteTimeout    time.Duration `default:"5s" envconfig:"WRITE_TIMEOUT"`
+			HeaderTimeout   time.Duration `default:"2s" envconfig:"HEADER_TIMEOUT" flagdesc:"How long clients have to send the request headers."`
+			UploadTimeout   time.Duration `default:"60s" envconfig:"UPLOAD_TIMEOUT" flagdesc:"How long clients have to send and the API has to handle an optimisation input upload."`
+			HandlerTimeout  time.Duration `default:"5s" envconfig:"HANDLER_TIMEOUT" flagdesc:"How long the API has to handle a request, 0 for no limit."`
+			WriteTimeout    time.Duration `default:"5s" envconfig:"WRITE_TIMEOUT" flagdesc:"How long the API has to send a response once handled."`
 			ShutdownTimeout time.Duration `default:"5s" envconfig:"SHUTDOWN_TIMEOUT"`
+			RequireIfMatch  bool          `default:"false" envconfig:"REQUIRE_IF_MATCH" flagdesc:"Reject changes sent without an If-Match header."`
+			CompressMinSize int           `default:"1024" envconfig:"COMPRESS_MIN_SIZE" flagdesc:"Smallest response in bytes to compress, 0 disables compression."`
+			MaxBodySize     int           `default:"1048576" envconfig:"MAX_BODY_SIZE" flagdesc:"Largest request body in bytes, 0 for no limit."`
+			MaxUploadSize   int           `default:"33554432" envconfig:"MAX_UPLOAD_SIZE" flagdesc:"Largest optimisation input upload in bytes."`
+			UploadMemory    int           `default:"8388608" envconfig:"UPLOAD_MEMORY" flagdesc:"Bytes of an upload held in memory, the rest goes to temporary files."`
+			StrictDecoding  bool          `default:"false" envconfig:"STRICT_DECODING" flagdesc:"Reject request bodies with unknown fields."`
+			MaxHeaderBytes  int           `default:"1048576" envconfig:"MAX_HEADER_BYTES" flagdesc:"Largest size in bytes of all request headers together."`
+			MaxHeaderSize   int           `default:"8192" envconfig:"MAX_HEADER_SIZE" flagdesc:"Largest size in bytes of a single request header, 0 for no limit."`
+		}
+		Security struct {
+			HSTSMaxAge            time.Duration `default:"8760h" envconfig:"HSTS_MAX_AGE" flagdesc:"How long browsers must only use https, sent over TLS. 0 disables it."`
+			ContentSecurityPolicy string        `default:"default-src 'none'; frame-ancestors 'none'" envconfig:"CONTENT_SECURITY_POLICY"`
+			FrameOptions          string        `default:"DENY" envconfig:"FRAME_OPTIONS"`
+			ReferrerPolicy        string        `default:"no-referrer" envconfig:"REFERRER_POLICY"`
+		}
+		TLS struct {
+			CertFile       string        `envconfig:"CERT_FILE" flagdesc:"Certificate served by the API, PEM encoded. Empty serves plain HTTP."`
+			KeyFile        string        `envconfig:"KEY_FILE"`
+			MinVersion     string        `default:"1.2" envconfig:"MIN_VERSION" flagdesc:"Oldest TLS version accepted: 1.2 or 1.3."`
+			CipherSuites   string        `envconfig:"CIPHER_SUITES" flagdesc:"TLS 1.2 cipher suites as name,name. Empty uses the Go defaults."`
+			HTTP2          bool          `default:"true" envconfig:"HTTP2"`
+			ReloadInterval time.Duration `default:"1m" envconfig:"RELOAD_INTERVAL" flagdesc:"How often the certificate files are checked for changes."`
+			ClientAuth     string        `default:"none" envconfig:"CLIENT_AUTH" flagdesc:"Client certificates: none, optional or require."`
+			ClientCAFile   string        `envconfig:"CLIENT_CA_FILE" flagdesc:"Authorities client certificates are verified against, PEM encoded."`
+			ClientRoles    string        `envconfig:"CLIENT_ROLES" flagdesc:"Client certificate unit to role mapping as unit=ROLE,unit=ROLE."`
 		}
 		DB struct {
-			DialTimeout time.Duration `default:"5s" envconfig:"DIAL_TIMEOUT"`
-			Host        string        `default:"0.0.0.0:27017" envconfig:"HOST"`
+			DialTimeout   time.Duration `default:"5s" envconfig:"DIAL_TIMEOUT"`
+			Host          string        `default:"0.0.0.0:27017" envconfig:"HOST" secret:"true"`
+			PurgeAfter    time.Duration `default:"720h" envconfig:"PURGE_AFTER"`
+			PurgeInterval time.Duration `default:"1h" envconfig:"PURGE_INTERVAL"`
 		}
 		Auth struct {
 			KeyID          string `default:"xxx" envconfig:"KEY_ID"`
 			PrivateKeyFile string `default:"private.pem" envconfig:"PRIVATE_KEY_FILE"`
 			Algorithm      string `default:"RS256" envconfig:"ALGORITHM"`
+			HashAlgorithm  string `default:"bcrypt" envconfig:"HASH_ALGORITHM"`
+			BcryptCost     int    `default:"10" envconfig:"BCRYPT_COST"`
+			Argon2Time     int    `default:"1" envconfig:"ARGON2_TIME"`
+			Argon2Memory   int    `default:"65536" envconfig:"ARGON2_MEMORY"`
+			Argon2Threads  int    `default:"4" envconfig:"ARGON2_THREADS"`
+		}
+		Trace struct {
+			Exporter string `default:"none" envconfig:"EXPORTER" flagdesc:"Where to send spans: none, stdout or otlp-file."`
+			File     string `default:"traces.otlp.json" envconfig:"FILE"`
+			Service  string `default:"inventory-optimisation-server" envconfig:"SERVICE"`
+		}
+		OIDC struct {
+			Issuer         string `envconfig:"ISSUER"`
+			ClientID       string `envconfig:"CLIENT_ID"`
+			ClientSecret   string `envconfig:"CLIENT_SECRET" secret:"true"`
+			RedirectURL    string `envconfig:"REDIRECT_URL"`
+			Scopes         string `default:"openid email profile" envconfig:"SCOPES"`
+			GroupsClaim    string `default:"groups" envconfig:"GROUPS_CLAIM"`
+			GroupRoles     string `envconfig:"GROUP_ROLES" flagdesc:"Group to role mapping as group=ROLE,group=ROLE."`
+			CookieKey      string `envconfig:"COOKIE_KEY" secret:"true"`
+			InsecureCookie bool   `envconfig:"INSECURE_COOKIE"`
+		}
+		CORS struct {
+			AllowedOrigins   string        `envconfig:"ALLOWED_ORIGINS" flagdesc:"Origins browsers may call from as origin,origin, such as https://*.example.com. Empty disables CORS."`
+			AllowedMethods   string        `default:"GET,POST,PUT,PATCH,DELETE" envconfig:"ALLOWED_METHODS"`
+			AllowedHeaders   string        `default:"Authorization,Content-Type,If-Match,If-None-Match,Idempotency-Key,API-Version,X-Request-ID,traceparent" envconfig:"ALLOWED_HEADERS"`
+			AllowCredentials bool          `default:"false" envconfig:"ALLOW_CREDENTIALS"`
+			MaxAge           time.Duration `default:"10m" envconfig:"MAX_AGE" flagdesc:"How long browsers may cache a preflight response."`
+		}
+		Idempotency struct {
+			TTL  time.Duration `default:"24h" envconfig:"TTL" flagdesc:"How long responses to requests with an Idempotency-Key are kept."`
+			Wait time.Duration `default:"10s" envconfig:"WAIT"`
+		}
+		RateLimit struct {
+			Backend        string        `default:"memory" envconfig:"BACKEND" flagdesc:"Where to keep rate limit budgets: memory or mongo."`
+			Requests       int           `default:"600" envconfig:"REQUESTS"`
+			Period         time.Duration `default:"1m" envconfig:"PERIOD"`
+			LoginRequests  int           `default:"10" envconfig:"LOGIN_REQUESTS"`
+			LoginPeriod    time.Duration `default:"1m" envconfig:"LOGIN_PERIOD"`
+			SubmitRequests int           `default:"10" envconfig:"SUBMIT_REQUESTS"`
+			SubmitPeriod   time.Duration `default:"1m" envconfig:"SUBMIT_PERIOD"`
+		}
+		Password struct {
+			MinLength        int    `default:"10" envconfig:"MIN_LENGTH"`
+			MaxLength        int    `default:"72" envconfig:"MAX_LENGTH"`
+			RequireUpper     bool   `default:"true" envconfig:"REQUIRE_UPPER"`
+			RequireLower     bool   `default:"true" envconfig:"REQUIRE_LOWER"`
+			RequireDigit     bool   `default:"true" envconfig:"REQUIRE_DIGIT"`
+			RequireSymbol    bool   `default:"false" envconfig:"REQUIRE_SYMBOL"`
+			DisallowEmail    bool   `default:"true" envconfig:"DISALLOW_EMAIL"`
+			BreachedListFile string `envconfig:"BREACHED_LIST_FILE" flagdesc:"File of breached SHA-1 password hashes sorted by hash, such as the Pwned Passwords download ordered by hash."`
+		}
+		Secrets struct {
+			Provider   string `default:"env" envconfig:"PROVIDER" flagdesc:"Where secrets not given in the environment or a _FILE are kept: env, file or vault."`
+			Dir        string `default:"/run/secrets" envconfig:"DIR" flagdesc:"Directory of the file provider, one file per secret such as oidc_client_secret."`
+			VaultAddr  string `envconfig:"VAULT_ADDR"`
+			VaultToken string `envconfig:"VAULT_TOKEN" secret:"true"`
+			VaultMount string `default:"secret" envconfig:"VAULT_MOUNT"`
+			VaultPath  string `default:"inventory-optimisation-server" envconfig:"VAULT_PATH"`
 		}
 	}
 
 	if err := envconfig.Process("API", &cfg); err != nil {
-		log.Fatalf("main : Parsing Config : %v", err)
+		log.Fatal("parsing config", "error", err)
 	}
 
 	if err := flag.Process(&cfg); err != nil {
 		if err != flag.ErrHelp {
-			log.Fatalf("main : Parsing Command Line : %v", err)
+			log.Fatal("parsing command line", "error", err)
 		}
 		return // We displayed help.
 	}
 
+	if cfg.DB.PurgeInterval <= 0 {
+		log.Fatal("purge interval must be greater than zero", "interval", cfg.DB.PurgeInterval)
+	}
+
+	// Secrets can also be read from files named by API_<NAME>_FILE, which
+	// is how the Vault token itself is given, then from the provider.
+	if err := secrets.Load(context.Background(), nil, "API", &cfg); err != nil {
+		log.Fatal("loading secrets", "error", err)
+	}
+
+	var secretProvider secrets.Provider
+	switch cfg.Secrets.Provider {
+	case "env", "":
+		secretProvider = secrets.Env{Prefix: "API"}
+	case "file":
+		secretProvider = secrets.File{Dir: cfg.Secrets.Dir}
+	case "vault":
+		secretProvider = secrets.Vault{
+			Addr:   cfg.Secrets.VaultAddr,
+			Token:  cfg.Secrets.VaultToken,
+			Mount:  cfg.Secrets.VaultMount,
+			Path:   cfg.Secrets.VaultPath,
+			Client: &http.Client{Timeout: 10 * time.Second},
+		}
+	default:
+		log.Fatal("unknown secrets provider", "provider", cfg.Secrets.Provider)
+	}
+
+	if err := secrets.Load(context.Background(), secretProvider, "API", &cfg); err != nil {
+		log.Fatal("loading secrets", "error", err)
+	}
+
 	// =========================================================================
 	// App Starting
 
-	log.Printf("main : Started : Application Initializing version %q", build)
-	defer log.Println("main : Completed")
+	if err := log.SetLevelName(cfg.Log.Level); err != nil {
+		log.Fatal("parsing log level", "error", err)
+	}
+
+	log.Info("application initializing", "version", build)
+	defer log.Info("application completed")
 
-	cfgJSON, err := json.MarshalIndent(cfg, "", "    ")
+	// Long running components register with the lifecycle manager, which
+	// stops them in reverse order on shutdown.
+	lc := lifecycle.New(log)
+
+	cfgJSON, err := json.Marshal(secrets.Mask(cfg))
 	if err != nil {
-		log.Fatalf("main : Marshalling Config to JSON : %v", err)
+		log.Fatal("marshalling config to JSON", "error", err)
 	}
+	log.Info("config", "config", json.RawMessage(cfgJSON))
 
-	// TODO: Validate what is being written to the logs. We don't
-	// want to leak credentials or anything that can be a security risk.
-	log.Printf("main : Config : %v\n", string(cfgJSON))
+	// =========================================================================
+	// Start Tracing Support
+
+	switch cfg.Trace.Exporter {
+	case "none", "":
+	case "stdout":
+		trace.RegisterExporter(trace.NewStdoutExporter(os.Stdout))
+	case "otlp-file":
+		f, err := os.OpenFile(cfg.Trace.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal("opening trace file", "error", err)
+		}
+		defer f.Close()
+		trace.RegisterExporter(trace.NewOTLPFileExporter(f, cfg.Trace.Service))
+	default:
+		log.Fatal("unknown trace exporter", "exporter", cfg.Trace.Exporter)
+	}
 
 	// =========================================================================
 	// Find auth keys
 
 	keyContents, err := ioutil.ReadFile(cfg.Auth.PrivateKeyFile)
 	if err != nil {
-		log.Fatalf("main : Reading auth private key : %v", err)
+		log.Fatal("reading auth private key", "error", err)
 	}
 
 	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyContents)
 	if err != nil {
-		log.Fatalf("main : Parsing auth private key : %v", err)
+		log.Fatal("parsing auth private key", "error", err)
 	}
 
 	publicKeyLookup := auth.NewSingleKeyFunc(cfg.Auth.KeyID, key.Public().(*rsa.PublicKey))
 
 	authenticator, err := auth.NewAuthenticator(key, cfg.Auth.KeyID, cfg.Auth.Algorithm, publicKeyLookup)
 	if err != nil {
-		log.Fatalf("main : Constructing authenticator : %v", err)
+		log.Fatal("constructing authenticator", "error", err)
+	}
+
+	// =========================================================================
+	// Password hashing and policy
+
+	hasher := user.Hasher{
+		Algorithm:  cfg.Auth.HashAlgorithm,
+		BcryptCost: cfg.Auth.BcryptCost,
+		Argon2: user.Argon2Params{
+			Time:    uint32(cfg.Auth.Argon2Time),
+			Memory:  uint32(cfg.Auth.Argon2Memory),
+			Threads: uint8(cfg.Auth.Argon2Threads),
+		},
+	}
+
+	policy := user.PasswordPolicy{
+		MinLength:     cfg.Password.MinLength,
+		MaxLength:     cfg.Password.MaxLength,
+		RequireUpper:  cfg.Password.RequireUpper,
+		RequireLower:  cfg.Password.RequireLower,
+		RequireDigit:  cfg.Password.RequireDigit,
+		RequireSymbol: cfg.Password.RequireSymbol,
+		DisallowEmail: cfg.Password.DisallowEmail,
+	}
+
+	if cfg.Password.BreachedListFile != "" {
+		policy.Breached, err = user.NewHashList(cfg.Password.BreachedListFile)
+		if err != nil {
+			log.Fatal("loading breached password list", "error", err)
+		}
 	}
 
 	// =========================================================================
 	// Start Mongo
 
-	log.Println("main : Started : Initialize Mongo")
+	log.Info("initializing mongo", "host", secrets.MaskURL(cfg.DB.Host))
 	masterDB, err := db.New(cfg.DB.Host, cfg.DB.DialTimeout)
 	if err != nil {
-		log.Fatalf("main : Register DB : %v", err)
+		log.Fatal("registering DB", "error", err)
+	}
+
+	// Mongo is added first so it is the last to go.
+	lc.Add(lifecycle.Component{
+		Name: "mongo",
+		Stop: func(ctx context.Context) error {
+			masterDB.Close()
+			return nil
+		},
+	})
+
+	// =========================================================================
+	// Health checks
+
+	// Subsystems register what they need to be alive or ready to serve.
+	checks := health.NewRegistry()
+
+	checks.AddReadiness("mongo", cfg.DB.DialTimeout, masterDB.StatusCheck)
+	checks.AddReadiness("auth_keys", 0, func(ctx context.Context) error {
+		return key.Validate()
+	})
+
+	// =========================================================================
+	// Rate limiting
+
+	limits := handlers.Limits{
+		Default: ratelimit.Rate{Limit: cfg.RateLimit.Requests, Period: cfg.RateLimit.Period},
+		Login:   ratelimit.Rate{Limit: cfg.RateLimit.LoginRequests, Period: cfg.RateLimit.LoginPeriod},
+		Submit:  ratelimit.Rate{Limit: cfg.RateLimit.SubmitRequests, Period: cfg.RateLimit.SubmitPeriod},
+	}
+
+	// Budgets kept in memory are per instance. Keep them in Mongo to share
+	// them when running several instances.
+	switch cfg.RateLimit.Backend {
+	case "memory":
+		limits.Store = ratelimit.NewMemoryStore()
+	case "mongo":
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.DB.DialTimeout)
+		store, err := ratelimit.NewMongoStore(ctx, masterDB)
+		cancel()
+		if err != nil {
+			log.Fatal("creating rate limit store", "error", err)
+		}
+		limits.Store = store
+	default:
+		log.Fatal("unknown rate limit backend", "backend", cfg.RateLimit.Backend)
+	}
+
+	// =========================================================================
+	// Idempotency
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DB.DialTimeout)
+	keys, err := idempotency.NewMongoStore(ctx, masterDB)
+	cancel()
+	if err != nil {
+		log.Fatal("creating idempotency store", "error", err)
+	}
+
+	idem := mid.Idempotency{
+		Store:     keys,
+		TTL:       cfg.Idempotency.TTL,
+		Wait:      cfg.Idempotency.Wait,
+		MaxMemory: int64(cfg.Web.UploadMemory),
+	}
+
+	// =========================================================================
+	// Request bodies
+
+	bodies := handlers.BodyLimits{
+		Default:      int64(cfg.Web.MaxBodySize),
+		Upload:       int64(cfg.Web.MaxUploadSize),
+		UploadMemory: int64(cfg.Web.UploadMemory),
+	}
+
+	// =========================================================================
+	// Timeouts
+
+	timeouts := handlers.Timeouts{
+		Read:   cfg.Web.ReadTimeout,
+		Handle: cfg.Web.HandlerTimeout,
+		Write:  cfg.Web.WriteTimeout,
+		Upload: cfg.Web.UploadTimeout,
 	}
-	defer masterDB.Close()
+
+	// =========================================================================
+	// Security headers
+
+	security := mid.Security{
+		HSTSMaxAge:            cfg.Security.HSTSMaxAge,
+		ContentSecurityPolicy: cfg.Security.ContentSecurityPolicy,
+		FrameOptions:          cfg.Security.FrameOptions,
+		ReferrerPolicy:        cfg.Security.ReferrerPolicy,
+		MaxHeaderSize:         cfg.Web.MaxHeaderSize,
+	}
+
+	// =========================================================================
+	// Cross-origin requests
+
+	cors := mid.CORS{
+		Origins:     splitList(cfg.CORS.AllowedOrigins),
+		Methods:     splitList(cfg.CORS.AllowedMethods),
+		Headers:     splitList(cfg.CORS.AllowedHeaders),
+		Credentials: cfg.CORS.AllowCredentials,
+		MaxAge:      cfg.CORS.MaxAge,
+	}
+	if err := cors.Validate(); err != nil {
+		log.Fatal("invalid cors settings", "error", err)
+	}
+
+	// =========================================================================
+	// Compression
+
+	compress := mid.Compress{
+		MinSize: cfg.Web.CompressMinSize,
+	}
+
+	// =========================================================================
+	// Single sign-on
+
+	var sso *handlers.OIDC
+	if cfg.OIDC.Issuer != "" {
+		log.Info("discovering OIDC provider", "issuer", cfg.OIDC.Issuer)
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ReadTimeout)
+		provider, err := oidc.NewProvider(ctx, nil, oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       strings.Fields(cfg.OIDC.Scopes),
+			GroupsClaim:  cfg.OIDC.GroupsClaim,
+		})
+		cancel()
+		if err != nil {
+			log.Fatal("discovering OIDC provider", "error", err)
+		}
+
+		groupRoles, err := auth.ParseRoleMapping(cfg.OIDC.GroupRoles)
+		if err != nil {
+			log.Fatal("parsing OIDC group roles", "error", err)
+		}
+
+		// Without a configured key the login state cookie can only be opened
+		// by this instance.
+		cookieKey := []byte(cfg.OIDC.CookieKey)
+		if len(cookieKey) == 0 {
+			log.Warn("no OIDC cookie key configured, generating one for this instance")
+			k, err := oidc.RandomString()
+			if err != nil {
+				log.Fatal("generating OIDC cookie key", "error", err)
+			}
+			cookieKey = []byte(k)
+		}
+
+		sso = &handlers.OIDC{
+			MasterDB:       masterDB,
+			Provider:       provider,
+			TokenGenerator: authenticator,
+			CookieKey:      cookieKey,
+			GroupRoles:     groupRoles,
+			InsecureCookie: cfg.OIDC.InsecureCookie,
+		}
+	}
+
+	// =========================================================================
+	// TLS
+
+	var (
+		tlsConfig *tls.Config
+		certRoles map[string]string
+	)
+	if cfg.TLS.CertFile != "" {
+		var reloader *certs.Reloader
+		tlsConfig, reloader, err = certs.New(certs.Config{
+			CertFile:     cfg.TLS.CertFile,
+			KeyFile:      cfg.TLS.KeyFile,
+			MinVersion:   cfg.TLS.MinVersion,
+			CipherSuites: splitList(cfg.TLS.CipherSuites),
+			ClientAuth:   cfg.TLS.ClientAuth,
+			ClientCAFile: cfg.TLS.ClientCAFile,
+			HTTP2:        cfg.TLS.HTTP2,
+		})
+		if err != nil {
+			log.Fatal("configuring TLS", "error", err)
+		}
+
+		// Clients are only authenticated by their certificate when there is
+		// a mapping to give them roles.
+		if cfg.TLS.ClientAuth != certs.ClientAuthNone && cfg.TLS.ClientRoles != "" {
+			if certRoles, err = auth.ParseRoleMapping(cfg.TLS.ClientRoles); err != nil {
+				log.Fatal("parsing client certificate roles", "error", err)
+			}
+		}
+
+		// Renewed certificates are picked up without a restart, straight
+		// away on SIGHUP.
+		reload := func() error {
+			reloaded, err := reloader.Reload()
+			if reloaded {
+				log.Info("reloaded certificate", "file", cfg.TLS.CertFile)
+			}
+			return err
+		}
+		lc.OnReload("certificate", reload)
+
+		if cfg.TLS.ReloadInterval > 0 {
+			lc.Add(lifecycle.Every("certificate reload", cfg.TLS.ReloadInterval, func(time.Time) {
+				if err := reload(); err != nil {
+					log.Error("reloading certificate", "error", err)
+				}
+			}))
+		}
+	}
+
+	// =========================================================================
+	// Start Purge Service
+
+	// Soft deleted users and optimisation requests are removed for good once
+	// they have been deleted for longer than the retention window.
+	log.Info("purging deleted records", "after", cfg.DB.PurgeAfter, "interval", cfg.DB.PurgeInterval)
+
+	// The purge loop is considered stuck when it misses two runs in a row.
+	var purgeBeat health.Heartbeat
+	purgeBeat.Beat(time.Now())
+	checks.AddLiveness("purge", 0, purgeBeat.Check(2*cfg.DB.PurgeInterval+time.Minute))
+
+	// A purge under way when shutting down is given time to finish.
+	lc.Add(lifecycle.Every("purge", cfg.DB.PurgeInterval, func(now time.Time) {
+		purge(log, masterDB, now.Add(-cfg.DB.PurgeAfter))
+		purgeBeat.Beat(time.Now())
+	}))
 
 	// =========================================================================
 	// Start Debug Service
 
 	// /debug/vars - Added to the default mux by the expvars package.
 	// /debug/pprof - Added to the default mux by the net/http/pprof package.
+	// /debug/loglevel - Reports and changes the log level at runtime.
+	// /metrics - Prometheus scrape endpoint.
+
+	http.Handle("/debug/loglevel", log.LevelHandler())
+	http.Handle("/metrics", metrics.Handler())
 
 	debug := http.Server{
-		Addr:           cfg.Web.DebugHost,
-		Handler:        http.DefaultServeMux,
-		ReadTimeout:    cfg.Web.ReadTimeout,
-		WriteTimeout:   cfg.Web.WriteTimeout,
-		MaxHeaderBytes: 1 << 20,
+		Addr:              cfg.Web.DebugHost,
+		Handler:           http.DefaultServeMux,
+		ReadTimeout:       cfg.Web.ReadTimeout,
+		ReadHeaderTimeout: cfg.Web.HeaderTimeout,
+		WriteTimeout:      cfg.Web.WriteTimeout,
+		MaxHeaderBytes:    cfg.Web.MaxHeaderBytes,
 	}
 
-	// Not concerned with shutting this down when the
-	// application is being shutdown.
-	go func() {
-		log.Printf("main : Debug Listening %s", cfg.Web.DebugHost)
-		log.Printf("main : Debug Listener closed : %v", debug.ListenAndServe())
-	}()
+	log.Info("debug listening", "host", cfg.Web.DebugHost)
+	lc.Add(lifecycle.Server("debug", &debug, cfg.Web.ShutdownTimeout))
 
 	// =========================================================================
 	// Start API Service
 
-	api := http.Server{
-		Addr:           cfg.Web.APIHost,
-		Handler:        handlers.API(log, masterDB, authenticator),
-		ReadTimeout:    cfg.Web.ReadTimeout,
-		WriteTimeout:   cfg.Web.WriteTimeout,
-		MaxHeaderBytes: 1 << 20,
+	routes := handlers.Config{
+		MasterDB:         masterDB,
+		Checks:           checks,
+		Authenticator:    authenticator,
+		CertificateRoles: certRoles,
+		PasswordPolicy:   policy,
+		Hasher:           hasher,
+		RequireIfMatch:   cfg.Web.RequireIfMatch,
+		Limits:           limits,
+		Bodies:           bodies,
+		StrictDecoding:   cfg.Web.StrictDecoding,
+		Timeouts:         timeouts,
+		Idempotency:      &idem,
+		CORS:             &cors,
+		Compress:         &compress,
+		Security:         &security,
+		SSO:              sso,
 	}
 
-	// Make a channel to listen for errors coming from the listener. Use a
-	// buffered channel so the goroutine can exit if we don't collect this error.
-	serverErrors := make(chan error, 1)
+	api := http.Server{
+		Addr:              cfg.Web.APIHost,
+		Handler:           handlers.API(log, routes),
+		ReadTimeout:       cfg.Web.ReadTimeout,
+		ReadHeaderTimeout: cfg.Web.HeaderTimeout,
+		WriteTimeout:      cfg.Web.WriteTimeout,
+		MaxHeaderBytes:    cfg.Web.MaxHeaderBytes,
+	}
 
-	// // Start the service listening for requests.
-	go func() {
-		log.Printf("main : API Listening %s", cfg.Web.APIHost)
-		serverErrors <- api.ListenAndServe()
-	}()
+	// Serve TLS when a certificate is configured. The certificate is
+	// served from memory so it is read once rather than by ListenAndServeTLS.
+	if tlsConfig != nil {
+		api.TLSConfig = tlsConfig
 
-	// =========================================================================
-	// Shutdown
+		// A non-nil map stops the server from offering HTTP/2.
+		if !cfg.TLS.HTTP2 {
+			api.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+	}
 
-	// Make a channel to listen for an interrupt or terminate signal from the OS.
-	// Use a buffered channel because the signal package requires it.
-	osSignals := make(chan os.Signal, 1)
-	signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM)
+	// The API is added last so it stops taking requests before anything it
+	// relies on goes.
+	log.Info("API listening", "host", cfg.Web.APIHost, "tls", tlsConfig != nil, "client_auth", cfg.TLS.ClientAuth)
+	lc.Add(lifecycle.Server("api", &api, cfg.Web.ShutdownTimeout))
 
 	// =========================================================================
-	// Stop API Service
+	// Run until shutdown
+
+	// Make a channel to listen for an interrupt or terminate signal from the
+	// OS, and for SIGHUP asking to reload the certificate. Nothing else is
+	// reloaded: settings and secrets, including those in _FILE files, the
+	// secrets directory or Vault, are read once at startup so rotating them
+	// needs a restart. The log level can be changed at /debug/loglevel. Use
+	// a buffered channel because the signal package requires it.
+	osSignals := make(chan os.Signal, 1)
+	signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	// Blocking main and waiting for shutdown.
-	select {
-	case err := <-serverErrors:
-		log.Fatalf("main : Error starting server: %v", err)
-
-	case <-osSignals:
-		log.Println("main : Start shutdown...")
-
-		// Create context for Shutdown call.
-		ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
-		defer cancel()
-
-		// Asking listener to shutdown and load shed.
-		if err := api.Shutdown(ctx); err != nil {
-			log.Printf("main : Graceful shutdown did not complete in %v : %v", cfg.Web.ShutdownTimeout, err)
-			if err := api.Close(); err != nil {
-				log.Fatalf("main : Could not stop http server: %v", err)
-			}
+	if err := lc.Run(osSignals); err != nil {
+		log.Fatal("shutting down", "error", err)
+	}
+}
+
+// purge permanently removes users and optimisation requests that were soft
+// deleted before the specified time.
+func purge(log *log.Logger, masterDB *db.DB, before time.Time) {
+	dbConn := masterDB.Copy()
+	defer dbConn.Close()
+
+	ctx := context.Background()
+
+	n, err := user.Purge(ctx, dbConn, before)
+	if err != nil {
+		log.Error("purging users", "error", err)
+	} else if n > 0 {
+		log.Info("purged users", "count", n, "deleted_before", before)
+	}
+
+	n, err = optimisationRequest.Purge(ctx, dbConn, before)
+	if err != nil {
+		log.Error("purging optimisation requests", "error", err)
+	} else if n > 0 {
+		log.Info("purged optimisation requests", "count", n, "deleted_before", before)
+	}
+}
+
+// splitList splits a comma separated configuration value, dropping empty
+// entries.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
 		}
 	}
+	return list
 }
@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/db"
+
+	"github.com/pkg/errors"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const auditCollection = "audit"
+
+// These bound the number of events returned by a single List call.
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// FromRequest returns a NewEvent populated with the details of the client
+// making the request. The caller fills in the actor, action, target and
+// outcome.
+func FromRequest(r *http.Request, requestID string) NewEvent {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	ne := NewEvent{
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+		RequestID: requestID,
+	}
+
+	return ne
+}
+
+// Record appends an event to the audit trail. Events are never updated or
+// removed once recorded.
+func Record(ctx context.Context, dbConn *db.DB, ne NewEvent, now time.Time) (*Event, error) {
+
+	// Mongo truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	e := Event{
+		ID:        bson.NewObjectId(),
+		Actor:     ne.Actor,
+		Action:    ne.Action,
+		Target:    ne.Target,
+		IP:        ne.IP,
+		UserAgent: ne.UserAgent,
+		Outcome:   ne.Outcome,
+		RequestID: ne.RequestID,
+		Date:      now,
+	}
+
+	f := func(collection *mgo.Collection) error {
+		return collection.Insert(&e)
+	}
+	if err := dbConn.ExecuteWrite(ctx, auditCollection, f); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.audit.insert(%s)", db.Query(&e)))
+	}
+
+	return &e, nil
+}
+
+// List retrieves the events matching the filter, newest first.
+func List(ctx context.Context, dbConn *db.DB, flt Filter) ([]Event, error) {
+
+	q := bson.M{}
+	if flt.Actor != "" {
+		q["actor"] = flt.Actor
+	}
+	if flt.Action != "" {
+		q["action"] = flt.Action
+	}
+
+	date := bson.M{}
+	if !flt.Since.IsZero() {
+		date["$gte"] = flt.Since
+	}
+	if !flt.Until.IsZero() {
+		date["$lt"] = flt.Until
+	}
+	if len(date) > 0 {
+		q["date"] = date
+	}
+
+	limit := flt.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	e := []Event{}
+
+	f := func(collection *mgo.Collection) error {
+		return collection.Find(q).Sort("-date").Limit(limit).All(&e)
+	}
+	if err := dbConn.Execute(ctx, auditCollection, f); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.audit.find(%s)", db.Query(q)))
+	}
+
+	return e, nil
+}
@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// These are the actions recorded in the audit trail.
+const (
+	ActionLogin               = "login"
+	ActionLoginFailed         = "login_failed"
+	ActionTokenIssue          = "token_issue"
+	ActionUserCreate          = "user_create"
+	ActionUserUpdate          = "user_update"
+	ActionUserDelete          = "user_delete"
+	ActionUserRestore         = "user_restore"
+	ActionOptimisationSubmit  = "optimisation_submit"
+	ActionOptimisationUpdate  = "optimisation_update"
+	ActionOptimisationDelete  = "optimisation_delete"
+	ActionOptimisationRestore = "optimisation_restore"
+)
+
+// These are the possible outcomes of an audited action.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is a single entry in the audit trail.
+type Event struct {
+	ID        bson.ObjectId `bson:"_id" json:"id"`
+	Actor     string        `bson:"actor" json:"actor"`
+	Action    string        `bson:"action" json:"action"`
+	Target    string        `bson:"target,omitempty" json:"target,omitempty"`
+	IP        string        `bson:"ip" json:"ip"`
+	UserAgent string        `bson:"user_agent" json:"user_agent"`
+	Outcome   string        `bson:"outcome" json:"outcome"`
+	RequestID string        `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	Date      time.Time     `bson:"date" json:"date"`
+}
+
+// NewEvent contains the information needed to record an Event.
+type NewEvent struct {
+	Actor     string
+	Action    string
+	Target    string
+	IP        string
+	UserAgent string
+	Outcome   string
+	RequestID string
+}
+
+// Filter narrows the events returned by List. Zero values are ignored.
+type Filter struct {
+	Actor  string
+	Action string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
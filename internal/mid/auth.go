@@ -2,11 +2,12 @@ package mid
 
 import (
 	"context"
-	"log"
+	"crypto/x509"
 	"net/http"
 	"strings"
 
 	"inventory-optimisation-server/internal/platform/auth"
+	"inventory-optimisation-server/internal/platform/log"
 	"inventory-optimisation-server/internal/platform/web"
 
 	"github.com/pkg/errors"
@@ -15,28 +16,51 @@ import (
 // Auth is used to authenticate and authorize HTTP requests.
 type Auth struct {
 	Authenticator *auth.Authenticator
+
+	// CertificateRoles maps the organizational units of verified client
+	// certificates to roles. Clients are not authenticated by their
+	// certificate when it is nil.
+	CertificateRoles map[string]string
 }
 
-// Authenticate validates a JWT from the `Authorization` header.
+// Authenticate validates a JWT from the `Authorization` header. Requests
+// without the header are authenticated by their client certificate when
+// certificates are accepted.
 func (a *Auth) Authenticate(next web.Handler) web.Handler {
 	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		var claims auth.Claims
+
 		authHdr := r.Header.Get("Authorization")
-		if authHdr == "" {
+		cert := a.certificate(r)
+		switch {
+		case authHdr != "":
+			tknStr, err := parseAuthHeader(authHdr)
+			if err != nil {
+				return errors.Wrap(web.ErrUnauthorized, err.Error())
+			}
+
+			if claims, err = a.Authenticator.ParseClaims(tknStr); err != nil {
+				return errors.Wrap(web.ErrUnauthorized, err.Error())
+			}
+
+		case cert != nil:
+			v := ctx.Value(web.KeyValues).(*web.Values)
+
+			var err error
+			if claims, err = auth.NewCertificateClaims(cert, a.CertificateRoles, v.Now); err != nil {
+				return errors.Wrap(web.ErrUnauthorized, err.Error())
+			}
+
+		default:
 			return errors.Wrap(web.ErrUnauthorized, "Missing Authorization header")
 		}
 
-		tknStr, err := parseAuthHeader(authHdr)
-		if err != nil {
-			return errors.Wrap(web.ErrUnauthorized, err.Error())
-		}
-
-		claims, err := a.Authenticator.ParseClaims(tknStr)
-		if err != nil {
-			return errors.Wrap(web.ErrUnauthorized, err.Error())
-		}
-
-		// Add claims to the context so they can be retrieved later.
+		// Add claims to the context so they can be retrieved later and tag
+		// everything logged from here on with the user. Middleware running
+		// before us, such as RequestLogger, finds the user in the values.
 		ctx = context.WithValue(ctx, auth.Key, claims)
+		log = log.With("user_id", claims.Subject)
+		ctx.Value(web.KeyValues).(*web.Values).UserID = claims.Subject
 
 		return next(ctx, log, w, r, params)
 	}
@@ -44,6 +68,32 @@ func (a *Auth) Authenticate(next web.Handler) web.Handler {
 	return h
 }
 
+// Identify behaves like Authenticate when an `Authorization` header or
+// client certificate is sent but lets anonymous requests through. It is
+// used on public routes that behave differently for authenticated users.
+func (a *Auth) Identify(next web.Handler) web.Handler {
+	authenticated := a.Authenticate(next)
+
+	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		if r.Header.Get("Authorization") == "" && a.certificate(r) == nil {
+			return next(ctx, log, w, r, params)
+		}
+
+		return authenticated(ctx, log, w, r, params)
+	}
+
+	return h
+}
+
+// certificate returns the client certificate the request was made with once
+// the TLS stack has verified it, or nil.
+func (a *Auth) certificate(r *http.Request) *x509.Certificate {
+	if a.CertificateRoles == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
 // parseAuthHeader parses an authorization header. Expected header is of
 // the format `Bearer <token>`.
 func parseAuthHeader(bearerStr string) (string, error) {
@@ -57,23 +107,24 @@ func parseAuthHeader(bearerStr string) (string, error) {
 
 // HasRole validates that an authenticated user has at least one role from a
 // specified list. This method constructs the actual function that is used.
-// func (a *Auth) HasRole(roles inventory-optimisation-server/internal.string) func(next web.Handler) web.Handler {
-// 	mw := func(next web.Handler) web.Handler {
-// 		h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
-
-// 			claims, ok := ctx.Value(auth.Key).(auth.Claims)
-// 			if !ok {
-// 				return web.ErrUnauthorized
-// 			}
-
-// 			if !claims.HasRole(rolesinventory-optimisation-server/internal.) {
-// 				return web.ErrForbidden
-// 			}
-
-// 			return next(ctx, log, w, r, params)
-// 		}
-
-// 		return h
-// 	}
-// 	return mw
-// }
+func (a *Auth) HasRole(roles ...string) web.Middleware {
+	mw := func(next web.Handler) web.Handler {
+		h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return web.ErrUnauthorized
+			}
+
+			if !claims.HasRole(roles...) {
+				return web.ErrForbidden
+			}
+
+			return next(ctx, log, w, r, params)
+		}
+
+		return h
+	}
+
+	return mw
+}
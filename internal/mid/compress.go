@@ -0,0 +1,252 @@
+package mid
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+)
+
+// encodings are the content codings Compress can apply in order of
+// preference.
+var encodings = []string{"gzip", "deflate", "identity"}
+
+// Compressors are reused as each one allocates large buffers.
+var (
+	gzipPool = sync.Pool{New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}}
+	flatePool = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+)
+
+// tagSuffixes removes the suffixes added to the ETags of compressed
+// responses.
+var tagSuffixes = strings.NewReplacer(`-gzip"`, `"`, `-deflate"`, `"`)
+
+// Compress compresses responses with gzip or deflate when the client
+// accepts them. Responses smaller than MinSize are sent as they are since
+// compressing them saves little and costs a round of CPU. A MinSize of zero
+// or less disables compression.
+//
+// A compressed response is a different representation so its ETag gets the
+// coding as a suffix. The suffix is removed from tags clients send back in
+// If-Match and If-None-Match before handlers compare them.
+type Compress struct {
+	MinSize int
+}
+
+// Handle compresses the responses of the next handler. It must run outside
+// ErrorHandler so errors and panics are written through it.
+func (c *Compress) Handle(next web.Handler) web.Handler {
+	if c.MinSize <= 0 {
+		return next
+	}
+
+	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		for _, k := range []string{"If-Match", "If-None-Match"} {
+			if v := r.Header.Get(k); v != "" {
+				r.Header.Set(k, tagSuffixes.Replace(v))
+			}
+		}
+
+		// Clients that say nothing about encodings get none.
+		ae := r.Header.Get("Accept-Encoding")
+		if ae == "" || r.Method == http.MethodHead {
+			return next(ctx, log, w, r, params)
+		}
+
+		enc, _ := web.Negotiate(ae, encodings)
+		if enc == "" || enc == "identity" {
+			return next(ctx, log, w, r, params)
+		}
+
+		cw := compressWriter{
+			ResponseWriter: w,
+			encoding:       enc,
+			minSize:        c.MinSize,
+		}
+		defer cw.Close()
+
+		return next(ctx, log, &cw, r, params)
+	}
+
+	return h
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// the response is large enough to compress.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+
+	// w compresses the body once the response has been found large enough.
+	w io.WriteCloser
+}
+
+// WriteHeader implements the http.ResponseWriter interface. The status is
+// sent along with the first part of the body.
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+
+	// These responses have no body to wait for.
+	if status == http.StatusNoContent || status == http.StatusNotModified || status < http.StatusOK {
+		cw.start(false)
+	}
+}
+
+// Write implements the http.ResponseWriter interface.
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.decided {
+		if cw.w != nil {
+			return cw.w.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// start sends the status, compressed if compress is set and the content
+// suits it, followed by the body held back so far.
+func (cw *compressWriter) start(compress bool) error {
+	cw.decided = true
+
+	h := cw.Header()
+	if compress && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); strings.HasSuffix(etag, `"`) {
+			h.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+cw.encoding+`"`)
+		}
+
+		switch cw.encoding {
+		case "gzip":
+			w := gzipPool.Get().(*gzip.Writer)
+			w.Reset(cw.ResponseWriter)
+			cw.w = w
+		case "deflate":
+			w := flatePool.Get().(*flate.Writer)
+			w.Reset(cw.ResponseWriter)
+			cw.w = w
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.w != nil {
+		_, err = cw.w.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// Flush implements the http.Flusher interface. A response flushed before it
+// reaches the minimum size is compressed since more of it is coming.
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.start(true)
+	}
+
+	switch w := cw.w.(type) {
+	case *gzip.Writer:
+		w.Flush()
+	case *flate.Writer:
+		w.Flush()
+	}
+
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close sends anything held back and finishes the compressed stream.
+func (cw *compressWriter) Close() error {
+
+	// A response that never reached the minimum size is sent as it is. One
+	// with no status at all is left for the server to answer.
+	if !cw.decided && cw.status != 0 {
+		cw.start(false)
+	}
+
+	if cw.w == nil {
+		return nil
+	}
+
+	err := cw.w.Close()
+	switch w := cw.w.(type) {
+	case *gzip.Writer:
+		gzipPool.Put(w)
+	case *flate.Writer:
+		flatePool.Put(w)
+	}
+	cw.w = nil
+
+	return err
+}
+
+// compressible reports whether content of the type shrinks when
+// compressed. Archives and images are already compressed.
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		strings.HasSuffix(mt, "+json"),
+		strings.HasSuffix(mt, "+xml"),
+		mt == "application/json",
+		mt == "application/xml",
+		mt == "application/javascript",
+		mt == web.FormatMsgpack,
+		mt == "application/x-msgpack":
+		return true
+	}
+
+	return false
+}
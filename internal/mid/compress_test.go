@@ -0,0 +1,129 @@
+package mid_test
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"inventory-optimisation-server/internal/mid"
+	"inventory-optimisation-server/internal/platform/log"
+)
+
+// TestCompress validates responses are compressed when the client accepts
+// it and they are worth compressing.
+func TestCompress(t *testing.T) {
+	large := `{"name":"` + strings.Repeat("a", 200) + `"}`
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		body        string
+		encoding    string
+		etag        string
+	}{
+		{"a large JSON response", "gzip, deflate", "application/json", large, "gzip", `"1-gzip"`},
+		{"a small JSON response", "gzip", "application/json", `{}`, "", `"1"`},
+		{"a large image", "gzip", "image/png", large, "", `"1"`},
+		{"a large JSON response", "", "application/json", large, "", `"1"`},
+	}
+
+	c := mid.Compress{MinSize: 100}
+
+	t.Log("Given the need to compress responses.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen sending %s to a client accepting %q.", tt.name, tt.accept)
+			{
+				h := c.Handle(func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+					w.Header().Set("Content-Type", tt.contentType)
+					w.Header().Set("ETag", `"1"`)
+					w.Write([]byte(tt.body))
+					return nil
+				})
+
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("GET", "/v1/users", nil)
+				r.Header.Set("Accept-Encoding", tt.accept)
+				if err := h(context.Background(), log.New(ioutil.Discard, log.ErrorLevel), w, r, nil); err != nil {
+					t.Fatal(err)
+				}
+
+				if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+					t.Fatalf("\t%s\tShould be encoded with %q : got %q.", failed, tt.encoding, got)
+				}
+				t.Logf("\t%s\tShould be encoded with %q.", success, tt.encoding)
+
+				if got := w.Header().Get("ETag"); got != tt.etag {
+					t.Fatalf("\t%s\tShould be tagged %s : got %s.", failed, tt.etag, got)
+				}
+				t.Logf("\t%s\tShould be tagged %s.", success, tt.etag)
+
+				body := w.Body.String()
+				if tt.encoding == "gzip" {
+					zr, err := gzip.NewReader(w.Body)
+					if err != nil {
+						t.Fatal(err)
+					}
+					b, err := ioutil.ReadAll(zr)
+					if err != nil {
+						t.Fatal(err)
+					}
+					body = string(b)
+				}
+				if body != tt.body {
+					t.Fatalf("\t%s\tShould receive the body : got %q.", failed, body)
+				}
+				t.Logf("\t%s\tShould receive the body.", success)
+			}
+		}
+
+		t.Log("\tWhen a client sends back the tag of a compressed response.")
+		{
+			var got string
+			h := c.Handle(func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+				got = r.Header.Get("If-None-Match")
+				return nil
+			})
+
+			r := httptest.NewRequest("GET", "/v1/users", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			r.Header.Set("If-None-Match", `"1-gzip", W/"2-deflate"`)
+			h(context.Background(), log.New(ioutil.Discard, log.ErrorLevel), httptest.NewRecorder(), r, nil)
+
+			if want := `"1", W/"2"`; got != want {
+				t.Fatalf("\t%s\tShould pass the handler the original tags : got %s.", failed, got)
+			}
+			t.Logf("\t%s\tShould pass the handler the original tags.", success)
+		}
+
+		t.Log("\tWhen a handler flushes the response.")
+		{
+			var flushed int
+			h := c.Handle(func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte("a"))
+				if err := http.NewResponseController(w).Flush(); err != nil {
+					return err
+				}
+				flushed = w.(interface{ Unwrap() http.ResponseWriter }).Unwrap().(*httptest.ResponseRecorder).Body.Len()
+				return nil
+			})
+
+			r := httptest.NewRequest("GET", "/v1/users", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			if err := h(context.Background(), log.New(ioutil.Discard, log.ErrorLevel), httptest.NewRecorder(), r, nil); err != nil {
+				t.Fatalf("\t%s\tShould be able to flush : %s.", failed, err)
+			}
+
+			if flushed == 0 {
+				t.Fatalf("\t%s\tShould send what was written before returning.", failed)
+			}
+			t.Logf("\t%s\tShould send what was written before returning.", success)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package mid
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+
+	"github.com/pkg/errors"
+)
+
+// exposedHeaders are the response headers browsers let scripts on other
+// origins read, beyond the few every response exposes.
+var exposedHeaders = []string{
+	"Content-Disposition",
+	"ETag",
+	"Location",
+	"X-Request-ID",
+	web.VersionHeader,
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+	"Retry-After",
+	"Idempotent-Replayed",
+}
+
+// CORS lets browsers on other origins call the API.
+// https://fetch.spec.whatwg.org/#http-cors-protocol
+type CORS struct {
+
+	// Origins are the origins allowed to make requests, such as
+	// https://app.example.com. An origin of https://*.example.com allows
+	// every subdomain of example.com over https and * allows any origin.
+	// No origins disables CORS.
+	Origins []string
+
+	// Methods and Headers are the methods and request headers allowed
+	// beyond those browsers always allow.
+	Methods []string
+	Headers []string
+
+	// Credentials allows requests to carry cookies and Authorization
+	// headers. It can not be combined with the * origin.
+	Credentials bool
+
+	// MaxAge is how long browsers may cache the answer to a preflight.
+	MaxAge time.Duration
+}
+
+// Validate checks the settings are safe to use. Credentials with the *
+// origin would let any site call the API as the user.
+func (c *CORS) Validate() error {
+	if c.Credentials && c.anyOrigin() {
+		return errors.New("credentials can not be allowed for the * origin")
+	}
+	return nil
+}
+
+// anyOrigin reports whether any origin is allowed.
+func (c *CORS) anyOrigin() bool {
+	for _, o := range c.Origins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle adds the CORS headers to responses for allowed origins and answers
+// preflight requests without calling the next handler. It must run outside
+// ErrorHandler so error responses carry the headers too, otherwise browsers
+// hide them from the caller.
+func (c *CORS) Handle(next web.Handler) web.Handler {
+	if len(c.Origins) == 0 {
+		return next
+	}
+
+	methods := strings.Join(c.Methods, ", ")
+	headers := strings.Join(c.Headers, ", ")
+	exposed := strings.Join(exposedHeaders, ", ")
+	wildcard := c.anyOrigin()
+
+	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		// The headers depend on the origin so caches must keep a response
+		// per origin.
+		w.Header().Add("Vary", "Origin")
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		// Browsers refuse the response when the headers are missing, which
+		// is how an origin that is not allowed is turned away.
+		if origin == "" || !c.allowed(origin) {
+			if preflight {
+				web.Respond(ctx, log, w, nil, http.StatusNoContent)
+				return nil
+			}
+			return next(ctx, log, w, r, params)
+		}
+
+		// Any origin is allowed with * which browsers never send credentials
+		// for. Otherwise the origin is echoed since browsers do not accept *
+		// for requests with credentials.
+		switch {
+		case wildcard:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		default:
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.Credentials && !wildcard {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", exposed)
+			return next(ctx, log, w, r, params)
+		}
+
+		if methods != "" {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+		}
+		if headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+		}
+
+		web.Respond(ctx, log, w, nil, http.StatusNoContent)
+		return nil
+	}
+
+	return h
+}
+
+// allowed reports whether requests from the origin are allowed.
+func (c *CORS) allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+
+	for _, o := range c.Origins {
+		o = strings.ToLower(o)
+
+		if o == "*" || o == origin {
+			return true
+		}
+
+		// A wildcard matches one or more labels in front of the domain, but
+		// not the domain itself.
+		i := strings.Index(o, "://*.")
+		if i < 0 {
+			continue
+		}
+		scheme, domain := o[:i+3], o[i+4:]
+		if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, domain) && len(origin) > len(scheme)+len(domain) {
+			return true
+		}
+	}
+
+	return false
+}
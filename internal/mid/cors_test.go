@@ -0,0 +1,61 @@
+package mid_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"inventory-optimisation-server/internal/mid"
+	"inventory-optimisation-server/internal/platform/log"
+)
+
+// TestCORS validates browsers are only allowed to send credentials from the
+// origins listed.
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name        string
+		cors        mid.CORS
+		valid       bool
+		origin      string
+		credentials string
+	}{
+		{"a listed origin", mid.CORS{Origins: []string{"https://app.example.com"}, Credentials: true}, true, "https://app.example.com", "true"},
+		{"a subdomain", mid.CORS{Origins: []string{"https://*.example.com"}, Credentials: true}, true, "https://app.example.com", "true"},
+		{"any origin", mid.CORS{Origins: []string{"*"}}, true, "*", ""},
+		{"any origin with credentials", mid.CORS{Origins: []string{"*"}, Credentials: true}, false, "*", ""},
+	}
+
+	t.Log("Given the need to let browsers on other origins call the API.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen allowing %s.", tt.name)
+			{
+				if err := tt.cors.Validate(); (err == nil) != tt.valid {
+					t.Fatalf("\t%s\tShould be valid : %v, got %v.", failed, tt.valid, err)
+				}
+				t.Logf("\t%s\tShould be valid : %v.", success, tt.valid)
+
+				h := tt.cors.Handle(func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+					return nil
+				})
+
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("GET", "/v1/users", nil)
+				r.Header.Set("Origin", "https://app.example.com")
+				h(context.Background(), log.New(ioutil.Discard, log.ErrorLevel), w, r, nil)
+
+				if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.origin {
+					t.Fatalf("\t%s\tShould allow origin %s : got %s.", failed, tt.origin, got)
+				}
+				t.Logf("\t%s\tShould allow origin %s.", success, tt.origin)
+
+				if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.credentials {
+					t.Fatalf("\t%s\tShould allow credentials %q : got %q.", failed, tt.credentials, got)
+				}
+				t.Logf("\t%s\tShould allow credentials %q.", success, tt.credentials)
+			}
+		}
+	}
+}
@@ -2,10 +2,11 @@ package mid
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"net/http"
 	"runtime/debug"
 
+	"inventory-optimisation-server/internal/platform/log"
 	"inventory-optimisation-server/internal/platform/web"
 
 	"github.com/pkg/errors"
@@ -24,17 +25,24 @@ func ErrorHandler(next web.Handler) web.Handler {
 		defer func() {
 			if r := recover(); r != nil {
 
+				// A response aborted part way through is left to the server
+				// to cut off.
+				if r == http.ErrAbortHandler {
+					panic(r)
+				}
+
 				// Indicate this request had an error.
 				v.Error = true
 
-				// Log the panic.
-				log.Printf("ERROR : Panic Caught : %s\n", r)
+				if v.UserID != "" {
+					log = log.With("user_id", v.UserID)
+				}
 
-				// Respond with the error.
-				web.RespondError(ctx, log, w, errors.New("unhandled"), http.StatusInternalServerError)
+				// Log the panic along with the stack.
+				log.Error("panic caught", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
 
-				// Print out the stack.
-				log.Printf("ERROR : Stacktrace\n%s\n", debug.Stack())
+				// Respond with the error.
+				web.Error(ctx, log, w, errors.New("unhandled"))
 			}
 		}()
 
@@ -43,13 +51,16 @@ func ErrorHandler(next web.Handler) web.Handler {
 			// Indicate this request had an error.
 			v.Error = true
 
-			// What is the root error.
-			err = errors.Cause(err)
+			// The user is only known once Authenticate has run further down.
+			if v.UserID != "" {
+				log = log.With("user_id", v.UserID)
+			}
 
-			if err != web.ErrNotFound {
+			// A missing entity is expected and not worth logging.
+			if e, ok := errors.Cause(err).(*web.APIError); !ok || e.Status != http.StatusNotFound {
 
 				// Log the error.
-				log.Printf("ERROR : %v\n", err)
+				log.Error("request failed", "error", err)
 			}
 
 			// Respond with the error.
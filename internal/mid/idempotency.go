@@ -0,0 +1,275 @@
+package mid
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/idempotency"
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+)
+
+// IdempotencyHeader is the request header carrying the client's key.
+const IdempotencyHeader = "Idempotency-Key"
+
+var (
+	// ErrIdempotencyKeyInvalid occurs when the key is too long to store.
+	ErrIdempotencyKeyInvalid = web.NewError("idempotency_key_invalid", http.StatusBadRequest, "Idempotency key must be at most 255 characters")
+
+	// ErrIdempotencyKeyReused occurs when a key is sent again with a
+	// different request.
+	ErrIdempotencyKeyReused = web.NewError("idempotency_key_reused", http.StatusUnprocessableEntity, "Idempotency key was used for a different request")
+
+	// ErrIdempotencyKeyInProgress occurs when the request first sent with
+	// the key is still being processed.
+	ErrIdempotencyKeyInProgress = web.NewError("idempotency_key_in_progress", http.StatusConflict, "A request with this idempotency key is in progress")
+)
+
+// replayedHeaders are the response headers stored and replayed. Headers
+// that describe this particular request, such as its id or rate limit, are
+// left out.
+var replayedHeaders = []string{"Content-Type", "Content-Disposition", "Location", "ETag", "Last-Modified"}
+
+// Idempotency makes POST endpoints safe to retry. The first request with an
+// Idempotency-Key is processed and its response stored, later requests with
+// the key receive the stored response. Keys are scoped to the caller so it
+// must run after Authenticate or Identify.
+type Idempotency struct {
+	Store idempotency.Store
+
+	// TTL is how long responses are kept.
+	TTL time.Duration
+
+	// Wait is how long a request waits for a concurrent request with the
+	// same key to finish before giving up with a 409.
+	Wait time.Duration
+
+	// MaxMemory is how many bytes of a request body are held in memory
+	// while it is fingerprinted, the rest goes to a temporary file.
+	MaxMemory int64
+}
+
+// pollInterval is how often a waiting request checks on the request that
+// owns its key.
+const pollInterval = 100 * time.Millisecond
+
+// lockMargin is how long a key stays locked past the route's time limit,
+// giving its request time to store the response. A key whose request never
+// finishes is taken over after that.
+const lockMargin = 10 * time.Second
+
+// storeTimeout bounds storing the outcome of a request once it is done.
+const storeTimeout = 5 * time.Second
+
+// Handle applies idempotency to requests that send a key. Requests without
+// one are passed through.
+func (i *Idempotency) Handle(next web.Handler) web.Handler {
+	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" {
+			return next(ctx, log, w, r, params)
+		}
+		if len(key) > 255 {
+			return ErrIdempotencyKeyInvalid
+		}
+
+		v := ctx.Value(web.KeyValues).(*web.Values)
+
+		// The same key sent with a different request is a client bug we
+		// want to catch rather than answer with the wrong response.
+		// The body is hashed as it is buffered for the handler so large
+		// uploads are not held in memory.
+		body := newDigest(r)
+		done, err := web.BufferBody(r, i.MaxMemory, body)
+		digest := body.Sum()
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		sum := sha256.New()
+		sum.Write([]byte(r.Method + " " + v.Route + "\n"))
+		sum.Write(digest)
+		fingerprint := hex.EncodeToString(sum.Sum(nil))
+
+		// The key must stay locked for as long as the route may take, or a
+		// retry would run the request a second time.
+		lock := v.Timeout
+		if lock <= 0 {
+			lock = i.Wait
+		}
+		lock += lockMargin
+
+		key = caller(ctx, r) + ":" + key
+		deadline := time.Now().Add(i.Wait)
+
+		for {
+			rec, owned, err := i.Store.Begin(ctx, key, fingerprint, time.Now(), lock, i.TTL)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case owned:
+				return i.process(ctx, log, key, next, w, r, params)
+
+			case rec.Fingerprint != fingerprint:
+				return web.Detail(ErrIdempotencyKeyReused, "the key was first sent with a different method, route or body")
+
+			case rec.State == idempotency.StateComplete:
+				replay(v, w, rec)
+				return nil
+			}
+
+			// Another request with the key is being processed. Wait for it
+			// to finish so we can send its response.
+			if time.Now().After(deadline) {
+				return web.Detail(ErrIdempotencyKeyInProgress, "retry once the first request has been answered")
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(pollInterval):
+			}
+		}
+	}
+
+	return h
+}
+
+// process runs the request that owns the key and stores its response. A
+// request that fails is released so it can be retried.
+func (i *Idempotency) process(ctx context.Context, log *log.Logger, key string, next web.Handler, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	cw := web.NewCaptureWriter(w)
+
+	// Errors are turned into responses further up the chain, after we have
+	// returned, so they are not stored.
+	err := next(ctx, log, cw, r, params)
+
+	// The outcome must be recorded even if the client has gone away.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), storeTimeout)
+	defer cancel()
+
+	if err != nil || cw.Status == 0 || cw.Status >= http.StatusInternalServerError {
+		if err := i.Store.Release(ctx, key); err != nil {
+			log.Error("releasing idempotency key", "error", err)
+		}
+		return err
+	}
+
+	header := make(http.Header)
+	for _, k := range replayedHeaders {
+		if vs, ok := cw.SentHeader[k]; ok {
+			header[k] = vs
+		}
+	}
+
+	if err := i.Store.Complete(ctx, key, cw.Status, header, cw.Body.Bytes()); err != nil {
+		log.Error("storing idempotent response", "error", err)
+	}
+
+	return nil
+}
+
+// replay sends the stored response.
+func replay(v *web.Values, w http.ResponseWriter, rec *idempotency.Record) {
+	for k, vs := range rec.Header {
+		w.Header()[k] = vs
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+
+	v.StatusCode = rec.Status
+	w.WriteHeader(rec.Status)
+	w.Write(rec.Body)
+}
+
+// digest hashes a request body as it is written. A multipart form is hashed
+// by its parts rather than its bytes, which hold a boundary the client picks
+// anew for every request, so a retried upload has the same digest. A body
+// that is not a well formed form is hashed as it is.
+type digest struct {
+	raw  hash.Hash
+	pw   *io.PipeWriter
+	form chan []byte
+}
+
+// newDigest returns a digest for the body of r.
+func newDigest(r *http.Request) *digest {
+	d := digest{raw: sha256.New()}
+
+	mt, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/form-data" || params["boundary"] == "" {
+		return &d
+	}
+
+	pr, pw := io.Pipe()
+	d.pw = pw
+	d.form = make(chan []byte, 1)
+	go func() {
+		sum, err := formDigest(multipart.NewReader(pr, params["boundary"]))
+		if err != nil {
+			sum = nil
+		}
+
+		// Writes block until they are read so the rest of the body must be
+		// read even when the form is malformed.
+		io.Copy(ioutil.Discard, pr)
+		d.form <- sum
+	}()
+
+	return &d
+}
+
+// Write implements the io.Writer interface.
+func (d *digest) Write(p []byte) (int, error) {
+	d.raw.Write(p)
+	if d.pw != nil {
+		d.pw.Write(p)
+	}
+	return len(p), nil
+}
+
+// Sum returns the digest of what was written. It must be called once the
+// whole body was written, or reading it failed.
+func (d *digest) Sum() []byte {
+	if d.pw == nil {
+		return d.raw.Sum(nil)
+	}
+
+	d.pw.Close()
+	if sum := <-d.form; sum != nil {
+		return sum
+	}
+	return d.raw.Sum(nil)
+}
+
+// formDigest hashes the name, file name, content type and content of each
+// part of a multipart form.
+func formDigest(mr *multipart.Reader) ([]byte, error) {
+	sum := sha256.New()
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return sum.Sum(nil), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		content := sha256.New()
+		if _, err := io.Copy(content, p); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(sum, "%q %q %q %x\n", p.FormName(), p.FileName(), p.Header.Get("Content-Type"), content.Sum(nil))
+	}
+}
@@ -0,0 +1,266 @@
+package mid_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/internal/mid"
+	"inventory-optimisation-server/internal/platform/idempotency"
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+
+	"github.com/pkg/errors"
+)
+
+// memoryKeys is an idempotency store keeping records in memory.
+type memoryKeys struct {
+	mu      sync.Mutex
+	records map[string]idempotency.Record
+	lock    time.Duration
+}
+
+// Begin implements the idempotency.Store interface.
+func (s *memoryKeys) Begin(ctx context.Context, key, fingerprint string, now time.Time, lock, ttl time.Duration) (*idempotency.Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lock = lock
+	if rec, ok := s.records[key]; ok {
+		return &rec, false, nil
+	}
+
+	rec := idempotency.Record{
+		Key:         key,
+		Fingerprint: fingerprint,
+		State:       idempotency.StateProcessing,
+		LockedUntil: now.Add(lock),
+		ExpiresAt:   now.Add(ttl),
+	}
+	s.records[key] = rec
+	return &rec, true, nil
+}
+
+// Complete implements the idempotency.Store interface.
+func (s *memoryKeys) Complete(ctx context.Context, key string, status int, header http.Header, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec := s.records[key]
+	rec.State = idempotency.StateComplete
+	rec.Status = status
+	rec.Header = header
+	rec.Body = body
+	s.records[key] = rec
+	return nil
+}
+
+// Release implements the idempotency.Store interface.
+func (s *memoryKeys) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// echo is a handler creating an entity from the request body. It counts how
+// often it runs and, when block is set, waits for it to be closed first.
+type echo struct {
+	mu     sync.Mutex
+	calls  int
+	status int
+	block  chan struct{}
+}
+
+// handle implements web.Handler.
+func (e *echo) handle(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	e.mu.Lock()
+	e.calls++
+	e.mu.Unlock()
+
+	if e.block != nil {
+		<-e.block
+	}
+
+	body, err := web.ReadBody(r)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Location", "/v1/users/1")
+	w.WriteHeader(e.status)
+	w.Write(body)
+	return nil
+}
+
+// count returns how often the handler ran.
+func (e *echo) count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calls
+}
+
+// send makes a request with the idempotency key and body.
+func send(h web.Handler, key, body string) (*httptest.ResponseRecorder, error) {
+	v := web.Values{Route: "/v1/users", Timeout: time.Minute, Now: time.Now()}
+	ctx := context.WithValue(context.Background(), web.KeyValues, &v)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/v1/users", strings.NewReader(body))
+	r.Header.Set(mid.IdempotencyHeader, key)
+
+	err := h(ctx, log.New(ioutil.Discard, log.ErrorLevel), w, r, nil)
+	return w, err
+}
+
+// sendForm makes a request with the idempotency key and a multipart form
+// holding a file, written with the boundary.
+func sendForm(h web.Handler, key, boundary, file string) (*httptest.ResponseRecorder, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.SetBoundary(boundary)
+	mw.WriteField("name", "a")
+	fw, _ := mw.CreateFormFile("file", "a.csv")
+	fw.Write([]byte(file))
+	mw.Close()
+
+	v := web.Values{Route: "/v1/users", Timeout: time.Minute, Now: time.Now()}
+	ctx := context.WithValue(context.Background(), web.KeyValues, &v)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/v1/users", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set(mid.IdempotencyHeader, key)
+
+	err := h(ctx, log.New(ioutil.Discard, log.ErrorLevel), w, r, nil)
+	return w, err
+}
+
+// TestIdempotency validates requests retried with the same key are only
+// processed once.
+func TestIdempotency(t *testing.T) {
+	t.Log("Given the need to make requests safe to retry.")
+	{
+		t.Log("\tWhen a request is retried.")
+		{
+			keys := &memoryKeys{records: make(map[string]idempotency.Record)}
+			idem := mid.Idempotency{Store: keys, TTL: time.Hour, Wait: time.Second, MaxMemory: 4}
+			e := echo{status: http.StatusCreated}
+			h := idem.Handle(e.handle)
+
+			first, err := send(h, "a", `{"name":"a"}`)
+			if err != nil {
+				t.Fatalf("\t%s\tShould process the first request : %s.", failed, err)
+			}
+			t.Logf("\t%s\tShould process the first request.", success)
+
+			if want := time.Minute + 10*time.Second; keys.lock != want {
+				t.Fatalf("\t%s\tShould lock the key for the route's time limit and a margin : got %s.", failed, keys.lock)
+			}
+			t.Logf("\t%s\tShould lock the key for the route's time limit and a margin.", success)
+
+			retry, err := send(h, "a", `{"name":"a"}`)
+			if err != nil || e.count() != 1 {
+				t.Fatalf("\t%s\tShould not process the retry : %d calls %v.", failed, e.count(), err)
+			}
+			t.Logf("\t%s\tShould not process the retry.", success)
+
+			if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get("Location") != "/v1/users/1" {
+				t.Fatalf("\t%s\tShould replay the response : got %d %s.", failed, retry.Code, retry.Body)
+			}
+			if retry.Header().Get("Idempotent-Replayed") != "true" {
+				t.Fatalf("\t%s\tShould mark the response as replayed.", failed)
+			}
+			t.Logf("\t%s\tShould replay the response.", success)
+
+			if _, err := send(h, "a", `{"name":"b"}`); errors.Cause(err) != mid.ErrIdempotencyKeyReused {
+				t.Fatalf("\t%s\tShould reject the key with a different body : got %v.", failed, err)
+			}
+			t.Logf("\t%s\tShould reject the key with a different body.", success)
+		}
+
+		t.Log("\tWhen a form is retried with a different boundary.")
+		{
+			keys := &memoryKeys{records: make(map[string]idempotency.Record)}
+			idem := mid.Idempotency{Store: keys, TTL: time.Hour, Wait: time.Second, MaxMemory: 16}
+			e := echo{status: http.StatusCreated}
+			h := idem.Handle(e.handle)
+
+			if _, err := sendForm(h, "a", "first", "sku,qty\n1,2\n"); err != nil {
+				t.Fatalf("\t%s\tShould process the first request : %s.", failed, err)
+			}
+			t.Logf("\t%s\tShould process the first request.", success)
+
+			retry, err := sendForm(h, "a", "second", "sku,qty\n1,2\n")
+			if err != nil || e.count() != 1 || retry.Header().Get("Idempotent-Replayed") != "true" {
+				t.Fatalf("\t%s\tShould replay the response : %d calls %v.", failed, e.count(), err)
+			}
+			t.Logf("\t%s\tShould replay the response.", success)
+
+			if _, err := sendForm(h, "a", "second", "sku,qty\n1,3\n"); errors.Cause(err) != mid.ErrIdempotencyKeyReused {
+				t.Fatalf("\t%s\tShould reject the key with a different file : got %v.", failed, err)
+			}
+			t.Logf("\t%s\tShould reject the key with a different file.", success)
+		}
+
+		t.Log("\tWhen a request fails.")
+		{
+			keys := &memoryKeys{records: make(map[string]idempotency.Record)}
+			idem := mid.Idempotency{Store: keys, TTL: time.Hour, Wait: time.Second, MaxMemory: 1024}
+			e := echo{status: http.StatusServiceUnavailable}
+			h := idem.Handle(e.handle)
+
+			send(h, "a", `{"name":"a"}`)
+			e.status = http.StatusCreated
+			w, err := send(h, "a", `{"name":"a"}`)
+			if err != nil || e.count() != 2 || w.Code != http.StatusCreated {
+				t.Fatalf("\t%s\tShould process the retry : %d calls %d %v.", failed, e.count(), w.Code, err)
+			}
+			t.Logf("\t%s\tShould process the retry.", success)
+		}
+
+		t.Log("\tWhen a request is retried while the first is in progress.")
+		{
+			keys := &memoryKeys{records: make(map[string]idempotency.Record)}
+			idem := mid.Idempotency{Store: keys, TTL: time.Hour, Wait: 300 * time.Millisecond, MaxMemory: 1024}
+			e := echo{status: http.StatusCreated, block: make(chan struct{})}
+			h := idem.Handle(e.handle)
+
+			done := make(chan error)
+			go func() {
+				_, err := send(h, "a", `{"name":"a"}`)
+				done <- err
+			}()
+			for e.count() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			if _, err := send(h, "a", `{"name":"a"}`); errors.Cause(err) != mid.ErrIdempotencyKeyInProgress {
+				t.Fatalf("\t%s\tShould give up after waiting : got %v.", failed, err)
+			}
+			t.Logf("\t%s\tShould give up after waiting.", success)
+
+			idem.Wait = 5 * time.Second
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				close(e.block)
+			}()
+			w, err := send(h, "a", `{"name":"a"}`)
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+			if err != nil || e.count() != 1 || w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "true" {
+				t.Fatalf("\t%s\tShould wait for the response : %d calls %d %v.", failed, e.count(), w.Code, err)
+			}
+			t.Logf("\t%s\tShould wait for the response.", success)
+		}
+	}
+}
@@ -2,15 +2,16 @@ package mid
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"time"
 
+	"inventory-optimisation-server/internal/platform/log"
 	"inventory-optimisation-server/internal/platform/web"
 )
 
-// RequestLogger writes some information about the request to the logs in
-// the format: TraceID : (200) GET /foo -> IP ADDR (latency)
+// RequestLogger writes an entry for every completed request holding its
+// status, method, path, client address, latency and the authenticated user,
+// if any. The trace id and route come from the request's logger.
 func RequestLogger(next web.Handler) web.Handler {
 
 	// Wrap this handler around the next one provided.
@@ -20,10 +21,16 @@ func RequestLogger(next web.Handler) web.Handler {
 
 		v := ctx.Value(web.KeyValues).(*web.Values)
 
-		log.Printf("(%d) : %s %s -> %s (%s)",
-			v.StatusCode,
-			r.Method, r.URL.Path,
-			r.RemoteAddr, time.Since(v.Now),
+		if v.UserID != "" {
+			log = log.With("user_id", v.UserID)
+		}
+
+		log.Info("request completed",
+			"status", v.StatusCode,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote_addr", r.RemoteAddr,
+			"latency", time.Since(v.Now),
 		)
 
 		// For consistency return the error we received.
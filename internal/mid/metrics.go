@@ -2,49 +2,65 @@ package mid
 
 import (
 	"context"
-	"expvar"
-	"log"
 	"net/http"
 	"runtime"
+	"strconv"
+	"time"
 
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/metrics"
 	"inventory-optimisation-server/internal/platform/web"
 )
 
-// m contains the global program counters for the application.
+// m contains the request metrics for the application. Requests are labeled
+// by route pattern, not path, so ids in the path do not create a series per
+// entity.
 var m = struct {
-	gr  *expvar.Int
-	req *expvar.Int
-	err *expvar.Int
+	req      *metrics.CounterVec
+	latency  *metrics.HistogramVec
+	inFlight *metrics.GaugeVec
 }{
-	gr:  expvar.NewInt("goroutines"),
-	req: expvar.NewInt("requests"),
-	err: expvar.NewInt("errors"),
+	req:      metrics.NewCounterVec("http_requests_total", "Number of HTTP requests handled.", "method", "route", "status"),
+	latency:  metrics.NewHistogramVec("http_request_duration_seconds", "Time taken to handle HTTP requests.", nil, "method", "route", "status"),
+	inFlight: metrics.NewGaugeVec("http_requests_in_flight", "Number of HTTP requests being handled."),
 }
 
-// Metrics updates program counters.
+func init() {
+	metrics.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+}
+
+// Metrics records the count and latency of each request along with the
+// number of requests in flight.
 func Metrics(next web.Handler) web.Handler {
 
 	// Wrap this handler around the next one provided.
 	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		inFlight := m.inFlight.With()
+		inFlight.Inc()
+		defer inFlight.Dec()
 
-		next(ctx, log, w, r, params)
+		err := next(ctx, log, w, r, params)
 
-		// Add one to the request counter.
-		m.req.Add(1)
+		v := ctx.Value(web.KeyValues).(*web.Values)
 
-		// Include the current count for the number of goroutines.
-		if m.req.Value()%100 == 0 {
-			m.gr.Set(int64(runtime.NumGoroutine()))
+		// A handler that wrote its own response without going through
+		// web.Respond left the default status. An error that reached us has
+		// not been responded to yet.
+		status := v.StatusCode
+		if status == 0 {
+			status = http.StatusOK
+			if err != nil {
+				status = http.StatusInternalServerError
+			}
 		}
 
-		// Add one to the errors counter if an error occured
-		// on this reuqest.
-		v := ctx.Value(web.KeyValues).(*web.Values)
-		if v.Error {
-			m.err.Add(1)
-		}
+		lvs := []string{r.Method, v.Route, strconv.Itoa(status)}
+		m.req.With(lvs...).Inc()
+		m.latency.With(lvs...).Observe(time.Since(v.Now).Seconds())
 
-		return nil
+		return err
 	}
 
 	return h
@@ -0,0 +1,97 @@
+package mid
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/auth"
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/ratelimit"
+	"inventory-optimisation-server/internal/platform/web"
+)
+
+// ErrRateLimited occurs when a caller has used up their budget.
+var ErrRateLimited = web.NewError("rate_limited", http.StatusTooManyRequests, "Too many requests")
+
+// RateLimit limits how often each caller can make requests. Callers are
+// identified by the subject of their token, or by their IP address when
+// they are anonymous, so it must run after Authenticate or Identify to
+// budget users separately.
+type RateLimit struct {
+	Store ratelimit.Store
+	Rate  ratelimit.Rate
+
+	// Budget names the budget so routes with their own limits do not draw
+	// from each other.
+	Budget string
+}
+
+// Limit rejects requests from callers that have used up their budget with a
+// 429 and a Retry-After header. Every response carries the RateLimit
+// headers describing the budget. A zero rate disables limiting.
+//
+// Requests are let through when the store is unavailable, but not when a
+// bucket is too contended to update, since attackers can cause that.
+// https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+func (rl *RateLimit) Limit(next web.Handler) web.Handler {
+	if rl.Store == nil || rl.Rate.Limit <= 0 || rl.Rate.Period <= 0 {
+		return next
+	}
+
+	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		v := ctx.Value(web.KeyValues).(*web.Values)
+
+		res, err := rl.Store.Take(ctx, rl.Budget+":"+caller(ctx, r), rl.Rate, v.Now)
+		switch {
+
+		// A bucket changed by so many requests at once that it could not be
+		// updated is being hammered, which is what the budget is there for.
+		case err == ratelimit.ErrContention:
+			log.Warn("rate limiting", "budget", rl.Budget, "error", err)
+			w.Header().Set("Retry-After", "1")
+			return ErrRateLimited
+
+		case err != nil:
+
+			// An unavailable store should not take the API down with it.
+			log.Error("rate limiting", "budget", rl.Budget, "error", err)
+			return next(ctx, log, w, r, params)
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(rl.Rate.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", ceilSeconds(res.Reset))
+
+		if !res.Allowed {
+			w.Header().Set("Retry-After", ceilSeconds(res.RetryAfter))
+			return ErrRateLimited
+		}
+
+		return next(ctx, log, w, r, params)
+	}
+
+	return h
+}
+
+// caller identifies who is making the request.
+func caller(ctx context.Context, r *http.Request) string {
+	if claims, ok := ctx.Value(auth.Key).(auth.Claims); ok && claims.Subject != "" {
+		return "sub:" + claims.Subject
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return "ip:" + ip
+}
+
+// ceilSeconds formats the duration as whole seconds, rounded up so clients
+// do not retry too early.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,73 @@
+package mid_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/internal/mid"
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/ratelimit"
+	"inventory-optimisation-server/internal/platform/web"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// failingStore is a rate limit store that always fails with err.
+type failingStore struct {
+	err error
+}
+
+// Take implements the ratelimit.Store interface.
+func (s failingStore) Take(ctx context.Context, key string, rate ratelimit.Rate, now time.Time) (ratelimit.Result, error) {
+	return ratelimit.Result{}, s.err
+}
+
+// TestRateLimitStoreFailure validates requests are let through when the
+// store is unavailable but not when a bucket is contended.
+func TestRateLimitStoreFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		allowed bool
+	}{
+		{"unavailable", errors.New("no reachable servers"), true},
+		{"contended", ratelimit.ErrContention, false},
+	}
+
+	t.Log("Given the need to keep limiting callers when the store fails.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen the store is %s.", tt.name)
+			{
+				rl := mid.RateLimit{
+					Store:  failingStore{err: tt.err},
+					Rate:   ratelimit.Rate{Limit: 10, Period: time.Minute},
+					Budget: "login",
+				}
+
+				var called bool
+				h := rl.Limit(func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+					called = true
+					return nil
+				})
+
+				v := web.Values{Now: time.Now()}
+				ctx := context.WithValue(context.Background(), web.KeyValues, &v)
+				err := h(ctx, log.New(ioutil.Discard, log.ErrorLevel), httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/users/token", nil), nil)
+
+				if called != tt.allowed || (err == mid.ErrRateLimited) == tt.allowed {
+					t.Fatalf("\t%s\tShould let the request through : %v, got %v %v.", failed, tt.allowed, called, err)
+				}
+				t.Logf("\t%s\tShould let the request through : %v.", success, tt.allowed)
+			}
+		}
+	}
+}
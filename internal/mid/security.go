@@ -0,0 +1,110 @@
+package mid
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrHeaderTooLarge occurs when a request header is longer than allowed.
+	ErrHeaderTooLarge = web.NewError("header_too_large", http.StatusRequestHeaderFieldsTooLarge, "Request header is too large")
+
+	// ErrPathRejected occurs when the request path tries to escape the
+	// route it is meant for, such as with dot segments or encoded slashes.
+	ErrPathRejected = web.NewError("path_rejected", http.StatusBadRequest, "Request path is not allowed")
+)
+
+// Security sets the headers that tell browsers to lock down how responses
+// are used and rejects requests that look like attacks. Empty settings
+// leave their header out.
+type Security struct {
+
+	// HSTSMaxAge is how long browsers must only use https to reach the
+	// service. It is only sent over TLS.
+	HSTSMaxAge time.Duration
+
+	ContentSecurityPolicy string
+	FrameOptions          string
+	ReferrerPolicy        string
+
+	// MaxHeaderSize is the longest value allowed for a single request
+	// header, no limit when zero. The server limits all headers together.
+	MaxHeaderSize int
+}
+
+// Handle sets the security headers on every response and rejects requests
+// with oversized headers or suspicious paths. It runs inside ErrorHandler
+// so rejections are answered like any other error, the headers are set
+// before then so error responses carry them too.
+func (s *Security) Handle(next web.Handler) web.Handler {
+	hsts := "max-age=" + strconv.Itoa(int(s.HSTSMaxAge/time.Second)) + "; includeSubDomains"
+
+	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		hdr := w.Header()
+		hdr.Set("X-Content-Type-Options", "nosniff")
+		if s.HSTSMaxAge > 0 && r.TLS != nil {
+			hdr.Set("Strict-Transport-Security", hsts)
+		}
+		if s.ContentSecurityPolicy != "" {
+			hdr.Set("Content-Security-Policy", s.ContentSecurityPolicy)
+		}
+		if s.FrameOptions != "" {
+			hdr.Set("X-Frame-Options", s.FrameOptions)
+		}
+		if s.ReferrerPolicy != "" {
+			hdr.Set("Referrer-Policy", s.ReferrerPolicy)
+		}
+
+		if s.MaxHeaderSize > 0 {
+			for name, values := range r.Header {
+				for _, v := range values {
+					if len(v) > s.MaxHeaderSize {
+						return errors.Wrapf(ErrHeaderTooLarge, "%s is %d bytes", name, len(v))
+					}
+				}
+			}
+		}
+
+		if suspicious(r) {
+			return errors.Wrapf(ErrPathRejected, "path %q", r.URL.EscapedPath())
+		}
+
+		return next(ctx, log, w, r, params)
+	}
+
+	return h
+}
+
+// suspicious reports whether the request path holds control characters,
+// dot segments or encodings that could make it reach something other than
+// what the route matched.
+func suspicious(r *http.Request) bool {
+	for i := 0; i < len(r.URL.Path); i++ {
+		if c := r.URL.Path[i]; c < ' ' || c == 0x7f || c == '\\' {
+			return true
+		}
+	}
+
+	raw := strings.ToLower(r.URL.EscapedPath())
+	for _, enc := range []string{"%00", "%2e", "%2f", "%5c"} {
+		if strings.Contains(raw, enc) {
+			return true
+		}
+	}
+
+	for _, seg := range strings.Split(r.URL.Path, "/") {
+		if seg == "." || seg == ".." {
+			return true
+		}
+	}
+
+	return false
+}
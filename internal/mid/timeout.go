@@ -0,0 +1,152 @@
+package mid
+
+import (
+	"context"
+	"net/http"
+
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrTimeout occurs when a request is not handled within its route's
+	// time limit.
+	ErrTimeout = web.NewError("timeout", http.StatusGatewayTimeout, "Request took too long")
+
+	// ErrCancelled occurs when a request is abandoned before it could be
+	// handled, such as when the server is shutting down.
+	ErrCancelled = web.NewError("cancelled", http.StatusServiceUnavailable, "Request was cancelled")
+)
+
+// Timeout bounds the time taken to handle a request to the route's time
+// limit, see web.Route.LimitTime. The limit becomes the deadline of the
+// context, which database operations run through db.Execute honor, so
+// handlers must give up once their context is done.
+//
+// The status and headers are held back until the handler writes the body.
+// A request that runs out of time before then is answered with a 504, or a
+// 503 when it was cancelled. Once the body has started, streaming to the
+// client, a failure can no longer be answered so the response is aborted
+// and the client sees it cut short. It must run inside ErrorHandler.
+func Timeout(next web.Handler) web.Handler {
+
+	// Wrap this handler around the next one provided.
+	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		v := ctx.Value(web.KeyValues).(*web.Values)
+		if v.Timeout <= 0 {
+			return next(ctx, log, w, r, params)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, v.Timeout)
+		defer cancel()
+
+		hw := holdWriter{
+			ResponseWriter: w,
+			header:         make(http.Header, len(w.Header())),
+		}
+		for k, vs := range w.Header() {
+			hw.header[k] = append([]string(nil), vs...)
+		}
+
+		err := next(ctx, log, &hw, r, params)
+
+		// The handler failing once the context is done is put down to the
+		// deadline whatever error it reports.
+		if err != nil {
+			switch ctx.Err() {
+			case context.DeadlineExceeded:
+				err = errors.Wrapf(ErrTimeout, "after %s: %v", v.Timeout, err)
+			case context.Canceled:
+				err = errors.Wrap(ErrCancelled, err.Error())
+			}
+		}
+
+		if hw.sent {
+			if err != nil {
+				v.Error = true
+				log.Error("request failed while responding", "error", err)
+				panic(http.ErrAbortHandler)
+			}
+			return nil
+		}
+
+		// An error is answered by ErrorHandler, which needs the headers
+		// set so far, such as Retry-After, but not the status.
+		if err != nil && hw.status != 0 {
+			return err
+		}
+
+		hw.flush()
+		return err
+	}
+
+	return h
+}
+
+// holdWriter holds back the status and headers until the first write of
+// the body or until flush is called.
+type holdWriter struct {
+	http.ResponseWriter
+	header http.Header
+	status int
+	sent   bool
+}
+
+// Header implements the http.ResponseWriter interface.
+func (hw *holdWriter) Header() http.Header {
+	return hw.header
+}
+
+// WriteHeader implements the http.ResponseWriter interface.
+func (hw *holdWriter) WriteHeader(status int) {
+	if hw.status == 0 {
+		hw.status = status
+	}
+}
+
+// Write implements the http.ResponseWriter interface.
+func (hw *holdWriter) Write(b []byte) (int, error) {
+	if hw.status == 0 {
+		hw.status = http.StatusOK
+	}
+	if !hw.sent {
+		hw.flush()
+	}
+	return hw.ResponseWriter.Write(b)
+}
+
+// Flush implements the http.Flusher interface.
+func (hw *holdWriter) Flush() {
+	if hw.status == 0 {
+		hw.status = http.StatusOK
+	}
+	if !hw.sent {
+		hw.flush()
+	}
+	http.NewResponseController(hw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (hw *holdWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// flush sends the headers, and the status if one was set, to the client.
+func (hw *holdWriter) flush() {
+	h := hw.ResponseWriter.Header()
+	for k := range h {
+		delete(h, k)
+	}
+	for k, vs := range hw.header {
+		h[k] = vs
+	}
+
+	if hw.status == 0 {
+		return
+	}
+
+	hw.ResponseWriter.WriteHeader(hw.status)
+	hw.sent = true
+}
@@ -0,0 +1,104 @@
+package mid_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/internal/mid"
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+)
+
+// slow is a handler that starts a response then waits for its context to
+// be done.
+func slow(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	w.Header().Set("Location", "/v1/users/1")
+	w.WriteHeader(http.StatusCreated)
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// TestTimeout validates requests that take too long are answered with an
+// error rather than what the handler started to send.
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		cancel bool
+		status int
+		code   string
+	}{
+		{"runs out of time", false, http.StatusGatewayTimeout, "timeout"},
+		{"is cancelled", true, http.StatusServiceUnavailable, "cancelled"},
+	}
+
+	t.Log("Given the need to bound the time taken by requests.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen a request %s.", tt.name)
+			{
+				v := web.Values{Timeout: 50 * time.Millisecond}
+				ctx, cancel := context.WithCancel(context.WithValue(context.Background(), web.KeyValues, &v))
+				if tt.cancel {
+					cancel()
+				}
+
+				w := httptest.NewRecorder()
+				h := mid.ErrorHandler(mid.Timeout(slow))
+				h(ctx, log.New(ioutil.Discard, log.ErrorLevel), w, httptest.NewRequest("POST", "/v1/users", nil), nil)
+				cancel()
+
+				if w.Code != tt.status {
+					t.Fatalf("\t%s\tShould receive a status code of %d : got %d.", failed, tt.status, w.Code)
+				}
+				t.Logf("\t%s\tShould receive a status code of %d.", success, tt.status)
+
+				var p web.Problem
+				if err := json.NewDecoder(w.Body).Decode(&p); err != nil || p.Code != tt.code {
+					t.Fatalf("\t%s\tShould receive only the %s problem : got %+v %v.", failed, tt.code, p, err)
+				}
+				if w.Header().Get("Location") != "" {
+					t.Fatalf("\t%s\tShould receive only the %s problem : got Location %s.", failed, tt.code, w.Header().Get("Location"))
+				}
+				t.Logf("\t%s\tShould receive only the %s problem.", success, tt.code)
+			}
+		}
+
+		t.Log("\tWhen a request streaming its response runs out of time.")
+		{
+			v := web.Values{Timeout: 50 * time.Millisecond}
+			ctx := context.WithValue(context.Background(), web.KeyValues, &v)
+
+			var streamed int
+			w := httptest.NewRecorder()
+			h := mid.ErrorHandler(mid.Timeout(func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+				w.Write([]byte("["))
+				http.NewResponseController(w).Flush()
+				streamed = w.(interface{ Unwrap() http.ResponseWriter }).Unwrap().(*httptest.ResponseRecorder).Body.Len()
+
+				<-ctx.Done()
+				return ctx.Err()
+			}))
+
+			func() {
+				defer func() {
+					if r := recover(); r != http.ErrAbortHandler {
+						t.Fatalf("\t%s\tShould abort the response : got %v.", failed, r)
+					}
+					t.Logf("\t%s\tShould abort the response.", success)
+				}()
+				h(ctx, log.New(ioutil.Discard, log.ErrorLevel), w, httptest.NewRequest("GET", "/v1/users", nil), nil)
+			}()
+
+			if streamed == 0 {
+				t.Fatalf("\t%s\tShould send the body as it is written.", failed)
+			}
+			t.Logf("\t%s\tShould send the body as it is written.", success)
+		}
+	}
+}
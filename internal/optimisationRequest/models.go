@@ -1,16 +1,22 @@
 package optimisationRequest
 
 import (
+	"mime/multipart"
 	"time"
+
 	"gopkg.in/mgo.v2/bson"
 )
 
 // Request ...
 type Request struct {
-	ID           bson.ObjectId  `bson:"_id" json:"id"`
-	Name         string         `bson:"name" json:"name"`
-	Input        []RequestInput `bson:"input" json:"input"`
-	DateCreated  time.Time      `bson:"date_created" json:"date_created"`
+	ID          bson.ObjectId  `bson:"_id" json:"id"`
+	Name        string         `bson:"name" json:"name"`
+	Input       []RequestInput `bson:"input" json:"input"`
+	DateCreated time.Time      `bson:"date_created" json:"date_created"`
+	DeletedAt   *time.Time     `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+
+	// Version is incremented by every change. It is sent as the ETag.
+	Version int `bson:"version" json:"version"`
 }
 
 // RequestInput ...
@@ -21,12 +27,27 @@ type RequestInput struct {
 
 // NewRequest ...
 type NewRequest struct {
-	Name                     string            `json:"name" validate:"required"`
-	Input      				 []NewRequestInput `json:"input" validate:"required"`		
+	Name  string            `json:"name" validate:"required"`
+	Input []NewRequestInput `json:"input" validate:"required"`
+}
+
+// NewRequestForm is the multipart form an optimisation request is submitted
+// with. The input files are sent under their type.
+type NewRequestForm struct {
+	Name        string                  `form:"name" validate:"required"`
+	ProductData []*multipart.FileHeader `form:"PRODUCT_DATA_FILE"`
+	FactoryData []*multipart.FileHeader `form:"FACTORY_DATA_FILE"`
 }
 
 // NewRequestInput ...
 type NewRequestInput struct {
 	Type     string `json:"type" validate:"required"`
 	Location string `json:"location" validate:"required"`
-}
\ No newline at end of file
+}
+
+// UpdateRequest defines the information that replaces an existing Request
+// with PUT. It is also the document PATCH is applied to. The input files
+// can not be changed once submitted.
+type UpdateRequest struct {
+	Name string `json:"name" validate:"required"`
+}
@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"inventory-optimisation-server/internal/platform/db"
 	"mime/multipart"
+	"net/http"
 	"time"
 
 	"inventory-optimisation-server/internal/constants"
+	"inventory-optimisation-server/internal/platform/metrics"
+	"inventory-optimisation-server/internal/platform/web"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -18,21 +21,17 @@ import (
 
 var (
 	// ErrNotFound abstracts the mgo not found error.
-	ErrNotFound = errors.New("Entity not found")
+	ErrNotFound = web.NewError("optimisation_request_not_found", http.StatusNotFound, "Optimisation request not found")
 
 	// ErrInvalidID occurs when an ID is not in a valid form.
-	ErrInvalidID = errors.New("ID is not in its proper form")
-
-	// ErrAuthenticationFailure occurs when a user attempts to authenticate but
-	// anything goes wrong.
-	ErrAuthenticationFailure = errors.New("Authentication failed")
-
-	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
-	ErrForbidden = errors.New("Attempted action is not allowed")
+	ErrInvalidID = web.NewError("optimisation_request_invalid_id", http.StatusBadRequest, "Optimisation request ID is not in its proper form")
 )
 
 const requestsCollection = "requests"
 
+// submitted counts the optimisation requests accepted for processing.
+var submitted = metrics.NewCounterVec("optimisation_requests_submitted_total", "Number of optimisation requests submitted.")
+
 // Create inserts a new optimisation request into the database.
 func Create(ctx context.Context, dbConn *db.DB, newRequest *NewRequest, now time.Time) (*Request, error) {
 	now = now.Truncate(time.Millisecond)
@@ -50,15 +49,18 @@ func Create(ctx context.Context, dbConn *db.DB, newRequest *NewRequest, now time
 		Name:        newRequest.Name,
 		Input:       requestInput,
 		DateCreated: now,
+		Version:     1,
 	}
 
 	f := func(collection *mgo.Collection) error {
 		return collection.Insert(&request)
 	}
-	if err := dbConn.Execute(ctx, requestsCollection, f); err != nil {
+	if err := dbConn.ExecuteWrite(ctx, requestsCollection, f); err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("db.requests.insert(%s)", db.Query(&request)))
 	}
 
+	submitted.With().Inc()
+
 	return &request, nil
 }
 
@@ -85,29 +87,81 @@ func Validate(file *multipart.FileHeader, fileType string) (*multipart.FileHeade
 	return nil, true
 }
 
-// List will list all the requests served
-func List(ctx context.Context, dbConn *db.DB) ([]Request, error) {
+// live restricts a query to requests that have not been soft deleted unless
+// includeDeleted is set.
+func live(q bson.M, includeDeleted bool) bson.M {
+	if !includeDeleted {
+		q["deleted_at"] = bson.M{"$exists": false}
+	}
+	return q
+}
+
+// versioned restricts a query to a version of a request unless version is
+// web.AnyVersion. Requests stored before they were versioned have no
+// version and are treated as version 0.
+func versioned(q bson.M, version int) bson.M {
+	switch version {
+	case web.AnyVersion:
+	case 0:
+		q["version"] = bson.M{"$in": []interface{}{0, nil}}
+	default:
+		q["version"] = version
+	}
+	return q
+}
+
+// unmatched explains why a conditional update of a live request matched
+// nothing. Either the request does not exist or it changed since the client
+// retrieved the version it sent.
+func unmatched(ctx context.Context, dbConn *db.DB, id string, version int) error {
+	if version == web.AnyVersion {
+		return ErrNotFound
+	}
+
+	q := live(bson.M{"_id": bson.ObjectIdHex(id)}, false)
+
+	var n int
+	f := func(collection *mgo.Collection) error {
+		var err error
+		n, err = collection.Find(q).Count()
+		return err
+	}
+	if err := dbConn.Execute(ctx, requestsCollection, f); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("db.requests.count(%s)", db.Query(q)))
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+	return web.ErrPreconditionFailed
+}
+
+// List will list all the requests served. Soft deleted requests are only
+// returned when includeDeleted is set.
+func List(ctx context.Context, dbConn *db.DB, includeDeleted bool) ([]Request, error) {
 
 	r := []Request{}
+	q := live(bson.M{}, includeDeleted)
 
 	f := func(collection *mgo.Collection) error {
-		return collection.Find(nil).All(&r)
+		return collection.Find(q).All(&r)
 	}
 	if err := dbConn.Execute(ctx, requestsCollection, f); err != nil {
-		return nil, errors.Wrap(err, "db.requests.find()")
+		return nil, errors.Wrap(err, fmt.Sprintf("db.requests.find(%s)", db.Query(q)))
 	}
 
 	return r, nil
 }
 
-// Retrieve gets the specified request from the database.
-func Retrieve(ctx context.Context, dbConn *db.DB, id string) (*Request, error) {
+// Retrieve gets the specified request from the database. A soft deleted
+// request is only returned when includeDeleted is set.
+func Retrieve(ctx context.Context, dbConn *db.DB, id string, includeDeleted bool) (*Request, error) {
 
 	if !bson.IsObjectIdHex(id) {
 		return nil, ErrInvalidID
 	}
 
-	q := bson.M{"_id": bson.ObjectIdHex(id)}
+	q := live(bson.M{"_id": bson.ObjectIdHex(id)}, includeDeleted)
 
 	var r *Request
 	f := func(collection *mgo.Collection) error {
@@ -122,3 +176,97 @@ func Retrieve(ctx context.Context, dbConn *db.DB, id string) (*Request, error) {
 
 	return r, nil
 }
+
+// Update replaces the changeable fields of a request. The request is only
+// changed if it is still at the version, pass web.AnyVersion to change it
+// whatever its version.
+func Update(ctx context.Context, dbConn *db.DB, id string, upd *UpdateRequest, version int) error {
+
+	if !bson.IsObjectIdHex(id) {
+		return ErrInvalidID
+	}
+
+	m := bson.M{"$set": bson.M{"name": upd.Name}, "$inc": bson.M{"version": 1}}
+	q := versioned(live(bson.M{"_id": bson.ObjectIdHex(id)}, false), version)
+
+	f := func(collection *mgo.Collection) error {
+		return collection.Update(q, m)
+	}
+	if err := dbConn.ExecuteWrite(ctx, requestsCollection, f); err != nil {
+		if err == mgo.ErrNotFound {
+			return unmatched(ctx, dbConn, id, version)
+		}
+		return errors.Wrap(err, fmt.Sprintf("db.requests.update(%s, %s)", db.Query(q), db.Query(m)))
+	}
+
+	return nil
+}
+
+// Delete marks a request as deleted. The request is hidden from every query
+// until it is restored or purged. The request is only deleted if it is still
+// at the version, pass web.AnyVersion to delete it whatever its version.
+func Delete(ctx context.Context, dbConn *db.DB, id string, version int, now time.Time) error {
+
+	if !bson.IsObjectIdHex(id) {
+		return ErrInvalidID
+	}
+
+	now = now.Truncate(time.Millisecond)
+
+	m := bson.M{"$set": bson.M{"deleted_at": now}, "$inc": bson.M{"version": 1}}
+	q := versioned(live(bson.M{"_id": bson.ObjectIdHex(id)}, false), version)
+
+	f := func(collection *mgo.Collection) error {
+		return collection.Update(q, m)
+	}
+	if err := dbConn.ExecuteWrite(ctx, requestsCollection, f); err != nil {
+		if err == mgo.ErrNotFound {
+			return unmatched(ctx, dbConn, id, version)
+		}
+		return errors.Wrap(err, fmt.Sprintf("db.requests.update(%s, %s)", db.Query(q), db.Query(m)))
+	}
+
+	return nil
+}
+
+// Restore brings back a request that was previously deleted.
+func Restore(ctx context.Context, dbConn *db.DB, id string) error {
+
+	if !bson.IsObjectIdHex(id) {
+		return ErrInvalidID
+	}
+
+	m := bson.M{"$unset": bson.M{"deleted_at": ""}, "$inc": bson.M{"version": 1}}
+	q := bson.M{"_id": bson.ObjectIdHex(id), "deleted_at": bson.M{"$exists": true}}
+
+	f := func(collection *mgo.Collection) error {
+		return collection.Update(q, m)
+	}
+	if err := dbConn.ExecuteWrite(ctx, requestsCollection, f); err != nil {
+		if err == mgo.ErrNotFound {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, fmt.Sprintf("db.requests.update(%s, %s)", db.Query(q), db.Query(m)))
+	}
+
+	return nil
+}
+
+// Purge permanently removes requests that were deleted before the specified
+// time. It returns the number of requests removed.
+func Purge(ctx context.Context, dbConn *db.DB, before time.Time) (int, error) {
+
+	q := bson.M{"deleted_at": bson.M{"$lt": before}}
+
+	var info *mgo.ChangeInfo
+	f := func(collection *mgo.Collection) error {
+		var err error
+		info, err = collection.RemoveAll(q)
+		return err
+	}
+	if err := dbConn.ExecuteWrite(ctx, requestsCollection, f); err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("db.requests.remove(%s)", db.Query(q)))
+	}
+
+	return info.Removed, nil
+}
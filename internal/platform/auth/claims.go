@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto/x509"
 	"fmt"
 	"time"
 
@@ -42,6 +43,36 @@ func NewClaims(subject string, roles []string, now time.Time, expires time.Durat
 	return c
 }
 
+// CertificateSubjectPrefix starts the subject of claims made for a client
+// certificate so it can not be mistaken for a user id.
+const CertificateSubjectPrefix = "cert:"
+
+// NewCertificateClaims constructs a Claims value for a client identified by
+// a verified certificate. The subject is the common name of the certificate
+// and the roles come from its organizational units using the provided unit
+// to role mapping. The Claims expire with the certificate.
+func NewCertificateClaims(cert *x509.Certificate, units map[string]string, now time.Time) (Claims, error) {
+	if cert.Subject.CommonName == "" {
+		return Claims{}, errors.New("certificate has no common name")
+	}
+
+	c := Claims{
+		Roles: MapRoles(cert.Subject.OrganizationalUnit, units),
+		StandardClaims: jwt.StandardClaims{
+			Subject:   CertificateSubjectPrefix + cert.Subject.CommonName,
+			IssuedAt:  now.Unix(),
+			NotBefore: cert.NotBefore.Unix(),
+			ExpiresAt: cert.NotAfter.Unix(),
+		},
+	}
+
+	if err := c.Valid(); err != nil {
+		return Claims{}, err
+	}
+
+	return c, nil
+}
+
 // Valid is called during the parsing of a token.
 func (c Claims) Valid() error {
 	for _, r := range c.Roles {
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MapRoles translates names asserted about a caller, such as the groups of
+// an identity or the organizational units of a certificate, into roles
+// using the provided name to role mapping. Each role is returned once.
+func MapRoles(names []string, mapping map[string]string) []string {
+	var roles []string
+	seen := make(map[string]bool)
+
+	for _, n := range names {
+		r, ok := mapping[n]
+		if !ok || seen[r] {
+			continue
+		}
+		seen[r] = true
+		roles = append(roles, r)
+	}
+
+	return roles
+}
+
+// ParseRoleMapping parses a name to role mapping of the form
+// "name=ROLE,other-name=ROLE".
+func ParseRoleMapping(s string) (map[string]string, error) {
+	m := make(map[string]string)
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, errors.Errorf("invalid role mapping %q, expected name=ROLE", pair)
+		}
+		m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return m, nil
+}
@@ -0,0 +1,52 @@
+package auth_test
+
+import (
+	"reflect"
+	"testing"
+
+	"inventory-optimisation-server/internal/platform/auth"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// TestRoleMapping validates groups and certificate units are given roles by
+// the configured mapping.
+func TestRoleMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping string
+		valid   bool
+		names   []string
+		roles   []string
+	}{
+		{"a mapping", "planners=USER, admins=ADMIN,", true, []string{"admins", "other", "planners"}, []string{"ADMIN", "USER"}},
+		{"names sharing a role", "planners=USER,buyers=USER", true, []string{"planners", "buyers"}, []string{"USER"}},
+		{"no names mapped", "planners=USER", true, []string{"other"}, nil},
+		{"a name without a role", "planners=", false, nil, nil},
+	}
+
+	t.Log("Given the need to give callers roles.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen parsing %s.", tt.name)
+			{
+				mapping, err := auth.ParseRoleMapping(tt.mapping)
+				if (err == nil) != tt.valid {
+					t.Fatalf("\t%s\tShould be valid : %v, got %v.", failed, tt.valid, err)
+				}
+				t.Logf("\t%s\tShould be valid : %v.", success, tt.valid)
+				if err != nil {
+					continue
+				}
+
+				if roles := auth.MapRoles(tt.names, mapping); !reflect.DeepEqual(roles, tt.roles) {
+					t.Fatalf("\t%s\tShould map %v to %v : got %v.", failed, tt.names, tt.roles, roles)
+				}
+				t.Logf("\t%s\tShould map %v to %v.", success, tt.names, tt.roles)
+			}
+		}
+	}
+}
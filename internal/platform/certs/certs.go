@@ -0,0 +1,191 @@
+// Package certs builds the TLS configuration of the API server and keeps
+// its certificate up to date as the files on disk are replaced.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// These are the ways clients may be asked for a certificate.
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// Config describes how the server speaks TLS.
+type Config struct {
+	CertFile string
+	KeyFile  string
+
+	// MinVersion is the oldest version accepted, "1.2" or "1.3". TLS 1.2
+	// is the oldest when empty.
+	MinVersion string
+
+	// CipherSuites are the names of the suites allowed for TLS 1.2, such as
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Go's secure defaults are used
+	// when empty. TLS 1.3 suites are not configurable.
+	CipherSuites []string
+
+	// ClientAuth is whether clients are asked for a certificate, which is
+	// verified against the authorities in ClientCAFile.
+	ClientAuth   string
+	ClientCAFile string
+
+	// HTTP2 offers HTTP/2 to clients that support it.
+	HTTP2 bool
+}
+
+// New returns the TLS configuration described by cfg. The certificate is
+// served by the returned Reloader, which must be reloaded for changes to the
+// files to be picked up.
+func New(cfg Config) (*tls.Config, *Reloader, error) {
+	rl, err := NewReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tc := tls.Config{
+		GetCertificate: rl.GetCertificate,
+		NextProtos:     []string{"http/1.1"},
+	}
+	if cfg.HTTP2 {
+		tc.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	switch cfg.MinVersion {
+	case "", "1.2":
+		tc.MinVersion = tls.VersionTLS12
+	case "1.3":
+		tc.MinVersion = tls.VersionTLS13
+	default:
+		return nil, nil, errors.Errorf("unsupported TLS version %q, expected 1.2 or 1.3", cfg.MinVersion)
+	}
+
+	if len(cfg.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, s := range tls.CipherSuites() {
+			suites[s.Name] = s.ID
+		}
+
+		for _, name := range cfg.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, nil, errors.Errorf("unknown or insecure cipher suite %q", name)
+			}
+			tc.CipherSuites = append(tc.CipherSuites, id)
+		}
+	}
+
+	switch cfg.ClientAuth {
+	case "", ClientAuthNone:
+		return &tc, rl, nil
+	case ClientAuthOptional:
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, nil, errors.Errorf("unsupported client auth %q, expected none, optional or require", cfg.ClientAuth)
+	}
+
+	pem, err := ioutil.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reading client CA file")
+	}
+	tc.ClientCAs = x509.NewCertPool()
+	if !tc.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, nil, errors.Errorf("no certificates found in %s", cfg.ClientCAFile)
+	}
+
+	return &tc, rl, nil
+}
+
+// Reloader serves a certificate and key pair loaded from files, replacing
+// it when Reload finds the files have changed. Certificates can then be
+// renewed without restarting the server.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader loads the certificate and key pair from the files.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	rl := Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if _, err := rl.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &rl, nil
+}
+
+// GetCertificate returns the current certificate. It is called by the TLS
+// stack for every handshake.
+func (rl *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	return rl.cert, nil
+}
+
+// Reload loads the certificate and key pair again if either file changed
+// since they were last loaded. It reports whether they were reloaded. The
+// current pair is kept when the new one can not be loaded, such as when the
+// files are caught half written.
+func (rl *Reloader) Reload() (bool, error) {
+	modTime, err := rl.lastModified()
+	if err != nil {
+		return false, err
+	}
+
+	rl.mu.RLock()
+	unchanged := rl.cert != nil && modTime.Equal(rl.modTime)
+	rl.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(rl.certFile, rl.keyFile)
+	if err != nil {
+		return false, errors.Wrap(err, "loading certificate")
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.cert = &cert
+	rl.modTime = modTime
+
+	return true, nil
+}
+
+// lastModified returns when the certificate or key file last changed.
+func (rl *Reloader) lastModified() (time.Time, error) {
+	var latest time.Time
+
+	for _, name := range []string{rl.certFile, rl.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "checking certificate")
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,139 @@
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/certs"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// writePair writes a self signed certificate for the common name and its
+// key to the files.
+func writePair(t *testing.T, certFile, keyFile, name string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// commonName returns the common name of the certificate being served.
+func commonName(t *testing.T, tc *tls.Config) string {
+	cert, err := tc.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+// TestReload validates the certificate is replaced once its files change.
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	now := time.Now()
+	writePair(t, certFile, keyFile, "first", now.Add(-time.Minute))
+
+	t.Log("Given the need to renew certificates without a restart.")
+	{
+		tc, rl, err := certs.New(certs.Config{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3", HTTP2: true})
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to build the TLS configuration : %s.", failed, err)
+		}
+		t.Logf("\t%s\tShould be able to build the TLS configuration.", success)
+
+		if tc.MinVersion != tls.VersionTLS13 || tc.NextProtos[0] != "h2" {
+			t.Fatalf("\t%s\tShould accept TLS 1.3 and HTTP/2 : got %x %v.", failed, tc.MinVersion, tc.NextProtos)
+		}
+		t.Logf("\t%s\tShould accept TLS 1.3 and HTTP/2.", success)
+
+		t.Log("\tWhen the files have not changed.")
+		{
+			reloaded, err := rl.Reload()
+			if err != nil || reloaded {
+				t.Fatalf("\t%s\tShould not reload the certificate : got %v %v.", failed, reloaded, err)
+			}
+			t.Logf("\t%s\tShould not reload the certificate.", success)
+		}
+
+		t.Log("\tWhen the files are replaced.")
+		{
+			writePair(t, certFile, keyFile, "second", now)
+
+			reloaded, err := rl.Reload()
+			if err != nil || !reloaded {
+				t.Fatalf("\t%s\tShould reload the certificate : got %v %v.", failed, reloaded, err)
+			}
+			if name := commonName(t, tc); name != "second" {
+				t.Fatalf("\t%s\tShould serve the new certificate : got %s.", failed, name)
+			}
+			t.Logf("\t%s\tShould serve the new certificate.", success)
+		}
+
+		t.Log("\tWhen the files are caught half written.")
+		{
+			if err := ioutil.WriteFile(keyFile, []byte("-----BEGIN"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			os.Chtimes(keyFile, now.Add(time.Minute), now.Add(time.Minute))
+
+			if _, err := rl.Reload(); err == nil {
+				t.Fatalf("\t%s\tShould report the error.", failed)
+			}
+			if name := commonName(t, tc); name != "second" {
+				t.Fatalf("\t%s\tShould keep serving the current certificate : got %s.", failed, name)
+			}
+			t.Logf("\t%s\tShould keep serving the current certificate.", success)
+		}
+	}
+}
@@ -8,6 +8,10 @@ import (
 	"encoding/json"
 	"time"
 
+	"inventory-optimisation-server/internal/platform/metrics"
+	"inventory-optimisation-server/internal/platform/secrets"
+	"inventory-optimisation-server/internal/platform/trace"
+
 	"github.com/pkg/errors"
 	mgo "gopkg.in/mgo.v2"
 )
@@ -16,6 +20,11 @@ import (
 // used to perform actions against.
 var ErrInvalidDBProvided = errors.New("invalid DB provided")
 
+// opDuration records the latency of every operation run through Execute and
+// ExecuteWrite. A not found result is a success as far as the database is
+// concerned.
+var opDuration = metrics.NewHistogramVec("mongo_operation_duration_seconds", "Time taken by MongoDB operations.", nil, "collection", "outcome")
+
 // DB is a collection of support for different DB technologies. Currently
 // only MongoDB has been implemented. We want to be able to access the raw
 // database support for the given DB so an interface does not work. Each
@@ -40,7 +49,7 @@ func New(url string, timeout time.Duration) (*DB, error) {
 	// to our MongoDB.
 	ses, err := mgo.DialWithTimeout(url, timeout)
 	if err != nil {
-		return nil, errors.Wrapf(err, "mgo.DialWithTimeout: %s,%v", url, timeout)
+		return nil, errors.Wrapf(err, "mgo.DialWithTimeout: %s,%v", secrets.MaskURL(url), timeout)
 	}
 
 	// Reads may not be entirely up-to-date, but they will always see the
@@ -78,32 +87,141 @@ func (db *DB) Copy() *DB {
 	return &newDB
 }
 
-// Execute is used to execute MongoDB commands.
+// Execute is used to execute MongoDB commands that read. The operation is
+// abandoned when the context is done.
+//
+// mgo has no notion of a context. The operation runs on a clone of the
+// session whose socket timeout is bound to the context deadline. When the
+// context is cancelled the socket timeout is cut to the minimum so the next
+// round trip to Mongo, such as fetching another batch of results, fails
+// straight away. Execute returns ctx.Err() without waiting for that to happen.
+//
+// An abandoned f keeps running in the background until its round trip
+// fails, so it must not touch anything the caller uses once Execute has
+// returned an error. Changes must go through ExecuteWrite instead, they
+// could still be applied after Execute reported them failed.
 func (db *DB) Execute(ctx context.Context, collName string, f func(*mgo.Collection) error) error {
+	return db.execute(ctx, collName, f, true)
+}
+
+// ExecuteWrite is used to execute MongoDB commands that change data. Unlike
+// Execute the operation is never abandoned once sent: the socket timeout is
+// bound to the context deadline, but ExecuteWrite waits for Mongo to answer
+// so the caller knows whether the change was applied. A write that times
+// out may still have been applied.
+func (db *DB) ExecuteWrite(ctx context.Context, collName string, f func(*mgo.Collection) error) error {
+	return db.execute(ctx, collName, f, false)
+}
+
+// execute runs f against the collection within the deadline of the context,
+// abandoning it when the context is done if abandon is set.
+func (db *DB) execute(ctx context.Context, collName string, f func(*mgo.Collection) error, abandon bool) (err error) {
 
 	if db == nil || db.session == nil {
 		return errors.Wrap(ErrInvalidDBProvided, "db == nil || db.session == nil")
 	}
 
-	return f(db.database.C(collName))
+	start := time.Now()
+	ctx, span := trace.StartSpan(ctx, "mongo."+collName)
+	span.SetAttribute("db.system", "mongodb")
+	span.SetAttribute("db.collection", collName)
+	defer func() {
+		outcome := "success"
+		if err != nil && err != mgo.ErrNotFound {
+			outcome = "error"
+			span.SetError(err)
+		}
+		span.Finish()
+		opDuration.With(collName, outcome).Observe(time.Since(start).Seconds())
+	}()
+
+	// A context that can never be done does not need the extra machinery.
+	if ctx.Done() == nil {
+		return f(db.database.C(collName))
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+	}
+
+	ses := db.session.Clone()
+	if timeout > 0 {
+		ses.SetSocketTimeout(timeout)
+	}
+
+	if !abandon {
+		defer ses.Close()
+		return f(db.database.With(ses).C(collName))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer ses.Close()
+		done <- f(db.database.With(ses).C(collName))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+
+	case <-ctx.Done():
+		ses.SetSocketTimeout(time.Nanosecond)
+		return ctx.Err()
+	}
 }
 
-// ExecuteTimeout is used to execute MongoDB commands with a timeout.
+// ExecuteTimeout is used to execute MongoDB commands with a timeout. The
+// operation is also abandoned if the context is done first, so a request's
+// deadline still applies when it is sooner than the timeout.
 func (db *DB) ExecuteTimeout(ctx context.Context, timeout time.Duration, collName string, f func(*mgo.Collection) error) error {
 
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return db.Execute(ctx, collName, f)
+}
+
+// StatusCheck validates the DB status good by pinging the server. The ping
+// is abandoned when the context is done.
+func (db *DB) StatusCheck(ctx context.Context) error {
+
 	if db == nil || db.session == nil {
 		return errors.Wrap(ErrInvalidDBProvided, "db == nil || db.session == nil")
 	}
 
-	db.session.SetSocketTimeout(timeout)
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+	}
 
-	return f(db.database.C(collName))
-}
+	ses := db.session.Clone()
+	if timeout > 0 {
+		ses.SetSocketTimeout(timeout)
+	}
 
-// StatusCheck validates the DB status good.
-func (db *DB) StatusCheck(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		defer ses.Close()
+		done <- ses.Ping()
+	}()
+
+	select {
+	case err := <-done:
+		return errors.Wrap(err, "ping")
 
-	return nil
+	case <-ctx.Done():
+		ses.SetSocketTimeout(time.Nanosecond)
+		return ctx.Err()
+	}
 }
 
 // Query provides a string version of the value
@@ -4,12 +4,14 @@ for command-line options. See
 http://www.gnu.org/software/libc/manual/html_node/Argument-Syntax.html
 
 There are no hard bindings for this package. This package takes a struct
-value and parses it for flags. It supports three tags to customize the
+value and parses it for flags. It supports four tags to customize the
 flag options.
 
 	flag     - Denotes a shorthand option
 	flagdesc - Provides a description for the help
 	default  - Provides the default value for the help
+	secret   - Set to "true" to leave the field out of the help and the
+	           command line, where its value could be seen by others
 
 The field name and any parent struct name will be used for the long form of
 the command name.
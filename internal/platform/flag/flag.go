@@ -133,6 +133,12 @@ func parse(parentField string, v interface{}) ([]configArg, error) {
 			continue
 		}
 
+		// Secrets are left out of the help and can not be set on the
+		// command line where other users of the machine can see them.
+		if field.Tag.Get("secret") == "true" {
+			continue
+		}
+
 		cfgArg := configArg{
 			Short:   field.Tag.Get("flag"),
 			Long:    parentField + strings.ToLower(field.Name),
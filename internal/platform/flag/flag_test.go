@@ -47,6 +47,7 @@ func TestParse(t *testing.T) {
 		DialTimeout time.Duration `default:"5s"`
 		Host        string        `default:"mongo:27017/gotraining" flag:"h"`
 		Insecure    bool          `flag:"i"`
+		Password    string        `secret:"true"`
 	}
 	parseOutput := `[{"Short":"a","Long":"web_apihost","Default":"0.0.0.0:3000","Type":"string","Desc":"The ip:port for the api endpoint."},{"Short":"","Long":"web_batchsize","Default":"1000","Type":"int","Desc":"Represets number of items to move."},{"Short":"","Long":"web_readtimeout","Default":"5s","Type":"Duration","Desc":""},{"Short":"","Long":"dialtimeout","Default":"5s","Type":"Duration","Desc":""},{"Short":"h","Long":"host","Default":"mongo:27017/gotraining","Type":"string","Desc":""},{"Short":"i","Long":"insecure","Default":"","Type":"bool","Desc":""}]`
 
@@ -166,6 +167,7 @@ func TestDisplay(t *testing.T) {
 		DialTimeout time.Duration `default:"5s"`
 		Host        string        `default:"mongo:27017/gotraining" flag:"h"`
 		Insecure    bool          `flag:"i"`
+		Password    string        `secret:"true"`
 	}
 
 	want := `
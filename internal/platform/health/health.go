@@ -0,0 +1,206 @@
+// Package health runs the checks that tell an orchestrator whether the
+// service is alive and whether it is ready to receive traffic.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Status values reported for the service and for each check.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// DefaultTimeout bounds a check that is registered without a timeout.
+const DefaultTimeout = 2 * time.Second
+
+// Check reports a problem with a subsystem by returning an error. It must
+// give up when the context is done.
+type Check func(ctx context.Context) error
+
+// check is a registered Check.
+type check struct {
+	name    string
+	timeout time.Duration
+	f       Check
+}
+
+// Result is the outcome of a single check.
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the outcome of a set of checks. Status is StatusFail if any
+// check failed.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// OK reports whether every check passed.
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Registry holds the checks registered by the subsystems of the service.
+//
+// Liveness checks should only fail when restarting the process would fix
+// the problem, such as a stuck background worker. Readiness checks cover
+// everything needed to serve a request, such as the database. Readiness
+// includes the liveness checks.
+type Registry struct {
+	mu    sync.RWMutex
+	live  []check
+	ready []check
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// AddLiveness registers a check that decides if the process is alive. A
+// zero timeout means DefaultTimeout.
+func (reg *Registry) AddLiveness(name string, timeout time.Duration, f Check) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.live = append(reg.live, newCheck(name, timeout, f))
+}
+
+// AddReadiness registers a check that decides if the process can serve
+// requests. A zero timeout means DefaultTimeout.
+func (reg *Registry) AddReadiness(name string, timeout time.Duration, f Check) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.ready = append(reg.ready, newCheck(name, timeout, f))
+}
+
+// newCheck applies the default timeout.
+func newCheck(name string, timeout time.Duration, f Check) check {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return check{name: name, timeout: timeout, f: f}
+}
+
+// Live runs the liveness checks.
+func (reg *Registry) Live(ctx context.Context) Report {
+	reg.mu.RLock()
+	checks := append([]check(nil), reg.live...)
+	reg.mu.RUnlock()
+
+	return run(ctx, checks)
+}
+
+// Ready runs the liveness and readiness checks.
+func (reg *Registry) Ready(ctx context.Context) Report {
+	reg.mu.RLock()
+	checks := append(append([]check(nil), reg.live...), reg.ready...)
+	reg.mu.RUnlock()
+
+	return run(ctx, checks)
+}
+
+// run executes the checks concurrently, each bound by its own timeout.
+func run(ctx context.Context, checks []check) Report {
+	rep := Report{
+		Status: StatusOK,
+		Checks: make(map[string]Result, len(checks)),
+	}
+
+	results := make([]Result, len(checks))
+
+	var wg sync.WaitGroup
+	wg.Add(len(checks))
+	for i := range checks {
+		go func(i int) {
+			defer wg.Done()
+			results[i] = runOne(ctx, checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, c := range checks {
+		rep.Checks[c.name] = results[i]
+		if results[i].Status != StatusOK {
+			rep.Status = StatusFail
+		}
+	}
+
+	return rep
+}
+
+// runOne executes a single check. A check that ignores its context is
+// abandoned when the timeout expires.
+func runOne(ctx context.Context, c check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.f(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.Wrap(ctx.Err(), "check did not complete")
+	}
+
+	res := Result{
+		Status:   StatusOK,
+		Duration: time.Since(start).String(),
+	}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// Heartbeat lets a background worker prove it is still making progress.
+// The worker calls Beat each time around its loop.
+type Heartbeat struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// Beat records that the worker is alive.
+func (hb *Heartbeat) Beat(now time.Time) {
+	hb.mu.Lock()
+	hb.last = now
+	hb.mu.Unlock()
+}
+
+// Check returns a Check that fails when the worker has not beaten within
+// maxAge.
+func (hb *Heartbeat) Check(maxAge time.Duration) Check {
+	f := func(ctx context.Context) error {
+		hb.mu.Lock()
+		last := hb.last
+		hb.mu.Unlock()
+
+		if last.IsZero() {
+			return errors.New("no heartbeat yet")
+		}
+		if age := time.Since(last); age > maxAge {
+			return errors.Errorf("last heartbeat %v ago", age.Truncate(time.Second))
+		}
+		return nil
+	}
+
+	return f
+}
@@ -0,0 +1,76 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/health"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// TestRegistry validates liveness and readiness are reported separately and
+// a check that hangs is cut off by its timeout.
+func TestRegistry(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+	hang := func(ctx context.Context) error { select {} }
+
+	t.Log("Given the need to report the health of the service.")
+	{
+		reg := health.NewRegistry()
+		reg.AddLiveness("worker", 0, ok)
+		reg.AddReadiness("mongo", 0, down)
+		reg.AddReadiness("storage", 10*time.Millisecond, hang)
+
+		t.Log("\tWhen a readiness check fails.")
+		{
+			if rep := reg.Live(context.Background()); !rep.OK() || len(rep.Checks) != 1 {
+				t.Log("\t\tGot :", rep)
+				t.Fatalf("\t%s\tShould still report the service is alive.", failed)
+			}
+			t.Logf("\t%s\tShould still report the service is alive.", success)
+
+			rep := reg.Ready(context.Background())
+			if rep.OK() || len(rep.Checks) != 3 {
+				t.Log("\t\tGot :", rep)
+				t.Fatalf("\t%s\tShould report the service is not ready.", failed)
+			}
+			t.Logf("\t%s\tShould report the service is not ready.", success)
+
+			if rep.Checks["mongo"].Error != "connection refused" || rep.Checks["worker"].Status != health.StatusOK {
+				t.Log("\t\tGot :", rep)
+				t.Fatalf("\t%s\tShould report the detail of each check.", failed)
+			}
+			t.Logf("\t%s\tShould report the detail of each check.", success)
+
+			if rep.Checks["storage"].Status != health.StatusFail {
+				t.Log("\t\tGot :", rep)
+				t.Fatalf("\t%s\tShould fail a check that does not complete in time.", failed)
+			}
+			t.Logf("\t%s\tShould fail a check that does not complete in time.", success)
+		}
+
+		t.Log("\tWhen a worker stops beating.")
+		{
+			var hb health.Heartbeat
+			hb.Beat(time.Now().Add(-time.Hour))
+
+			if err := hb.Check(time.Minute)(context.Background()); err == nil {
+				t.Fatalf("\t%s\tShould fail the heartbeat check.", failed)
+			}
+			t.Logf("\t%s\tShould fail the heartbeat check.", success)
+
+			hb.Beat(time.Now())
+			if err := hb.Check(time.Minute)(context.Background()); err != nil {
+				t.Fatalf("\t%s\tShould pass once the worker beats again : %s.", failed, err)
+			}
+			t.Logf("\t%s\tShould pass once the worker beats again.", success)
+		}
+	}
+}
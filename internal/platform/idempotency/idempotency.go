@@ -0,0 +1,190 @@
+// Package idempotency keeps the outcome of requests sent with an
+// Idempotency-Key so retries can be answered without repeating the work.
+// https://datatracker.ietf.org/doc/draft-ietf-httpapi-idempotency-key-header/
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/db"
+
+	"github.com/pkg/errors"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const keysCollection = "idempotency_keys"
+
+// These are the states of a key.
+const (
+	StateProcessing = "processing"
+	StateComplete   = "complete"
+)
+
+// Record is the state of a single key. Expired records are removed by a
+// TTL index on expires_at.
+type Record struct {
+	Key         string      `bson:"_id"`
+	Fingerprint string      `bson:"fingerprint"`
+	State       string      `bson:"state"`
+	Status      int         `bson:"status,omitempty"`
+	Header      http.Header `bson:"header,omitempty"`
+	Body        []byte      `bson:"body,omitempty"`
+	LockedUntil time.Time   `bson:"locked_until"`
+	ExpiresAt   time.Time   `bson:"expires_at"`
+}
+
+// Store keeps the records. Implementations must be safe for concurrent
+// use.
+type Store interface {
+
+	// Begin claims the key for a request with the fingerprint. It returns
+	// the new record and true when the caller owns the key and must process
+	// the request, then Complete or Release it. The key stays locked to the
+	// caller until lock has passed.
+	//
+	// Otherwise it returns the existing record, which may belong to a
+	// request still being processed. A record left processing past its
+	// lock, because its owner died, is taken over by a request with the
+	// same fingerprint.
+	Begin(ctx context.Context, key, fingerprint string, now time.Time, lock, ttl time.Duration) (*Record, bool, error)
+
+	// Complete stores the response sent for the key so later requests with
+	// the key receive it too.
+	Complete(ctx context.Context, key string, status int, header http.Header, body []byte) error
+
+	// Release gives up the key without storing a response so the request
+	// can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+// MongoStore keeps records in Mongo so every instance of the service shares
+// the same keys.
+type MongoStore struct {
+	MasterDB *db.DB
+}
+
+// NewMongoStore returns a MongoStore using the master database. It creates
+// the index that removes expired records.
+func NewMongoStore(ctx context.Context, masterDB *db.DB) (*MongoStore, error) {
+	dbConn := masterDB.Copy()
+	defer dbConn.Close()
+
+	idx := mgo.Index{
+		Key:         []string{"expires_at"},
+		ExpireAfter: time.Second,
+	}
+	f := func(collection *mgo.Collection) error {
+		return collection.EnsureIndex(idx)
+	}
+	if err := dbConn.ExecuteWrite(ctx, keysCollection, f); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.%s.ensureIndex(%s)", keysCollection, db.Query(idx.Key)))
+	}
+
+	s := MongoStore{
+		MasterDB: masterDB,
+	}
+
+	return &s, nil
+}
+
+// Begin implements the Store interface.
+func (s *MongoStore) Begin(ctx context.Context, key, fingerprint string, now time.Time, lock, ttl time.Duration) (*Record, bool, error) {
+	dbConn := s.MasterDB.Copy()
+	defer dbConn.Close()
+
+	now = now.Truncate(time.Millisecond)
+
+	rec := Record{
+		Key:         key,
+		Fingerprint: fingerprint,
+		State:       StateProcessing,
+		LockedUntil: now.Add(lock),
+		ExpiresAt:   now.Add(ttl),
+	}
+
+	f := func(collection *mgo.Collection) error {
+		return collection.Insert(&rec)
+	}
+	err := dbConn.ExecuteWrite(ctx, keysCollection, f)
+	if err == nil {
+		return &rec, true, nil
+	}
+	if !mgo.IsDup(err) {
+		return nil, false, errors.Wrap(err, fmt.Sprintf("db.%s.insert(%s)", keysCollection, db.Query(key)))
+	}
+
+	var ex Record
+	f = func(collection *mgo.Collection) error {
+		return collection.FindId(key).One(&ex)
+	}
+	if err := dbConn.Execute(ctx, keysCollection, f); err != nil {
+		if err == mgo.ErrNotFound {
+
+			// The record expired between the insert and the find. Report
+			// it as in progress and let the caller try again.
+			return &Record{Key: key, Fingerprint: fingerprint, State: StateProcessing}, false, nil
+		}
+		return nil, false, errors.Wrap(err, fmt.Sprintf("db.%s.find(%s)", keysCollection, db.Query(key)))
+	}
+
+	if ex.State != StateProcessing || ex.Fingerprint != fingerprint || ex.LockedUntil.After(now) {
+		return &ex, false, nil
+	}
+
+	// Take over the abandoned key. Only one request wins when several try.
+	q := bson.M{"_id": key, "state": StateProcessing, "locked_until": ex.LockedUntil}
+	m := bson.M{"$set": bson.M{"locked_until": rec.LockedUntil, "expires_at": rec.ExpiresAt}}
+	f = func(collection *mgo.Collection) error {
+		return collection.Update(q, m)
+	}
+	switch err := dbConn.ExecuteWrite(ctx, keysCollection, f); err {
+	case nil:
+		return &rec, true, nil
+	case mgo.ErrNotFound:
+		return &ex, false, nil
+	default:
+		return nil, false, errors.Wrap(err, fmt.Sprintf("db.%s.update(%s, %s)", keysCollection, db.Query(q), db.Query(m)))
+	}
+}
+
+// Complete implements the Store interface.
+func (s *MongoStore) Complete(ctx context.Context, key string, status int, header http.Header, body []byte) error {
+	dbConn := s.MasterDB.Copy()
+	defer dbConn.Close()
+
+	q := bson.M{"_id": key}
+	m := bson.M{"$set": bson.M{
+		"state":  StateComplete,
+		"status": status,
+		"header": header,
+		"body":   body,
+	}}
+
+	f := func(collection *mgo.Collection) error {
+		return collection.Update(q, m)
+	}
+	if err := dbConn.ExecuteWrite(ctx, keysCollection, f); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("db.%s.update(%s)", keysCollection, db.Query(q)))
+	}
+
+	return nil
+}
+
+// Release implements the Store interface.
+func (s *MongoStore) Release(ctx context.Context, key string) error {
+	dbConn := s.MasterDB.Copy()
+	defer dbConn.Close()
+
+	f := func(collection *mgo.Collection) error {
+		return collection.RemoveId(key)
+	}
+	if err := dbConn.ExecuteWrite(ctx, keysCollection, f); err != nil && err != mgo.ErrNotFound {
+		return errors.Wrap(err, fmt.Sprintf("db.%s.remove(%s)", keysCollection, db.Query(key)))
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package idempotency_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/db"
+	"inventory-optimisation-server/internal/platform/idempotency"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// TestMongoStore validates a key is owned by a single request and its
+// response kept for the requests after it. It needs a Mongo server, set
+// TEST_DB_HOST to run it.
+func TestMongoStore(t *testing.T) {
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		t.Skip("TEST_DB_HOST is not set")
+	}
+
+	ctx := context.Background()
+
+	masterDB, err := db.New(host, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer masterDB.Close()
+
+	t.Log("Given the need to keep the outcome of requests.")
+	{
+		s, err := idempotency.NewMongoStore(ctx, masterDB)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create the store : %s.", failed, err)
+		}
+		t.Logf("\t%s\tShould be able to create the store.", success)
+
+		var indexes []mgo.Index
+		f := func(collection *mgo.Collection) error {
+			var err error
+			indexes, err = collection.Indexes()
+			return err
+		}
+		if err := masterDB.Execute(ctx, "idempotency_keys", f); err != nil {
+			t.Fatal(err)
+		}
+		var ttl bool
+		for _, idx := range indexes {
+			if len(idx.Key) == 1 && idx.Key[0] == "expires_at" && idx.ExpireAfter > 0 {
+				ttl = true
+			}
+		}
+		if !ttl {
+			t.Fatalf("\t%s\tShould create the TTL index : got %+v.", failed, indexes)
+		}
+		t.Logf("\t%s\tShould create the TTL index.", success)
+
+		t.Log("\tWhen two requests begin with the same key.")
+		{
+			key := "test:" + bson.NewObjectId().Hex()
+			now := time.Now()
+
+			if _, owned, err := s.Begin(ctx, key, "f", now, time.Minute, time.Hour); err != nil || !owned {
+				t.Fatalf("\t%s\tShould let the first own the key : %v %v.", failed, owned, err)
+			}
+			t.Logf("\t%s\tShould let the first own the key.", success)
+
+			if rec, owned, err := s.Begin(ctx, key, "f", now, time.Minute, time.Hour); err != nil || owned || rec.State != idempotency.StateProcessing {
+				t.Fatalf("\t%s\tShould not let the second own the key : %v %v.", failed, owned, err)
+			}
+			t.Logf("\t%s\tShould not let the second own the key.", success)
+
+			if err := s.Complete(ctx, key, http.StatusCreated, nil, []byte("{}")); err != nil {
+				t.Fatalf("\t%s\tShould be able to complete the key : %s.", failed, err)
+			}
+			rec, owned, err := s.Begin(ctx, key, "f", now, time.Minute, time.Hour)
+			if err != nil || owned || rec.State != idempotency.StateComplete || rec.Status != http.StatusCreated {
+				t.Fatalf("\t%s\tShould keep the response : %+v %v.", failed, rec, err)
+			}
+			t.Logf("\t%s\tShould keep the response.", success)
+		}
+	}
+}
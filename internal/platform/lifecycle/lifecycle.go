@@ -0,0 +1,257 @@
+// Package lifecycle starts the long running parts of the service and stops
+// them in order when it is asked to shut down, giving each a chance to
+// finish its in-flight work.
+package lifecycle
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/log"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultTimeout bounds the stop of a component registered without a
+// timeout.
+const DefaultTimeout = 5 * time.Second
+
+// Component is a part of the service with work to start and stop.
+type Component struct {
+	Name string
+
+	// Start runs the component and blocks until it stops. It returns nil
+	// once stopped by Stop, any error means the component failed and the
+	// service shuts down. Components with nothing to run, such as a
+	// database session, leave it nil.
+	Start func() error
+
+	// Stop asks the component to finish its in-flight work and stop. It
+	// must give up when the context is done.
+	Stop func(ctx context.Context) error
+
+	// Timeout bounds Stop, DefaultTimeout applies when zero.
+	Timeout time.Duration
+}
+
+// reload is a registered reload hook.
+type reload struct {
+	name string
+	f    func() error
+}
+
+// Manager runs the components of the service. Components are started in
+// the order they are added and stopped in reverse, so a component can rely
+// on those added before it for as long as it runs.
+type Manager struct {
+	log        *log.Logger
+	components []Component
+	reloads    []reload
+}
+
+// New returns a Manager logging to log.
+func New(log *log.Logger) *Manager {
+	return &Manager{
+		log: log,
+	}
+}
+
+// Add registers a component.
+func (m *Manager) Add(c Component) {
+	m.components = append(m.components, c)
+}
+
+// OnReload registers a function called on SIGHUP to reload state kept
+// outside the process, such as renewed certificates. Only what is
+// registered is reloaded.
+func (m *Manager) OnReload(name string, f func() error) {
+	m.reloads = append(m.reloads, reload{name: name, f: f})
+}
+
+// Run starts every component and blocks until a component fails or a
+// signal other than SIGHUP is received from sigs, then stops every
+// component. SIGHUP calls the reload hooks. It returns the failure, if any,
+// or the first error stopping a component.
+func (m *Manager) Run(sigs <-chan os.Signal) error {
+
+	// Buffer a result per component so they can exit while we are not
+	// listening.
+	failed := make(chan error, len(m.components))
+
+	var wg sync.WaitGroup
+	for _, c := range m.components {
+		if c.Start == nil {
+			continue
+		}
+
+		m.log.Info("starting component", "component", c.Name)
+
+		wg.Add(1)
+		go func(c Component) {
+			defer wg.Done()
+			if err := c.Start(); err != nil {
+				failed <- errors.Wrap(err, c.Name)
+			}
+		}(c)
+	}
+
+	var runErr error
+wait:
+	for {
+		select {
+		case runErr = <-failed:
+			m.log.Error("component failed", "error", runErr)
+			break wait
+
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				m.reload()
+				continue
+			}
+			m.log.Info("start shutdown", "signal", sig.String())
+			break wait
+		}
+	}
+
+	stopErr := m.stop()
+
+	// Every component has been asked to stop and given its time to do so.
+	// Those that ignored it are left behind rather than holding up exit.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(DefaultTimeout):
+		m.log.Warn("components still running after shutdown")
+	}
+
+	if runErr != nil {
+		return runErr
+	}
+	return stopErr
+}
+
+// stop stops the components in reverse order.
+func (m *Manager) stop() error {
+	var first error
+
+	for i := len(m.components) - 1; i >= 0; i-- {
+		c := m.components[i]
+		if c.Stop == nil {
+			continue
+		}
+
+		timeout := c.Timeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := c.Stop(ctx)
+		cancel()
+
+		if err != nil {
+			m.log.Warn("component did not stop cleanly", "component", c.Name, "timeout", timeout, "error", err)
+			if first == nil {
+				first = errors.Wrap(err, c.Name)
+			}
+			continue
+		}
+		m.log.Info("stopped component", "component", c.Name, "took", time.Since(start))
+	}
+
+	return first
+}
+
+// reload calls every reload hook. A hook that fails is logged and the
+// others still run.
+func (m *Manager) reload() {
+	m.log.Info("reloading")
+
+	for _, r := range m.reloads {
+		if err := r.f(); err != nil {
+			m.log.Error("reloading", "part", r.name, "error", err)
+			continue
+		}
+		m.log.Info("reloaded", "part", r.name)
+	}
+}
+
+// Every returns a component calling f every interval until it is stopped. A
+// call in progress when the component is stopped is given the component's
+// timeout to finish.
+func Every(name string, interval time.Duration, f func(now time.Time)) Component {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	c := Component{
+		Name: name,
+		Start: func() error {
+			defer close(done)
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-stop:
+					return nil
+				case now := <-ticker.C:
+					f(now)
+				}
+			}
+		},
+		Stop: func(ctx context.Context) error {
+			close(stop)
+
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+
+	return c
+}
+
+// Server returns a component serving HTTP, over TLS when the server has a
+// TLS configuration. Stopping it lets requests in flight finish within the
+// timeout before the remaining connections are closed.
+func Server(name string, srv *http.Server, timeout time.Duration) Component {
+	c := Component{
+		Name: name,
+		Start: func() error {
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+
+			if err == http.ErrServerClosed {
+				return nil
+			}
+			return err
+		},
+		Stop: func(ctx context.Context) error {
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+				return err
+			}
+			return nil
+		},
+		Timeout: timeout,
+	}
+
+	return c
+}
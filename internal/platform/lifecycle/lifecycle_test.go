@@ -0,0 +1,138 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/lifecycle"
+	"inventory-optimisation-server/internal/platform/log"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// TestManager validates components are stopped in reverse order once the
+// service is asked to shut down or a component fails.
+func TestManager(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	record := func(e string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	}
+
+	// component runs until it is stopped, or fails with err when err is
+	// not nil.
+	component := func(name string, err error) lifecycle.Component {
+		stop := make(chan struct{})
+		return lifecycle.Component{
+			Name: name,
+			Start: func() error {
+				if err != nil {
+					return err
+				}
+				<-stop
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				record("stop " + name)
+				close(stop)
+				return nil
+			},
+		}
+	}
+
+	t.Log("Given the need to shut the service down in order.")
+	{
+		t.Log("\tWhen asked to reload and then terminate.")
+		{
+			events = nil
+
+			m := lifecycle.New(log.New(ioutil.Discard, log.ErrorLevel))
+			m.Add(lifecycle.Component{
+				Name: "db",
+				Stop: func(ctx context.Context) error {
+					record("stop db")
+					return nil
+				},
+			})
+			m.Add(component("worker", nil))
+			m.Add(component("api", nil))
+			m.OnReload("certificate", func() error {
+				record("reload")
+				return nil
+			})
+
+			sigs := make(chan os.Signal, 2)
+			sigs <- syscall.SIGHUP
+			sigs <- syscall.SIGTERM
+
+			if err := m.Run(sigs); err != nil {
+				t.Fatalf("\t%s\tShould shut down cleanly : %v.", failed, err)
+			}
+			t.Logf("\t%s\tShould shut down cleanly.", success)
+
+			want := []string{"reload", "stop api", "stop worker", "stop db"}
+			if !reflect.DeepEqual(events, want) {
+				t.Fatalf("\t%s\tShould reload then stop in reverse order : got %v.", failed, events)
+			}
+			t.Logf("\t%s\tShould reload then stop in reverse order.", success)
+		}
+
+		t.Log("\tWhen a component fails.")
+		{
+			events = nil
+			fail := errors.New("address in use")
+
+			m := lifecycle.New(log.New(ioutil.Discard, log.ErrorLevel))
+			m.Add(component("worker", nil))
+			m.Add(component("api", fail))
+
+			err := m.Run(make(chan os.Signal))
+			if err == nil || err.Error() != "api: address in use" {
+				t.Fatalf("\t%s\tShould report the failure : got %v.", failed, err)
+			}
+			t.Logf("\t%s\tShould report the failure.", success)
+
+			want := []string{"stop api", "stop worker"}
+			if !reflect.DeepEqual(events, want) {
+				t.Fatalf("\t%s\tShould stop every component : got %v.", failed, events)
+			}
+			t.Logf("\t%s\tShould stop every component.", success)
+		}
+
+		t.Log("\tWhen a component does not stop in time.")
+		{
+			stuck := lifecycle.Every("stuck", time.Millisecond, func(time.Time) {
+				time.Sleep(time.Second)
+			})
+			stuck.Timeout = 10 * time.Millisecond
+
+			m := lifecycle.New(log.New(ioutil.Discard, log.ErrorLevel))
+			m.Add(stuck)
+
+			sigs := make(chan os.Signal, 1)
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				sigs <- syscall.SIGTERM
+			}()
+
+			if err := m.Run(sigs); err == nil {
+				t.Fatalf("\t%s\tShould report the component did not stop.", failed)
+			}
+			t.Logf("\t%s\tShould report the component did not stop.", success)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// LevelHandler returns a handler for the debug server that reports the
+// current level on GET and changes it on PUT or POST with ?level=debug.
+func (l *Logger) LevelHandler() http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+		case "PUT", "POST":
+			level, err := ParseLevel(r.URL.Query().Get("level"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if old := l.Level(); old != level {
+				l.SetLevel(level)
+				l.Info("log level changed", "from", old, "to", level)
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Level string `json:"level"`
+		}{
+			Level: l.Level().String(),
+		})
+	}
+
+	return http.HandlerFunc(f)
+}
@@ -0,0 +1,218 @@
+// Package log provides leveled, structured logging. Every entry is written as
+// a single line of JSON holding the time, level, message and caller followed
+// by any key/value fields attached to the logger or the call.
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int32
+
+// These are the supported levels, from most to least verbose.
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+// String implements the fmt.Stringer interface.
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int32(l))
+}
+
+// ParseLevel returns the Level named by s.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return DebugLevel, nil
+	case "info", "":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q", s)
+}
+
+// output is the destination shared by a logger and all of its children.
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Logger writes structured log entries. Child loggers created with With
+// share the output and level of their parent.
+type Logger struct {
+	out    *output
+	level  *int32
+	fields []interface{}
+}
+
+// New returns a Logger writing entries at or above the level to w. The
+// key/value pairs are added to every entry.
+func New(w io.Writer, level Level, kv ...interface{}) *Logger {
+	lvl := int32(level)
+
+	l := Logger{
+		out:    &output{w: w},
+		level:  &lvl,
+		fields: kv,
+	}
+
+	return &l
+}
+
+// With returns a child logger that adds the key/value pairs to every entry.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+
+	child := Logger{
+		out:    l.out,
+		level:  l.level,
+		fields: fields,
+	}
+
+	return &child
+}
+
+// Level returns the current minimum level.
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(l.level))
+}
+
+// SetLevel changes the minimum level for the logger, its parent and all of
+// their children.
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(l.level, int32(level))
+}
+
+// SetLevelName changes the minimum level to the one named by name. It is
+// convenient where the package name is shadowed by a logger variable.
+func (l *Logger) SetLevelName(name string) error {
+	level, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	l.SetLevel(level)
+	return nil
+}
+
+// Debug writes an entry at DebugLevel.
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.write(DebugLevel, msg, kv)
+}
+
+// Info writes an entry at InfoLevel.
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.write(InfoLevel, msg, kv)
+}
+
+// Warn writes an entry at WarnLevel.
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.write(WarnLevel, msg, kv)
+}
+
+// Error writes an entry at ErrorLevel.
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.write(ErrorLevel, msg, kv)
+}
+
+// Fatal writes an entry at ErrorLevel and terminates the program.
+func (l *Logger) Fatal(msg string, kv ...interface{}) {
+	l.write(ErrorLevel, msg, kv)
+	os.Exit(1)
+}
+
+// write formats and writes a single entry if the level is enabled.
+func (l *Logger) write(level Level, msg string, kv []interface{}) {
+	if level < l.Level() {
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`{"ts":`)
+	writeValue(&b, time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeValue(&b, level.String())
+	b.WriteString(`,"msg":`)
+	writeValue(&b, msg)
+
+	// Skip write and the exported level method to report our caller.
+	if _, file, line, ok := runtime.Caller(2); ok {
+		b.WriteString(`,"caller":`)
+		writeValue(&b, fmt.Sprintf("%s:%d", filepath.Base(file), line))
+	}
+
+	writeFields(&b, l.fields)
+	writeFields(&b, kv)
+	b.WriteString("}\n")
+
+	l.out.mu.Lock()
+	l.out.w.Write(b.Bytes())
+	l.out.mu.Unlock()
+}
+
+// writeFields appends the key/value pairs. A trailing key without a value
+// is reported under the key "!BADKEY" so it is not silently lost.
+func writeFields(b *bytes.Buffer, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		var k string
+		var v interface{}
+
+		if i+1 < len(kv) {
+			k, v = fmt.Sprint(kv[i]), kv[i+1]
+		} else {
+			k, v = "!BADKEY", kv[i]
+		}
+
+		b.WriteByte(',')
+		writeValue(b, k)
+		b.WriteByte(':')
+		writeValue(b, v)
+	}
+}
+
+// writeValue appends the JSON encoding of v. Errors, durations and other
+// values that do not encode well are written as strings.
+func writeValue(b *bytes.Buffer, v interface{}) {
+	switch t := v.(type) {
+	case error:
+		v = t.Error()
+	case time.Duration:
+		v = t.String()
+	case fmt.Stringer:
+		v = t.String()
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprintf("%+v", v))
+	}
+	b.Write(data)
+}
@@ -0,0 +1,75 @@
+package log_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"inventory-optimisation-server/internal/platform/log"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// TestLogger validates entries are filtered by level and written as JSON
+// with the logger and call fields.
+func TestLogger(t *testing.T) {
+	t.Log("Given the need to write structured log entries.")
+	{
+		var buf bytes.Buffer
+		l := log.New(&buf, log.InfoLevel, "service", "api").With("trace_id", "abc")
+
+		t.Log("\tWhen writing below the minimum level.")
+		{
+			l.Debug("ignored")
+			if buf.Len() != 0 {
+				t.Fatalf("\t%s\tShould not write the entry : %s.", failed, buf.String())
+			}
+			t.Logf("\t%s\tShould not write the entry.", success)
+		}
+
+		t.Log("\tWhen writing at or above the minimum level.")
+		{
+			l.Error("request failed", "error", errors.New("boom"), "status", 500)
+
+			var entry map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("\t%s\tShould write a line of JSON : %s.", failed, err)
+			}
+			t.Logf("\t%s\tShould write a line of JSON.", success)
+
+			want := map[string]interface{}{
+				"level":    "error",
+				"msg":      "request failed",
+				"service":  "api",
+				"trace_id": "abc",
+				"error":    "boom",
+				"status":   float64(500),
+			}
+			for k, v := range want {
+				if entry[k] != v {
+					t.Log("\t\tGot :", entry)
+					t.Fatalf("\t%s\tShould include %q as %v.", failed, k, v)
+				}
+			}
+			t.Logf("\t%s\tShould include the logger and call fields.", success)
+		}
+
+		t.Log("\tWhen changing the level on a child logger.")
+		{
+			buf.Reset()
+			if err := l.SetLevelName("debug"); err != nil {
+				t.Fatalf("\t%s\tShould accept the level name : %s.", failed, err)
+			}
+
+			l.With("user_id", "1").Debug("visible")
+			if buf.Len() == 0 {
+				t.Fatalf("\t%s\tShould write debug entries.", failed)
+			}
+			t.Logf("\t%s\tShould write debug entries.", success)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Package metrics collects counters, gauges and histograms and exposes them
+// in the Prometheus text exposition format.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are the default histogram buckets, in seconds. They suit the
+// latency of HTTP requests and database calls.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metric types as written on the TYPE line.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// value is a float64 that can be updated concurrently.
+type value struct {
+	bits uint64
+}
+
+// add adds v to the value.
+func (val *value) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&val.bits)
+		nu := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&val.bits, old, nu) {
+			return
+		}
+	}
+}
+
+// set replaces the value.
+func (val *value) set(v float64) {
+	atomic.StoreUint64(&val.bits, math.Float64bits(v))
+}
+
+// get returns the value.
+func (val *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&val.bits))
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	v value
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add adds v, which must not be negative, to the counter.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.v.add(v)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v value
+}
+
+// Set replaces the value of the gauge.
+func (g *Gauge) Set(v float64) {
+	g.v.set(v)
+}
+
+// Inc adds one to the gauge.
+func (g *Gauge) Inc() {
+	g.v.add(1)
+}
+
+// Dec subtracts one from the gauge.
+func (g *Gauge) Dec() {
+	g.v.add(-1)
+}
+
+// Add adds v to the gauge.
+func (g *Gauge) Add(v float64) {
+	g.v.add(v)
+}
+
+// Histogram counts observations in configurable buckets.
+type Histogram struct {
+	upper []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// newHistogram returns a histogram using the sorted bucket upper bounds.
+func newHistogram(upper []float64) *Histogram {
+	return &Histogram{
+		upper:  upper,
+		counts: make([]uint64, len(upper)),
+	}
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// snapshot returns the cumulative bucket counts, the count and the sum.
+func (h *Histogram) snapshot() ([]uint64, uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cum := make([]uint64, len(h.counts))
+	var n uint64
+	for i, c := range h.counts {
+		n += c
+		cum[i] = n
+	}
+
+	return cum, h.count, h.sum
+}
+
+// family is a named metric with one series per combination of label values.
+type family struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	new    func() interface{}
+
+	mu     sync.RWMutex
+	series map[string]interface{}
+	values map[string][]string
+}
+
+// with returns the series for the label values, creating it on first use.
+// Missing values are treated as empty and extra values are ignored.
+func (f *family) with(lvs []string) interface{} {
+	vals := make([]string, len(f.labels))
+	copy(vals, lvs)
+	key := strings.Join(vals, "\xff")
+
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s, ok := f.series[key]; ok {
+		return s
+	}
+
+	s = f.new()
+	f.series[key] = s
+	f.values[key] = vals
+	return s
+}
+
+// CounterVec is a set of counters partitioned by label values.
+type CounterVec struct {
+	f *family
+}
+
+// With returns the counter for the label values, in the order the labels
+// were declared.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return v.f.with(labelValues).(*Counter)
+}
+
+// GaugeVec is a set of gauges partitioned by label values.
+type GaugeVec struct {
+	f *family
+}
+
+// With returns the gauge for the label values, in the order the labels were
+// declared.
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return v.f.with(labelValues).(*Gauge)
+}
+
+// HistogramVec is a set of histograms partitioned by label values.
+type HistogramVec struct {
+	f *family
+}
+
+// With returns the histogram for the label values, in the order the labels
+// were declared.
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return v.f.with(labelValues).(*Histogram)
+}
@@ -0,0 +1,59 @@
+package metrics_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"inventory-optimisation-server/internal/platform/metrics"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// TestWriteTo validates metrics are written in the Prometheus text format.
+func TestWriteTo(t *testing.T) {
+	r := metrics.NewRegistry()
+
+	req := r.NewCounterVec("requests_total", "Requests handled.", "route", "status")
+	lat := r.NewHistogramVec("latency_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	r.NewGaugeFunc("answer", "The answer.", func() float64 { return 42 })
+
+	req.With("/v1/users/:id", "200").Inc()
+	req.With("/v1/users/:id", "200").Inc()
+	req.With(`/v1/"odd"`, "500").Add(3)
+	lat.With("/v1/users").Observe(0.05)
+	lat.With("/v1/users").Observe(0.5)
+	lat.With("/v1/users").Observe(5)
+
+	t.Log("Given the need to expose metrics to Prometheus.")
+	{
+		var buf bytes.Buffer
+		if _, err := r.WriteTo(&buf); err != nil {
+			t.Fatalf("\t%s\tShould be able to write the metrics : %s.", failed, err)
+		}
+		t.Logf("\t%s\tShould be able to write the metrics.", success)
+
+		want := []string{
+			"# HELP answer The answer.\n# TYPE answer gauge\nanswer 42\n",
+			"# TYPE requests_total counter\n",
+			`requests_total{route="/v1/users/:id",status="200"} 2`,
+			`requests_total{route="/v1/\"odd\"",status="500"} 3`,
+			"# TYPE latency_seconds histogram\n",
+			`latency_seconds_bucket{route="/v1/users",le="0.1"} 1`,
+			`latency_seconds_bucket{route="/v1/users",le="1"} 2`,
+			`latency_seconds_bucket{route="/v1/users",le="+Inf"} 3`,
+			`latency_seconds_sum{route="/v1/users"} 5.55`,
+			`latency_seconds_count{route="/v1/users"} 3`,
+		}
+		for _, w := range want {
+			if !strings.Contains(buf.String(), w) {
+				t.Log("\t\tGot :\n", buf.String())
+				t.Fatalf("\t%s\tShould contain %q.", failed, w)
+			}
+		}
+		t.Logf("\t%s\tShould contain every series.", success)
+	}
+}
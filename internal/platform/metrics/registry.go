@@ -0,0 +1,306 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds a set of metrics to expose together.
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]*family
+	funcs    map[string]gaugeFunc
+}
+
+// gaugeFunc is a gauge whose value is read when the metrics are written.
+type gaugeFunc struct {
+	help string
+	f    func() float64
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+		funcs:    make(map[string]gaugeFunc),
+	}
+}
+
+// Default is the registry used by the package level functions.
+var Default = NewRegistry()
+
+// register adds the family to the registry. Like expvar, registering the
+// same name twice is a programming error and panics.
+func (r *Registry) register(f *family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.families[f.name]; ok {
+		panic("metrics: duplicate metric " + f.name)
+	}
+	if _, ok := r.funcs[f.name]; ok {
+		panic("metrics: duplicate metric " + f.name)
+	}
+
+	f.series = make(map[string]interface{})
+	f.values = make(map[string][]string)
+	r.families[f.name] = f
+}
+
+// NewCounterVec registers a counter partitioned by the labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	f := family{
+		name:   name,
+		help:   help,
+		typ:    typeCounter,
+		labels: labels,
+		new:    func() interface{} { return &Counter{} },
+	}
+	r.register(&f)
+	return &CounterVec{f: &f}
+}
+
+// NewGaugeVec registers a gauge partitioned by the labels.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	f := family{
+		name:   name,
+		help:   help,
+		typ:    typeGauge,
+		labels: labels,
+		new:    func() interface{} { return &Gauge{} },
+	}
+	r.register(&f)
+	return &GaugeVec{f: &f}
+}
+
+// NewHistogramVec registers a histogram partitioned by the labels. If
+// buckets is empty DefBuckets is used.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	upper := append([]float64(nil), buckets...)
+	sort.Float64s(upper)
+
+	f := family{
+		name:   name,
+		help:   help,
+		typ:    typeHistogram,
+		labels: labels,
+		new:    func() interface{} { return newHistogram(upper) },
+	}
+	r.register(&f)
+	return &HistogramVec{f: &f}
+}
+
+// NewGaugeFunc registers a gauge whose value is provided by f each time the
+// metrics are written.
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.families[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+	if _, ok := r.funcs[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+
+	r.funcs[name] = gaugeFunc{help: help, f: f}
+}
+
+// NewCounterVec registers a counter with the Default registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewGaugeVec registers a gauge with the Default registry.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// NewHistogramVec registers a histogram with the Default registry.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewGaugeFunc registers a gauge function with the Default registry.
+func NewGaugeFunc(name, help string, f func() float64) {
+	Default.NewGaugeFunc(name, help, f)
+}
+
+// WriteTo writes every metric in the text exposition format. Metrics and
+// series are sorted so the output is stable between scrapes.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.families)+len(r.funcs))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	for name := range r.funcs {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+
+	cw := countWriter{w: bufio.NewWriter(w)}
+
+	for _, name := range names {
+		r.mu.RLock()
+		f, ok := r.families[name]
+		gf := r.funcs[name]
+		r.mu.RUnlock()
+
+		if !ok {
+			writeHeader(&cw, name, gf.help, typeGauge)
+			fmt.Fprintf(&cw, "%s %s\n", name, formatFloat(gf.f()))
+			continue
+		}
+
+		writeFamily(&cw, f)
+	}
+
+	if err := cw.w.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+// Handler returns a handler serving the registry to a Prometheus scraper.
+func (r *Registry) Handler() http.Handler {
+	f := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	}
+	return http.HandlerFunc(f)
+}
+
+// Handler returns a handler serving the Default registry.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// writeFamily writes every series of a metric.
+func writeFamily(w io.Writer, f *family) {
+	f.mu.RLock()
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	f.mu.RUnlock()
+
+	// A metric without labels is worth reporting as zero before its first
+	// update.
+	if len(keys) == 0 && len(f.labels) == 0 {
+		f.with(nil)
+		keys = append(keys, "")
+	}
+	sort.Strings(keys)
+
+	writeHeader(w, f.name, f.help, f.typ)
+
+	for _, k := range keys {
+		f.mu.RLock()
+		s, vals := f.series[k], f.values[k]
+		f.mu.RUnlock()
+
+		switch m := s.(type) {
+		case *Counter:
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelPairs(f.labels, vals, "", 0), formatFloat(m.v.get()))
+
+		case *Gauge:
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelPairs(f.labels, vals, "", 0), formatFloat(m.v.get()))
+
+		case *Histogram:
+			cum, count, sum := m.snapshot()
+			for i, upper := range m.upper {
+				fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelPairs(f.labels, vals, "le", upper), cum[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelPairs(f.labels, vals, "le", math.Inf(1)), count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelPairs(f.labels, vals, "", 0), formatFloat(sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelPairs(f.labels, vals, "", 0), count)
+		}
+	}
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric.
+func writeHeader(w io.Writer, name, help, typ string) {
+	if help != "" {
+		help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// labelValue escapes a label value for the exposition format.
+var labelValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelPairs formats the labels as {a="x",b="y"}. When extra is set it is
+// added as a final label with the value v, as used for histogram buckets.
+func labelPairs(labels, values []string, extra string, v float64) string {
+	if len(labels) == 0 && extra == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l)
+		b.WriteString(`="`)
+		b.WriteString(labelValue.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	if extra != "" {
+		if len(labels) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra)
+		b.WriteString(`="`)
+		b.WriteString(formatFloat(v))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// formatFloat formats a sample value the way Prometheus expects.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countWriter counts the bytes written and remembers the first error.
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+// Write implements the io.Writer interface.
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,358 @@
+// Package oidc implements the relying party side of the OpenID Connect
+// authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// Config describes the client registration with the identity provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// GroupsClaim names the ID token claim holding the user's groups.
+	GroupsClaim string
+}
+
+// Identity is the verified information about a user taken from an ID token.
+type Identity struct {
+	Issuer  string
+	Subject string
+	Email   string
+	Name    string
+	Groups  []string
+
+	// EmailVerified is set when the provider asserts the user proved they
+	// own the email address.
+	EmailVerified bool
+}
+
+// discovery is the subset of the provider metadata document we use.
+// https://openid.net/specs/openid-connect-discovery-1_0.html
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect identity provider discovered from its issuer
+// URL. It is safe for concurrent use.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+	meta   discovery
+	parser *jwt.Parser
+
+	mu   sync.RWMutex
+	keys map[string]*rsa.PublicKey
+}
+
+// NewProvider fetches the discovery document for the configured issuer and
+// returns a Provider ready to use. If client is nil, http.DefaultClient is
+// used.
+func NewProvider(ctx context.Context, client *http.Client, cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" {
+		return nil, errors.New("issuer cannot be blank")
+	}
+	if cfg.ClientID == "" {
+		return nil, errors.New("client id cannot be blank")
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	p := Provider{
+		cfg:    cfg,
+		client: client,
+		keys:   make(map[string]*rsa.PublicKey),
+
+		// Only accept asymmetric signatures so a token cannot be forged with
+		// the client secret.
+		parser: &jwt.Parser{
+			ValidMethods: []string{"RS256", "RS384", "RS512"},
+		},
+	}
+
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &p.meta); err != nil {
+		return nil, errors.Wrap(err, "fetching discovery document")
+	}
+
+	// The issuer in the document must match the one we were configured with
+	// exactly, otherwise tokens from another issuer could be accepted.
+	if p.meta.Issuer != cfg.Issuer {
+		return nil, errors.Errorf("issuer mismatch: configured %q, discovered %q", cfg.Issuer, p.meta.Issuer)
+	}
+
+	return &p, nil
+}
+
+// AuthCodeURL returns the URL to redirect the user to for login.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	scopes := p.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid"}
+	}
+
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return p.meta.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange trades an authorization code for tokens at the token endpoint and
+// returns the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	v := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.cfg.ClientSecret != "" {
+		v.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequest("POST", p.meta.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", errors.Wrap(err, "creating token request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "calling token endpoint")
+	}
+	defer resp.Body.Close()
+
+	var tkn struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tkn); err != nil {
+		return "", errors.Wrapf(err, "decoding token response, status %d", resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusOK || tkn.Error != "" {
+		return "", errors.Errorf("token endpoint status %d: %s %s", resp.StatusCode, tkn.Error, tkn.ErrorDescription)
+	}
+	if tkn.IDToken == "" {
+		return "", errors.New("token response is missing the id_token")
+	}
+
+	return tkn.IDToken, nil
+}
+
+// Verify validates the signature and claims of an ID token and returns the
+// identity it asserts. The nonce must match the one sent with the
+// authorization request.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (Identity, error) {
+	f := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	}
+
+	claims := jwt.MapClaims{}
+	tkn, err := p.parser.ParseWithClaims(rawIDToken, claims, f)
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "parsing id token")
+	}
+	if !tkn.Valid {
+		return Identity{}, errors.New("invalid id token")
+	}
+
+	// MapClaims.Valid has checked exp, iat and nbf. The rest is up to us.
+	// https://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation
+	if iss, _ := claims["iss"].(string); iss != p.cfg.Issuer {
+		return Identity{}, errors.Errorf("unexpected issuer %q", iss)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return Identity{}, errors.New("id token has no expiry")
+	}
+	if !hasAudience(claims["aud"], p.cfg.ClientID) {
+		return Identity{}, errors.New("id token was not issued for this client")
+	}
+	if got, _ := claims["nonce"].(string); nonce == "" || got != nonce {
+		return Identity{}, errors.New("id token nonce mismatch")
+	}
+
+	id := Identity{
+		Issuer: p.cfg.Issuer,
+	}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.Name, _ = claims["name"].(string)
+
+	// Some providers send the boolean as a string.
+	switch ev := claims["email_verified"].(type) {
+	case bool:
+		id.EmailVerified = ev
+	case string:
+		id.EmailVerified = ev == "true"
+	}
+
+	if id.Subject == "" {
+		return Identity{}, errors.New("id token has no subject")
+	}
+
+	switch g := claims[p.cfg.GroupsClaim].(type) {
+	case []interface{}:
+		for _, v := range g {
+			if s, ok := v.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	case string:
+		id.Groups = []string{g}
+	}
+
+	return id, nil
+}
+
+// hasAudience reports whether the aud claim, which may be a single string or
+// an array, contains the client id.
+func hasAudience(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the public key for the key id, fetching the provider's key set
+// again when the id is unknown to allow for key rotation.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.RLock()
+	k, ok := p.keys[kid]
+	p.mu.RUnlock()
+	if ok {
+		return k, nil
+	}
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	// Providers with a single key often leave out the kid.
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, nil
+		}
+	}
+
+	k, ok = p.keys[kid]
+	if !ok {
+		return nil, errors.Errorf("unrecognized kid %q", kid)
+	}
+	return k, nil
+}
+
+// refreshKeys replaces the cached keys with the provider's current key set.
+func (p *Provider) refreshKeys(ctx context.Context) error {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.meta.JWKSURI, &set); err != nil {
+		return errors.Wrap(err, "fetching key set")
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return errors.Wrapf(err, "decoding modulus of key %q", k.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return errors.Wrapf(err, "decoding exponent of key %q", k.Kid)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	return nil
+}
+
+// getJSON decodes the JSON document at the url into v.
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,258 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/auth"
+	"inventory-optimisation-server/internal/platform/oidc"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// idp is a minimal stand-in identity provider. It hands out a code for every
+// authorization request and exchanges it for an ID token signed with its key.
+type idp struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]url.Values
+}
+
+// newIDP starts a stand-in identity provider.
+func newIDP(t *testing.T) *idp {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := idp{
+		key:    key,
+		grants: make(map[string]url.Values),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "idp-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		p.mu.Lock()
+		grant, ok := p.grants[r.Form.Get("code")]
+		delete(p.grants, r.Form.Get("code"))
+		p.mu.Unlock()
+
+		if !ok || oidc.Challenge(r.Form.Get("code_verifier")) != grant.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		now := time.Now()
+		tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            p.URL,
+			"sub":            "idp|1234",
+			"aud":            []string{grant.Get("client_id")},
+			"exp":            now.Add(time.Minute).Unix(),
+			"iat":            now.Unix(),
+			"nonce":          grant.Get("nonce"),
+			"email":          "anna@ardanlabs.com",
+			"email_verified": true,
+			"name":           "Anna Walker",
+			"groups":         []string{"planners", "everyone"},
+		})
+		tkn.Header["kid"] = "idp-key"
+
+		signed, err := tkn.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	return &p
+}
+
+// authorize plays the part of the user logging in at the provider and
+// returns the code the provider would send back to the callback.
+func (p *idp) authorize(t *testing.T, authURL string) (code, state string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code = "code-" + u.Query().Get("state")
+
+	p.mu.Lock()
+	p.grants[code] = u.Query()
+	p.mu.Unlock()
+
+	return code, u.Query().Get("state")
+}
+
+// TestProvider validates the full authorization code flow against a stand-in
+// identity provider.
+func TestProvider(t *testing.T) {
+	srv := newIDP(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	t.Log("Given the need to log users in through an identity provider.")
+	{
+		p, err := oidc.NewProvider(ctx, srv.Client(), oidc.Config{
+			Issuer:      srv.URL,
+			ClientID:    "inventory",
+			RedirectURL: "http://localhost/v1/auth/oidc/callback",
+			Scopes:      []string{"openid", "email", "groups"},
+		})
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to discover the provider : %s.", failed, err)
+		}
+		t.Logf("\t%s\tShould be able to discover the provider.", success)
+
+		t.Log("\tWhen completing a login.")
+		{
+			ls, err := oidc.NewLoginState(time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code, state := srv.authorize(t, p.AuthCodeURL(ls.State, ls.Nonce, ls.Verifier))
+			if state != ls.State {
+				t.Fatalf("\t%s\tShould send the state to the provider.", failed)
+			}
+			t.Logf("\t%s\tShould send the state to the provider.", success)
+
+			raw, err := p.Exchange(ctx, code, ls.Verifier)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to exchange the code : %s.", failed, err)
+			}
+			t.Logf("\t%s\tShould be able to exchange the code.", success)
+
+			id, err := p.Verify(ctx, raw, ls.Nonce)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to verify the ID token : %s.", failed, err)
+			}
+			t.Logf("\t%s\tShould be able to verify the ID token.", success)
+
+			if id.Subject != "idp|1234" || id.Email != "anna@ardanlabs.com" || !id.EmailVerified || len(id.Groups) != 2 {
+				t.Log("\t\tGot :", id)
+				t.Fatalf("\t%s\tShould get back the asserted identity.", failed)
+			}
+			t.Logf("\t%s\tShould get back the asserted identity.", success)
+
+			roles := auth.MapRoles(id.Groups, map[string]string{"planners": "USER", "admins": "ADMIN"})
+			if len(roles) != 1 || roles[0] != "USER" {
+				t.Log("\t\tGot :", roles)
+				t.Fatalf("\t%s\tShould map groups to roles.", failed)
+			}
+			t.Logf("\t%s\tShould map groups to roles.", success)
+
+			if _, err := p.Verify(ctx, raw, "some-other-nonce"); err == nil {
+				t.Fatalf("\t%s\tShould reject a token with the wrong nonce.", failed)
+			}
+			t.Logf("\t%s\tShould reject a token with the wrong nonce.", success)
+		}
+
+		t.Log("\tWhen the PKCE verifier does not match.")
+		{
+			ls, err := oidc.NewLoginState(time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code, _ := srv.authorize(t, p.AuthCodeURL(ls.State, ls.Nonce, ls.Verifier))
+			if _, err := p.Exchange(ctx, code, "not-the-verifier"); err == nil {
+				t.Fatalf("\t%s\tShould fail to exchange the code.", failed)
+			}
+			t.Logf("\t%s\tShould fail to exchange the code.", success)
+		}
+	}
+}
+
+// TestLoginState validates the login state survives a round trip through
+// the browser and can not be read or tampered with.
+func TestLoginState(t *testing.T) {
+	key := []byte("cookie-key")
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Log("Given the need to carry login state between requests.")
+	{
+		ls, err := oidc.NewLoginState(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sealed, err := oidc.Seal(key, ls)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to seal the state : %s.", failed, err)
+		}
+		t.Logf("\t%s\tShould be able to seal the state.", success)
+
+		got, err := oidc.Open(key, sealed, now)
+		if err != nil || got.State != ls.State || got.Verifier != ls.Verifier {
+			t.Fatalf("\t%s\tShould be able to open the state : %v.", failed, err)
+		}
+		t.Logf("\t%s\tShould be able to open the state.", success)
+
+		b, err := base64.RawURLEncoding.DecodeString(sealed)
+		if err != nil || strings.Contains(string(b), ls.Verifier) || strings.Contains(sealed, ls.Verifier) {
+			t.Fatalf("\t%s\tShould not reveal the verifier : %v.", failed, err)
+		}
+		t.Logf("\t%s\tShould not reveal the verifier.", success)
+
+		b[len(b)-1] ^= 1
+		if _, err := oidc.Open(key, base64.RawURLEncoding.EncodeToString(b), now); err == nil {
+			t.Fatalf("\t%s\tShould reject altered state.", failed)
+		}
+		t.Logf("\t%s\tShould reject altered state.", success)
+
+		if _, err := oidc.Open([]byte("other-key"), sealed, now); err == nil {
+			t.Fatalf("\t%s\tShould reject state sealed with another key.", failed)
+		}
+		t.Logf("\t%s\tShould reject state sealed with another key.", success)
+
+		if _, err := oidc.Open(key, sealed, now.Add(oidc.LoginTTL+time.Second)); err == nil {
+			t.Fatalf("\t%s\tShould reject expired state.", failed)
+		}
+		t.Logf("\t%s\tShould reject expired state.", success)
+	}
+}
@@ -0,0 +1,135 @@
+package oidc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LoginTTL bounds how long a user has to complete the login at the provider.
+const LoginTTL = 10 * time.Minute
+
+// RandomString returns a URL safe string holding 32 bytes of randomness. It
+// is suitable for state, nonce and PKCE verifier values.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "reading random bytes")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE code challenge for the verifier.
+// https://tools.ietf.org/html/rfc7636#section-4.2
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// LoginState is what we need to remember between sending the user to the
+// provider and handling the callback.
+type LoginState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Expires  time.Time `json:"expires"`
+}
+
+// NewLoginState generates a fresh state, nonce and verifier valid until
+// LoginTTL after now.
+func NewLoginState(now time.Time) (LoginState, error) {
+	var ls LoginState
+	var err error
+
+	if ls.State, err = RandomString(); err != nil {
+		return LoginState{}, err
+	}
+	if ls.Nonce, err = RandomString(); err != nil {
+		return LoginState{}, err
+	}
+	if ls.Verifier, err = RandomString(); err != nil {
+		return LoginState{}, err
+	}
+	ls.Expires = now.Add(LoginTTL)
+
+	return ls, nil
+}
+
+// Seal encrypts the login state with the key so it can be handed to the
+// browser in a cookie without being read or tampered with. The PKCE
+// verifier in particular must stay secret until the callback.
+func Seal(key []byte, ls LoginState) (string, error) {
+	data, err := json.Marshal(ls)
+	if err != nil {
+		return "", errors.Wrap(err, "encoding login state")
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "reading random bytes")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, data, nil)), nil
+}
+
+// Open decrypts and decodes a value produced by Seal. It fails if the value
+// was altered or the state has expired.
+func Open(key []byte, sealed string, now time.Time) (LoginState, error) {
+	b, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return LoginState{}, errors.New("malformed login state")
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return LoginState{}, err
+	}
+	if len(b) < aead.NonceSize() {
+		return LoginState{}, errors.New("malformed login state")
+	}
+
+	data, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return LoginState{}, errors.New("login state can not be decrypted")
+	}
+
+	var ls LoginState
+	if err := json.Unmarshal(data, &ls); err != nil {
+		return LoginState{}, errors.Wrap(err, "decoding login state")
+	}
+
+	if now.After(ls.Expires) {
+		return LoginState{}, errors.New("login state expired")
+	}
+
+	return ls, nil
+}
+
+// newAEAD returns AES-256-GCM keyed with the SHA-256 of the key, so keys of
+// any length can be configured.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	sum := sha256.Sum256(key)
+
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "creating login state cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating login state cipher")
+	}
+
+	return aead, nil
+}
@@ -0,0 +1,177 @@
+package openapi
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+)
+
+// pageStyle is the only style sheet of the page. Its hash is allowed by
+// PagePolicy so the page needs nothing else.
+const pageStyle = `
+body { font-family: sans-serif; margin: 0 auto; max-width: 60em; padding: 1em; color: #222; }
+h2 { border-bottom: 1px solid #ccc; margin-top: 2em; }
+h3 { font-family: monospace; font-size: 1.1em; }
+.verb { display: inline-block; min-width: 4.5em; text-transform: uppercase; color: #fff; background: #555; padding: 0 .3em; }
+.get { background: #2b6cb0; } .post { background: #2f855a; } .put, .patch { background: #b7791f; } .delete { background: #c53030; }
+table { border-collapse: collapse; margin: .5em 0; }
+th, td { border: 1px solid #ddd; padding: .2em .5em; text-align: left; vertical-align: top; }
+code { font-size: .95em; }
+`
+
+// PagePolicy is the Content-Security-Policy for the page written by
+// WriteHTML. The page loads nothing, not even from our own origin.
+var PagePolicy = "default-src 'none'; style-src 'sha256-" + hash(pageStyle) + "'; frame-ancestors 'none'"
+
+// hash returns the base64 SHA-256 digest of s.
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// page renders a document for people. Everything is rendered on the server
+// so the page runs no script.
+var page = template.Must(template.New("page").Funcs(template.FuncMap{"type": typeName}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Info.Title}} {{.Info.Version}}</title>
+<style>{{.Style}}</style>
+</head>
+<body>
+<h1>{{.Info.Title}} {{.Info.Version}}</h1>
+<p>Paths are relative to <code>{{.Server}}</code>. The machine readable document is <a href="openapi.json">openapi.json</a>.</p>
+{{range .Operations}}
+<h3 id="{{.OperationID}}"><span class="verb {{.Verb}}">{{.Verb}}</span> {{.Path}}</h3>
+{{if or .Summary .Security}}<p>{{.Summary}}{{if .Security}} Requires authentication.{{end}}</p>{{end}}
+{{if .Parameters}}<table>
+<tr><th>Parameter</th><th>In</th><th>Description</th></tr>
+{{range .Parameters}}<tr><td><code>{{.Name}}</code></td><td>{{.In}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>{{end}}
+{{if .RequestBody}}<p>Request: {{range $ct, $mt := .RequestBody.Content}}<code>{{$ct}}</code> {{type $mt.Schema}} {{end}}</p>{{end}}
+{{range $status, $resp := .Responses}}{{if ne $status "default"}}<p>Response {{$status}}: {{range $ct, $mt := $resp.Content}}<code>{{$ct}}</code> {{type $mt.Schema}}{{else}}no content{{end}}</p>{{end}}{{end}}
+{{end}}
+<h2>Models</h2>
+{{range .Schemas}}
+<h3 id="{{.Name}}">{{.Name}}</h3>
+{{if .Schema.Properties}}<table>
+<tr><th>Field</th><th>Type</th><th>Required</th></tr>
+{{range .Properties}}<tr><td><code>{{.Name}}</code></td><td>{{type .Schema}}</td><td>{{if .Required}}yes{{end}}</td></tr>
+{{end}}</table>{{else}}<p>{{type .Schema}}{{with .Schema.Enum}}, one of {{range $i, $e := .}}{{if $i}}, {{end}}<code>{{$e}}</code>{{end}}{{end}}</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// pageOperation is an operation listed on the page.
+type pageOperation struct {
+	Operation
+	Verb string
+	Path string
+}
+
+// pageSchema is a model listed on the page.
+type pageSchema struct {
+	Name       string
+	Schema     *Schema
+	Properties []pageProperty
+}
+
+// pageProperty is a field of a model listed on the page.
+type pageProperty struct {
+	Name     string
+	Schema   *Schema
+	Required bool
+}
+
+// verbs orders the operations on a path.
+var verbs = map[string]int{"get": 0, "post": 1, "put": 2, "patch": 3, "delete": 4}
+
+// WriteHTML writes a page describing the document for people. It is served
+// with PagePolicy as its Content-Security-Policy.
+func WriteHTML(w io.Writer, doc *Document) error {
+	var ops []pageOperation
+	for path, byVerb := range doc.Paths {
+		for verb, op := range byVerb {
+			ops = append(ops, pageOperation{Operation: op, Verb: verb, Path: path})
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].Path != ops[j].Path {
+			return ops[i].Path < ops[j].Path
+		}
+		return verbs[ops[i].Verb] < verbs[ops[j].Verb]
+	})
+
+	var schemas []pageSchema
+	for name, s := range doc.Components.Schemas {
+		ps := pageSchema{Name: name, Schema: s}
+
+		required := make(map[string]bool, len(s.Required))
+		for _, r := range s.Required {
+			required[r] = true
+		}
+		for field, fs := range s.Properties {
+			ps.Properties = append(ps.Properties, pageProperty{Name: field, Schema: fs, Required: required[field]})
+		}
+		sort.Slice(ps.Properties, func(i, j int) bool { return ps.Properties[i].Name < ps.Properties[j].Name })
+
+		schemas = append(schemas, ps)
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Name < schemas[j].Name })
+
+	var server string
+	if len(doc.Servers) > 0 {
+		server = doc.Servers[0].URL
+	}
+
+	data := struct {
+		Info       Info
+		Server     string
+		Style      template.CSS
+		Operations []pageOperation
+		Schemas    []pageSchema
+	}{
+		Info:       doc.Info,
+		Server:     server,
+		Style:      template.CSS(pageStyle),
+		Operations: ops,
+		Schemas:    schemas,
+	}
+
+	return page.Execute(w, data)
+}
+
+// typeName describes the type of values of the schema, linking to models.
+func typeName(s *Schema) template.HTML {
+	switch {
+	case s == nil:
+		return ""
+
+	case s.Ref != "":
+		name := template.HTMLEscapeString(strings.TrimPrefix(s.Ref, refPrefix))
+		return template.HTML(`<a href="#` + name + `">` + name + `</a>`)
+
+	case s.Type == "array":
+		return "array of " + typeName(s.Items)
+
+	case s.AdditionalProperties != nil:
+		return "map of " + typeName(s.AdditionalProperties)
+	}
+
+	t := s.Type
+	if t == "" {
+		t = "any"
+	}
+	if s.Format != "" {
+		t += " (" + s.Format + ")"
+	}
+	if s.Nullable {
+		t += ", nullable"
+	}
+	return template.HTML(template.HTMLEscapeString(t))
+}
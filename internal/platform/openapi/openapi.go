@@ -0,0 +1,249 @@
+// Package openapi generates an OpenAPI 3 document describing the routes
+// mounted on a web.App and the models they accept and return.
+// https://spec.openapis.org/oas/v3.0.3
+package openapi
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"inventory-optimisation-server/internal/platform/web"
+)
+
+// Version is the version of the OpenAPI specification generated.
+const Version = "3.0.3"
+
+// Info describes the API as a whole.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Server is where the API is served.
+type Server struct {
+	URL string `json:"url"`
+}
+
+// Document is the root of an OpenAPI document.
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Servers    []Server                        `json:"servers,omitempty"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+// Components holds the schemas and security schemes referenced by the
+// operations.
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes how callers authenticate.
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// Operation describes a single verb on a path.
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+// Parameter describes a path or query parameter.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the body an operation accepts.
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes a response of an operation.
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a body.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Security scheme names used in the document.
+const (
+	schemeBearer = "bearerAuth"
+	schemeBasic  = "basicAuth"
+)
+
+// Generate documents the routes mounted under the prefix, such as "/v1".
+// Paths in the document are relative to the prefix, which becomes the
+// server URL.
+func Generate(info Info, prefix string, routes []web.Route) *Document {
+	g := newGenerator()
+
+	doc := Document{
+		OpenAPI: Version,
+		Info:    info,
+		Servers: []Server{{URL: prefix}},
+		Paths:   make(map[string]map[string]Operation),
+		Components: Components{
+			SecuritySchemes: map[string]SecurityScheme{
+				schemeBearer: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+				schemeBasic:  {Type: "http", Scheme: "basic"},
+			},
+		},
+	}
+
+	problem := g.schema(web.Problem{})
+	g.problemCodes()
+
+	for _, rt := range routes {
+		if !strings.HasPrefix(rt.Path, prefix+"/") {
+			continue
+		}
+
+		path, params := convertPath(strings.TrimPrefix(rt.Path, prefix))
+
+		op := Operation{
+			OperationID: operationID(rt.Verb, path),
+			Summary:     rt.Doc.Summary,
+			Tags:        []string{tag(path)},
+			Parameters:  params,
+			Responses:   make(map[string]Response),
+		}
+
+		names := make([]string, 0, len(rt.Doc.Query))
+		for name := range rt.Doc.Query {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:        name,
+				In:          "query",
+				Description: rt.Doc.Query[name],
+				Schema:      &Schema{Type: "string"},
+			})
+		}
+
+		if rt.Doc.Request != nil {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content: map[string]MediaType{
+					"application/json": {Schema: g.schema(rt.Doc.Request)},
+				},
+			}
+
+			// A PATCH accepts patches of its request rather than the request
+			// itself, see web.Patch.
+			if rt.Verb == http.MethodPatch {
+				op.RequestBody.Content = map[string]MediaType{
+					web.MergePatch: {Schema: g.schema(rt.Doc.Request)},
+					web.JSONPatch:  {Schema: &Schema{Type: "array", Items: &Schema{Type: "object"}}},
+				}
+			}
+		}
+
+		status := rt.Doc.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		resp := Response{
+			Description: http.StatusText(status),
+		}
+		if rt.Doc.Response != nil {
+			resp.Content = map[string]MediaType{
+				"application/json": {Schema: g.schema(rt.Doc.Response)},
+			}
+		}
+		op.Responses[strconv.Itoa(status)] = resp
+
+		// Every error is sent as a problem.
+		op.Responses["default"] = Response{
+			Description: "Problem",
+			Content: map[string]MediaType{
+				"application/problem+json": {Schema: problem},
+			},
+		}
+
+		switch rt.Doc.Auth {
+		case web.AuthBearer:
+			op.Security = []map[string][]string{{schemeBearer: {}}}
+		case web.AuthBasic:
+			op.Security = []map[string][]string{{schemeBasic: {}}}
+		}
+		if rt.Doc.AuthOptional && op.Security != nil {
+			op.Security = append(op.Security, map[string][]string{})
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]Operation)
+		}
+		doc.Paths[path][strings.ToLower(rt.Verb)] = op
+	}
+
+	doc.Components.Schemas = g.schemas
+
+	return &doc
+}
+
+// convertPath turns the router's :name and *name parameters into OpenAPI
+// {name} parameters.
+func convertPath(path string) (string, []Parameter) {
+	var params []Parameter
+
+	segs := strings.Split(path, "/")
+	for i, s := range segs {
+		if len(s) < 2 || (s[0] != ':' && s[0] != '*') {
+			continue
+		}
+
+		name := s[1:]
+		segs[i] = "{" + name + "}"
+		params = append(params, Parameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+
+	return strings.Join(segs, "/"), params
+}
+
+// operationID derives a stable id such as get_users_id from the verb and
+// path.
+func operationID(verb, path string) string {
+	id := strings.ToLower(verb)
+	for _, s := range strings.Split(path, "/") {
+		s = strings.Trim(s, "{}")
+		s = strings.Replace(s, "-", "_", -1)
+		if s != "" {
+			id += "_" + s
+		}
+	}
+	return id
+}
+
+// tag groups operations by the first segment of their path.
+func tag(path string) string {
+	return strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+}
@@ -0,0 +1,130 @@
+package openapi_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/openapi"
+	"inventory-optimisation-server/internal/platform/web"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// widget is a model with the kinds of fields and tags the API uses.
+type widget struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name" validate:"required,min=3,max=20"`
+	Tags    []string   `json:"tags" validate:"required"`
+	Secret  []byte     `json:"-"`
+	Created time.Time  `json:"date_created"`
+	Deleted *time.Time `json:"deleted_at,omitempty"`
+}
+
+// TestGenerate validates routes and models are turned into an OpenAPI
+// document.
+func TestGenerate(t *testing.T) {
+	routes := []web.Route{
+		{Verb: "GET", Path: "/v1/widgets/:id", Doc: web.Doc{Summary: "Get a widget", Response: widget{}, Auth: web.AuthBearer}},
+		{Verb: "POST", Path: "/v1/widgets", Doc: web.Doc{Request: widget{}, Status: http.StatusCreated}},
+		{Verb: "GET", Path: "/v2/widgets/:id"},
+	}
+
+	t.Log("Given the need to document the API.")
+	{
+		doc := openapi.Generate(openapi.Info{Title: "Test", Version: "v1"}, "/v1", routes)
+
+		if len(doc.Paths) != 2 || doc.Servers[0].URL != "/v1" {
+			t.Log("\t\tGot :", doc.Paths)
+			t.Fatalf("\t%s\tShould only document routes under the prefix.", failed)
+		}
+		t.Logf("\t%s\tShould only document routes under the prefix.", success)
+
+		get, ok := doc.Paths["/widgets/{id}"]["get"]
+		if !ok || len(get.Parameters) != 1 || get.Parameters[0].Name != "id" || get.Parameters[0].In != "path" {
+			t.Log("\t\tGot :", doc.Paths)
+			t.Fatalf("\t%s\tShould turn router parameters into path parameters.", failed)
+		}
+		t.Logf("\t%s\tShould turn router parameters into path parameters.", success)
+
+		if _, ok := get.Responses["default"]; !ok || len(get.Security) != 1 {
+			t.Log("\t\tGot :", get)
+			t.Fatalf("\t%s\tShould document problems and security.", failed)
+		}
+		t.Logf("\t%s\tShould document problems and security.", success)
+
+		if _, ok := doc.Paths["/widgets"]["post"].Responses["201"]; !ok {
+			t.Fatalf("\t%s\tShould document the success status.", failed)
+		}
+		t.Logf("\t%s\tShould document the success status.", success)
+
+		s, ok := doc.Components.Schemas["openapi_test.widget"]
+		if !ok {
+			t.Log("\t\tGot :", doc.Components.Schemas)
+			t.Fatalf("\t%s\tShould add the model to the components.", failed)
+		}
+		t.Logf("\t%s\tShould add the model to the components.", success)
+
+		if _, ok := s.Properties["Secret"]; ok || s.Properties["date_created"].Format != "date-time" || !s.Properties["deleted_at"].Nullable {
+			t.Log("\t\tGot :", s.Properties)
+			t.Fatalf("\t%s\tShould describe fields the way they are marshalled.", failed)
+		}
+		t.Logf("\t%s\tShould describe fields the way they are marshalled.", success)
+
+		name := s.Properties["name"]
+		if len(s.Required) != 2 || name.MinLength == nil || *name.MinLength != 3 || *name.MaxLength != 20 {
+			t.Log("\t\tGot :", s.Required, name)
+			t.Fatalf("\t%s\tShould apply the validate tags.", failed)
+		}
+		t.Logf("\t%s\tShould apply the validate tags.", success)
+
+		if len(doc.Components.Schemas["web.Problem"].Properties["code"].Enum) == 0 {
+			t.Fatalf("\t%s\tShould list the error codes.", failed)
+		}
+		t.Logf("\t%s\tShould list the error codes.", success)
+	}
+}
+
+// TestWriteHTML validates the document is rendered as a page that runs no
+// script and whose style is allowed by its policy.
+func TestWriteHTML(t *testing.T) {
+	routes := []web.Route{
+		{Verb: "GET", Path: "/v1/widgets/:id", Doc: web.Doc{Summary: "Get a <widget>", Response: widget{}, Auth: web.AuthBearer}},
+	}
+	doc := openapi.Generate(openapi.Info{Title: "Test", Version: "v1"}, "/v1", routes)
+
+	t.Log("Given the need to document the API for people.")
+	{
+		var b bytes.Buffer
+		if err := openapi.WriteHTML(&b, doc); err != nil {
+			t.Fatalf("\t%s\tShould be able to render the page : %s.", failed, err)
+		}
+		t.Logf("\t%s\tShould be able to render the page.", success)
+
+		page := b.String()
+		if !strings.Contains(page, "/widgets/{id}") || !strings.Contains(page, "Get a &lt;widget&gt;") || !strings.Contains(page, `href="#openapi_test.widget"`) {
+			t.Log("\t\tGot :", page)
+			t.Fatalf("\t%s\tShould describe the operations and models.", failed)
+		}
+		t.Logf("\t%s\tShould describe the operations and models.", success)
+
+		if strings.Contains(page, "<script") {
+			t.Fatalf("\t%s\tShould not run any script.", failed)
+		}
+		t.Logf("\t%s\tShould not run any script.", success)
+
+		style := page[strings.Index(page, "<style>")+len("<style>") : strings.Index(page, "</style>")]
+		sum := sha256.Sum256([]byte(style))
+		if !strings.Contains(openapi.PagePolicy, "'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'") {
+			t.Fatalf("\t%s\tShould allow the style of the page : got %s.", failed, openapi.PagePolicy)
+		}
+		t.Logf("\t%s\tShould allow the style of the page.", success)
+	}
+}
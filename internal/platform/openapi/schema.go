@@ -0,0 +1,249 @@
+package openapi
+
+import (
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/web"
+)
+
+// Schema is the subset of the OpenAPI schema object we generate.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+}
+
+// generator builds schemas from Go types, collecting named structs as
+// components.
+type generator struct {
+	schemas map[string]*Schema
+}
+
+// newGenerator returns a generator with no components.
+func newGenerator() *generator {
+	return &generator{
+		schemas: make(map[string]*Schema),
+	}
+}
+
+// refPrefix starts the reference to a schema in the components.
+const refPrefix = "#/components/schemas/"
+
+// timeType is documented as a date-time string, which is how it marshals.
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the schema for the type of v.
+func (g *generator) schema(v interface{}) *Schema {
+	return g.typeSchema(reflect.TypeOf(v))
+}
+
+// typeSchema returns the schema for t. Named structs are added to the
+// components and referenced.
+func (g *generator) typeSchema(t reflect.Type) *Schema {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.typeSchema(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+
+	case reflect.String:
+		return &Schema{Type: "string"}
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.typeSchema(t.Elem())}
+
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.typeSchema(t.Elem())}
+
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+
+		name := path.Base(t.PkgPath()) + "." + t.Name()
+		if _, ok := g.schemas[name]; !ok {
+
+			// Claim the name first so recursive types terminate.
+			g.schemas[name] = &Schema{}
+			*g.schemas[name] = *g.structSchema(t)
+		}
+		return &Schema{Ref: refPrefix + name}
+	}
+
+	// Interfaces and anything else can hold any value.
+	return &Schema{}
+}
+
+// structSchema describes the exported fields of a struct the way
+// encoding/json marshals them, applying their validate tags.
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	s := Schema{
+		Type:       "object",
+		Properties: make(map[string]*Schema),
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		// Embedded structs without a name are flattened by encoding/json.
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			emb := g.structSchema(f.Type)
+			for n, p := range emb.Properties {
+				s.Properties[n] = p
+			}
+			s.Required = append(s.Required, emb.Required...)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := g.typeSchema(f.Type)
+		if applyValidate(fs, f.Tag.Get("validate")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = fs
+	}
+
+	return &s
+}
+
+// applyValidate documents the validate tag rules on the schema and reports
+// whether the field is required. Rules with no OpenAPI equivalent are left
+// out. Rules after dive apply to elements and are ignored.
+func applyValidate(s *Schema, tag string) bool {
+	var required bool
+
+	for _, rule := range strings.Split(tag, ",") {
+		kv := strings.SplitN(rule, "=", 2)
+
+		switch kv[0] {
+		case "dive":
+			return required
+
+		case "required":
+			required = true
+
+		case "email":
+			s.Format = "email"
+
+		case "eqfield":
+			if len(kv) == 2 {
+				s.Description = "Must equal " + kv[1] + "."
+			}
+
+		case "min", "max", "len":
+			if len(kv) != 2 || s.Ref != "" {
+				continue
+			}
+			n, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				continue
+			}
+			if kv[0] != "max" {
+				setBound(s, n, true)
+			}
+			if kv[0] != "min" {
+				setBound(s, n, false)
+			}
+		}
+	}
+
+	return required
+}
+
+// setBound sets the lower or upper bound that matches the schema type.
+func setBound(s *Schema, n float64, lower bool) {
+	i := int(n)
+
+	switch s.Type {
+	case "string":
+		if lower {
+			s.MinLength = &i
+		} else {
+			s.MaxLength = &i
+		}
+	case "array":
+		if lower {
+			s.MinItems = &i
+		} else {
+			s.MaxItems = &i
+		}
+	case "integer", "number":
+		if lower {
+			s.Minimum = &n
+		} else {
+			s.Maximum = &n
+		}
+	}
+}
+
+// problemCodes lists every declared error code on the problem schema so
+// clients can generate an exhaustive switch.
+func (g *generator) problemCodes() {
+	p, ok := g.schemas["web.Problem"]
+	if !ok {
+		return
+	}
+
+	code, ok := p.Properties["code"]
+	if !ok {
+		return
+	}
+
+	code.Enum = nil
+	for _, e := range web.Errors() {
+		code.Enum = append(code.Enum, e.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/db"
+
+	"github.com/pkg/errors"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const bucketsCollection = "rate_limits"
+
+// maxAttempts bounds how often Take retries when other instances update
+// the same bucket at the same time.
+const maxAttempts = 5
+
+// ErrContention occurs when a bucket could not be updated because other
+// instances kept changing it.
+var ErrContention = errors.New("rate limit bucket contention")
+
+// mongoBucket is a bucket as stored in Mongo. Expired buckets are removed
+// by a TTL index on expires_at. Version is incremented by every update.
+type mongoBucket struct {
+	Key       string    `bson:"_id"`
+	Tokens    float64   `bson:"tokens"`
+	Last      time.Time `bson:"last"`
+	ExpiresAt time.Time `bson:"expires_at"`
+	Version   int       `bson:"version"`
+}
+
+// MongoStore keeps buckets in Mongo so every instance of the service shares
+// the same budgets.
+type MongoStore struct {
+	MasterDB *db.DB
+}
+
+// NewMongoStore returns a MongoStore using the master database. It creates
+// the index that removes expired buckets.
+func NewMongoStore(ctx context.Context, masterDB *db.DB) (*MongoStore, error) {
+	dbConn := masterDB.Copy()
+	defer dbConn.Close()
+
+	idx := mgo.Index{
+		Key:         []string{"expires_at"},
+		ExpireAfter: time.Second,
+	}
+	f := func(collection *mgo.Collection) error {
+		return collection.EnsureIndex(idx)
+	}
+	if err := dbConn.ExecuteWrite(ctx, bucketsCollection, f); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.%s.ensureIndex(%s)", bucketsCollection, db.Query(idx.Key)))
+	}
+
+	s := MongoStore{
+		MasterDB: masterDB,
+	}
+
+	return &s, nil
+}
+
+// Take implements the Store interface. Buckets are updated with optimistic
+// concurrency, an update only applies when the bucket's version is unchanged
+// since it was read.
+func (s *MongoStore) Take(ctx context.Context, key string, rate Rate, now time.Time) (Result, error) {
+	dbConn := s.MasterDB.Copy()
+	defer dbConn.Close()
+
+	// Mongo stores times to the millisecond. Truncate so the time read back
+	// matches the one written.
+	now = now.Truncate(time.Millisecond)
+
+	for i := 0; i < maxAttempts; i++ {
+		var b mongoBucket
+		f := func(collection *mgo.Collection) error {
+			return collection.FindId(key).One(&b)
+		}
+		err := dbConn.Execute(ctx, bucketsCollection, f)
+
+		isNew := err == mgo.ErrNotFound
+		if err != nil && !isNew {
+			return Result{}, errors.Wrap(err, fmt.Sprintf("db.%s.find(%s)", bucketsCollection, db.Query(key)))
+		}
+		if isNew {
+			b = mongoBucket{Key: key, Tokens: float64(rate.Limit), Last: now}
+		}
+
+		// Buckets stored before they were versioned have no version.
+		q := bson.M{"_id": key, "version": b.Version}
+		if b.Version == 0 {
+			q["version"] = bson.M{"$in": []interface{}{0, nil}}
+		}
+
+		var res Result
+		b.Tokens, res = rate.take(b.Tokens, b.Last, now)
+		b.Last = now
+		b.ExpiresAt = now.Add(rate.Period)
+		b.Version++
+
+		if isNew {
+			f = func(collection *mgo.Collection) error {
+				return collection.Insert(&b)
+			}
+		} else {
+			f = func(collection *mgo.Collection) error {
+				return collection.Update(q, &b)
+			}
+		}
+
+		switch err := dbConn.ExecuteWrite(ctx, bucketsCollection, f); {
+		case err == nil:
+			return res, nil
+
+		// Another instance created or changed the bucket since we read it.
+		case mgo.IsDup(err), err == mgo.ErrNotFound:
+			continue
+
+		default:
+			return Result{}, errors.Wrap(err, fmt.Sprintf("db.%s.update(%s)", bucketsCollection, db.Query(&b)))
+		}
+	}
+
+	return Result{}, ErrContention
+}
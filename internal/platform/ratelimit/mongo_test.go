@@ -0,0 +1,105 @@
+package ratelimit_test
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/db"
+	"inventory-optimisation-server/internal/platform/ratelimit"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TestMongoStore validates instances sharing buckets in Mongo never allow
+// more requests between them than the limit, even when they all take from
+// the same bucket in the same millisecond. It needs a Mongo server, set
+// TEST_DB_HOST to run it.
+func TestMongoStore(t *testing.T) {
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		t.Skip("TEST_DB_HOST is not set")
+	}
+
+	ctx := context.Background()
+
+	masterDB, err := db.New(host, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer masterDB.Close()
+
+	t.Log("Given the need to share budgets between instances.")
+	{
+		s, err := ratelimit.NewMongoStore(ctx, masterDB)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create the store : %s.", failed, err)
+		}
+		t.Logf("\t%s\tShould be able to create the store.", success)
+
+		var indexes []mgo.Index
+		f := func(collection *mgo.Collection) error {
+			var err error
+			indexes, err = collection.Indexes()
+			return err
+		}
+		if err := masterDB.Execute(ctx, "rate_limits", f); err != nil {
+			t.Fatal(err)
+		}
+		var ttl bool
+		for _, idx := range indexes {
+			if len(idx.Key) == 1 && idx.Key[0] == "expires_at" && idx.ExpireAfter > 0 {
+				ttl = true
+			}
+		}
+		if !ttl {
+			t.Fatalf("\t%s\tShould create the TTL index : got %+v.", failed, indexes)
+		}
+		t.Logf("\t%s\tShould create the TTL index.", success)
+
+		t.Log("\tWhen many requests take from the same bucket at once.")
+		{
+			key := "test:" + bson.NewObjectId().Hex()
+			rate := ratelimit.Rate{Limit: 10, Period: time.Hour}
+			now := time.Now()
+
+			var (
+				wg      sync.WaitGroup
+				mu      sync.Mutex
+				allowed int
+				errs    []error
+			)
+			for i := 0; i < 40; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					res, err := s.Take(ctx, key, rate, now)
+
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case err == nil && res.Allowed:
+						allowed++
+					case err != nil && err != ratelimit.ErrContention:
+						errs = append(errs, err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if len(errs) > 0 {
+				t.Fatalf("\t%s\tShould only fail with contention : %v.", failed, errs)
+			}
+			t.Logf("\t%s\tShould only fail with contention.", success)
+
+			if allowed == 0 || allowed > rate.Limit {
+				t.Fatalf("\t%s\tShould allow at most %d requests : got %d.", failed, rate.Limit, allowed)
+			}
+			t.Logf("\t%s\tShould allow at most %d requests.", success, rate.Limit)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Package ratelimit implements token bucket rate limiting with pluggable
+// storage for the buckets.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Rate allows Limit requests per Period. A caller can use the whole budget
+// in a burst, after which it refills evenly over the period.
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed bool
+
+	// Remaining is the number of requests left in the bucket.
+	Remaining int
+
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+
+	// RetryAfter is how long until the next request is allowed. It is only
+	// set when the request was not allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps the token buckets. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	Take(ctx context.Context, key string, rate Rate, now time.Time) (Result, error)
+}
+
+// take refills a bucket holding tokens as of last and takes one token from
+// it if it can. It returns the tokens left and the outcome.
+func (r Rate) take(tokens float64, last, now time.Time) (float64, Result) {
+	capacity := float64(r.Limit)
+	perSecond := capacity / r.Period.Seconds()
+
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens = math.Min(capacity, tokens+elapsed*perSecond)
+	}
+
+	var res Result
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - tokens) / perSecond)
+	}
+
+	res.Remaining = int(tokens)
+	res.Reset = seconds((capacity - tokens) / perSecond)
+
+	return tokens, res
+}
+
+// seconds converts a number of seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// bucket is the state of a single caller's budget.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	period time.Duration
+}
+
+// MemoryStore keeps buckets in memory. It suits a single instance of the
+// service.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// sweepEvery is how many takes pass between removing full buckets.
+const sweepEvery = 1000
+
+// Take implements the Store interface.
+func (s *MemoryStore) Take(ctx context.Context, key string, rate Rate, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rate.Limit), last: now, period: rate.Period}
+		s.buckets[key] = b
+	}
+
+	var res Result
+	b.tokens, res = rate.take(b.tokens, b.last, now)
+	b.last = now
+
+	// A bucket untouched for a whole period is full again and is the same
+	// as no bucket at all.
+	if s.takes++; s.takes%sweepEvery == 0 {
+		for k, b := range s.buckets {
+			if now.Sub(b.last) > b.period {
+				delete(s.buckets, k)
+			}
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,57 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/ratelimit"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// TestMemoryStore validates a caller can burst up to the limit and then
+// has to wait for the bucket to refill.
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	rate := ratelimit.Rate{Limit: 3, Period: 3 * time.Second}
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Log("Given the need to limit how often callers make requests.")
+	{
+		s := ratelimit.NewMemoryStore()
+
+		t.Log("\tWhen a caller bursts through their budget.")
+		{
+			for i := 0; i < 3; i++ {
+				res, err := s.Take(ctx, "anna", rate, now)
+				if err != nil || !res.Allowed || res.Remaining != 2-i {
+					t.Fatalf("\t%s\tShould allow request %d : %+v %v.", failed, i+1, res, err)
+				}
+			}
+			t.Logf("\t%s\tShould allow requests up to the limit.", success)
+
+			res, _ := s.Take(ctx, "anna", rate, now)
+			if res.Allowed || res.RetryAfter != time.Second || res.Reset != 3*time.Second {
+				t.Fatalf("\t%s\tShould refuse the next request : %+v.", failed, res)
+			}
+			t.Logf("\t%s\tShould refuse the next request.", success)
+
+			if res, _ := s.Take(ctx, "bill", rate, now); !res.Allowed {
+				t.Fatalf("\t%s\tShould not affect other callers.", failed)
+			}
+			t.Logf("\t%s\tShould not affect other callers.", success)
+		}
+
+		t.Log("\tWhen the caller waits for the bucket to refill.")
+		{
+			if res, _ := s.Take(ctx, "anna", rate, now.Add(time.Second)); !res.Allowed {
+				t.Fatalf("\t%s\tShould allow a request after Retry-After : %+v.", failed, res)
+			}
+			t.Logf("\t%s\tShould allow a request after Retry-After.", success)
+		}
+	}
+}
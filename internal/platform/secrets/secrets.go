@@ -0,0 +1,289 @@
+// Package secrets fills in the credentials of a configuration from where they
+// are kept, such as files mounted by the orchestrator or a Vault server, and
+// masks them when the configuration is logged.
+//
+// Fields holding a secret are tagged secret:"true". They are named like
+// envconfig names them, the section and the envconfig tag or field name in
+// upper case, so API_OIDC_CLIENT_SECRET is known to providers as
+// OIDC_CLIENT_SECRET.
+//
+// Secrets are read once, when Load is called. A rotated secret is only
+// picked up when the process restarts.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by a Provider that does not hold the secret.
+var ErrNotFound = errors.New("secret not found")
+
+// Masked replaces the value of a secret in the output of Mask.
+const Masked = "******"
+
+// Provider looks up secrets by name.
+type Provider interface {
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// Env looks up secrets in the environment, as PREFIX_NAME.
+type Env struct {
+	Prefix string
+}
+
+// Secret implements the Provider interface.
+func (e Env) Secret(ctx context.Context, name string) (string, error) {
+	if e.Prefix != "" {
+		name = e.Prefix + "_" + name
+	}
+
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+// File looks up secrets in files named after them in lower case in Dir, the
+// way Docker and Kubernetes mount them, such as /run/secrets/oidc_client_secret.
+type File struct {
+	Dir string
+}
+
+// Secret implements the Provider interface.
+func (f File) Secret(ctx context.Context, name string) (string, error) {
+	v, err := readFile(filepath.Join(f.Dir, strings.ToLower(name)))
+	if os.IsNotExist(errors.Cause(err)) {
+		return "", ErrNotFound
+	}
+	return v, err
+}
+
+// Vault looks up secrets in a version 2 key/value engine of a Vault server,
+// or anything speaking its API. Every secret of the service is a key, in
+// lower case, of the one Vault secret at Path.
+type Vault struct {
+	Addr  string
+	Token string
+
+	// Mount is where the key/value engine is mounted, "secret" when empty.
+	Mount string
+	Path  string
+
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// Secret implements the Provider interface.
+func (v Vault) Secret(ctx context.Context, name string) (string, error) {
+	mount := v.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	url := strings.TrimRight(v.Addr, "/") + "/v1/" + strings.Trim(mount, "/") + "/data/" + strings.Trim(v.Path, "/")
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "building vault request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "reading vault secret")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return "", errors.Errorf("reading vault secret %s : %s", v.Path, resp.Status)
+	}
+
+	var doc struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", errors.Wrap(err, "decoding vault secret")
+	}
+
+	s, ok := doc.Data.Data[strings.ToLower(name)].(string)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return s, nil
+}
+
+// Load fills in the secret fields of the struct cfg points to. A field is read
+// from the file named by the PREFIX_NAME_FILE environment variable when set,
+// otherwise a field not set in the environment is looked up with p, if not
+// nil. A field holding the value of its default tag counts as not set so the
+// provider wins over defaults. Secrets p does not hold keep their value.
+func Load(ctx context.Context, p Provider, prefix string, cfg interface{}) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("config must be a pointer to a struct")
+	}
+
+	return walk(v.Elem(), "", func(f reflect.Value, field reflect.StructField, name string) error {
+		env := name
+		if prefix != "" {
+			env = prefix + "_" + name
+		}
+
+		if file := os.Getenv(env + "_FILE"); file != "" {
+			s, err := readFile(file)
+			if err != nil {
+				return errors.Wrap(err, name)
+			}
+			f.SetString(s)
+			return nil
+		}
+
+		if p == nil {
+			return nil
+		}
+
+		// Values from the environment, or set some other way such as by a
+		// flag, win over the provider. Defaults do not.
+		_, set := os.LookupEnv(env)
+		if def, ok := field.Tag.Lookup("default"); !ok || f.String() != def {
+			set = set || f.String() != ""
+		}
+		if set {
+			return nil
+		}
+
+		s, err := p.Secret(ctx, name)
+		switch {
+		case err == ErrNotFound:
+			return nil
+		case err != nil:
+			return errors.Wrap(err, name)
+		}
+		f.SetString(s)
+		return nil
+	})
+}
+
+// Mask returns a copy of the struct cfg with the secrets that are set
+// replaced by Masked, ready to be logged.
+func Mask(cfg interface{}) interface{} {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return cfg
+	}
+
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+
+	walk(c, "", func(f reflect.Value, field reflect.StructField, name string) error {
+		if f.String() != "" {
+			f.SetString(Masked)
+		}
+		return nil
+	})
+
+	return c.Interface()
+}
+
+// MaskURL returns the URL s with the password in its user info, if any,
+// replaced by Masked, ready to be logged. It accepts the URLs net/url does
+// not, such as Mongo URLs listing several hosts.
+func MaskURL(s string) string {
+	start := strings.Index(s, "://")
+	if start < 0 {
+		return s
+	}
+	start += len("://")
+
+	end := strings.IndexAny(s[start:], "/?")
+	if end < 0 {
+		end = len(s)
+	} else {
+		end += start
+	}
+
+	at := strings.LastIndex(s[start:end], "@")
+	if at < 0 {
+		return s
+	}
+	at += start
+
+	colon := strings.Index(s[start:at], ":")
+	if colon < 0 {
+		return s
+	}
+
+	return s[:start+colon+1] + Masked + s[at:]
+}
+
+// walk calls fn with every string field tagged as a secret in the struct v
+// and the structs nested in it, along with the name of the secret.
+func walk(v reflect.Value, section string, fn func(f reflect.Value, field reflect.StructField, name string) error) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		f := v.Field(i)
+
+		if !f.CanSet() {
+			continue
+		}
+
+		name := field.Tag.Get("envconfig")
+		if name == "" {
+			name = strings.ToUpper(field.Name)
+		}
+		if section != "" {
+			name = section + "_" + name
+		}
+
+		if f.Kind() == reflect.Struct {
+			if err := walk(f, name, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if field.Tag.Get("secret") != "true" || f.Kind() != reflect.String {
+			continue
+		}
+
+		if err := fn(f, field, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readFile returns the content of a secret file without the line break
+// editors and echo leave at the end.
+func readFile(name string) (string, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", errors.Wrap(err, "reading secret file")
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
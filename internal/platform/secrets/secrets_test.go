@@ -0,0 +1,184 @@
+package secrets_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"inventory-optimisation-server/internal/platform/secrets"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// config is a configuration with secrets in a section, the way the api
+// declares its own.
+type config struct {
+	DB struct {
+		Host     string `envconfig:"HOST"`
+		User     string `default:"admin" envconfig:"USER" secret:"true"`
+		Password string `envconfig:"PASSWORD" secret:"true"`
+	}
+	OIDC struct {
+		ClientSecret string `envconfig:"CLIENT_SECRET" secret:"true"`
+		CookieKey    string `envconfig:"COOKIE_KEY" secret:"true"`
+	}
+}
+
+// TestLoad validates secrets are read from files and looked up in Vault.
+func TestLoad(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/api" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data": map[string]string{"db_user": "from-vault", "db_password": "from-vault"},
+			},
+		})
+	}))
+	defer vault.Close()
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "client_secret")
+	if err := ioutil.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("TEST_OIDC_CLIENT_SECRET_FILE", file)
+	defer os.Unsetenv("TEST_OIDC_CLIENT_SECRET_FILE")
+
+	t.Log("Given the need to keep credentials out of the environment.")
+	{
+		t.Log("\tWhen loading secrets from Vault and files.")
+		{
+			var cfg config
+			cfg.DB.Host = "mongo"
+			cfg.DB.User = "admin"
+
+			p := secrets.Vault{Addr: vault.URL, Token: "root", Path: "api"}
+			if err := secrets.Load(context.Background(), p, "TEST", &cfg); err != nil {
+				t.Fatalf("\t%s\tShould be able to load the secrets : %s.", failed, err)
+			}
+			t.Logf("\t%s\tShould be able to load the secrets.", success)
+
+			if cfg.DB.Password != "from-vault" {
+				t.Fatalf("\t%s\tShould look up the password in Vault : got %q.", failed, cfg.DB.Password)
+			}
+			t.Logf("\t%s\tShould look up the password in Vault.", success)
+
+			if cfg.DB.User != "from-vault" {
+				t.Fatalf("\t%s\tShould look up the user in Vault over its default : got %q.", failed, cfg.DB.User)
+			}
+			t.Logf("\t%s\tShould look up the user in Vault over its default.", success)
+
+			if cfg.OIDC.ClientSecret != "from-file" {
+				t.Fatalf("\t%s\tShould read the client secret from its file : got %q.", failed, cfg.OIDC.ClientSecret)
+			}
+			t.Logf("\t%s\tShould read the client secret from its file.", success)
+
+			if cfg.OIDC.CookieKey != "" {
+				t.Fatalf("\t%s\tShould leave unknown secrets empty : got %q.", failed, cfg.OIDC.CookieKey)
+			}
+			t.Logf("\t%s\tShould leave unknown secrets empty.", success)
+
+			b, err := json.Marshal(secrets.Mask(cfg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := `{"DB":{"Host":"mongo","User":"******","Password":"******"},"OIDC":{"ClientSecret":"******","CookieKey":""}}`
+			if string(b) != want {
+				t.Fatalf("\t%s\tShould mask the secrets : got %s.", failed, b)
+			}
+			if cfg.DB.Password != "from-vault" {
+				t.Fatalf("\t%s\tShould leave the configuration untouched.", failed)
+			}
+			t.Logf("\t%s\tShould mask the secrets.", success)
+		}
+
+		t.Log("\tWhen loading secrets from a directory.")
+		{
+			if err := ioutil.WriteFile(filepath.Join(dir, "db_user"), []byte("from-dir\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			var cfg config
+			cfg.DB.User = "admin"
+
+			p := secrets.File{Dir: dir}
+			if err := secrets.Load(context.Background(), p, "TEST", &cfg); err != nil {
+				t.Fatalf("\t%s\tShould be able to load the secrets : %s.", failed, err)
+			}
+			t.Logf("\t%s\tShould be able to load the secrets.", success)
+
+			if cfg.DB.User != "from-dir" {
+				t.Fatalf("\t%s\tShould read the user from the directory over its default : got %q.", failed, cfg.DB.User)
+			}
+			t.Logf("\t%s\tShould read the user from the directory over its default.", success)
+
+			cfg.DB.User = "admin"
+			os.Setenv("TEST_DB_USER", "admin")
+			err := secrets.Load(context.Background(), p, "TEST", &cfg)
+			os.Unsetenv("TEST_DB_USER")
+			if err != nil || cfg.DB.User != "admin" {
+				t.Fatalf("\t%s\tShould keep the user set in the environment : got %q %v.", failed, cfg.DB.User, err)
+			}
+			t.Logf("\t%s\tShould keep the user set in the environment.", success)
+		}
+
+		t.Log("\tWhen Vault refuses the token.")
+		{
+			var cfg config
+
+			p := secrets.Vault{Addr: vault.URL, Token: "wrong", Path: "api"}
+			if err := secrets.Load(context.Background(), p, "TEST", &cfg); err == nil {
+				t.Fatalf("\t%s\tShould report the error.", failed)
+			}
+			t.Logf("\t%s\tShould report the error.", success)
+		}
+	}
+}
+
+// TestMaskURL validates passwords in URLs are masked and the rest is kept.
+func TestMaskURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"0.0.0.0:27017", "0.0.0.0:27017"},
+		{"mongodb://db:27017/inventory", "mongodb://db:27017/inventory"},
+		{"mongodb://admin@db:27017", "mongodb://admin@db:27017"},
+		{"mongodb://admin:hunter2@db:27017/inventory", "mongodb://admin:" + secrets.Masked + "@db:27017/inventory"},
+		{"mongodb://admin:p@ss@db1:27017,db2:27017/inventory?replicaSet=rs0", "mongodb://admin:" + secrets.Masked + "@db1:27017,db2:27017/inventory?replicaSet=rs0"},
+		{"mongodb://db:27017/inventory?authSource=a@b", "mongodb://db:27017/inventory?authSource=a@b"},
+	}
+
+	t.Log("Given the need to log URLs holding credentials.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen masking %s.", tt.url)
+			{
+				if got := secrets.MaskURL(tt.url); got != tt.want {
+					t.Fatalf("\t%s\tShould get %s : got %s.", failed, tt.want, got)
+				}
+				t.Logf("\t%s\tShould get %s.", success, tt.want)
+			}
+		}
+	}
+}
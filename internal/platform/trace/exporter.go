@@ -0,0 +1,146 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StdoutExporter writes each span as a single line of JSON. It is meant for
+// local development.
+type StdoutExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewStdoutExporter returns an exporter that writes to w.
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{w: w}
+}
+
+// ExportSpan implements the Exporter interface.
+func (e *StdoutExporter) ExportSpan(s *Span) {
+	line := struct {
+		TraceID    string            `json:"trace_id"`
+		SpanID     string            `json:"span_id"`
+		ParentID   string            `json:"parent_id,omitempty"`
+		Name       string            `json:"name"`
+		Start      time.Time         `json:"start"`
+		Duration   string            `json:"duration"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Err        string            `json:"error,omitempty"`
+	}{
+		TraceID:    s.TraceID,
+		SpanID:     s.SpanID,
+		ParentID:   s.ParentID,
+		Name:       s.Name,
+		Start:      s.Start,
+		Duration:   s.End.Sub(s.Start).String(),
+		Attributes: s.Attributes,
+		Err:        s.Err,
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	json.NewEncoder(e.w).Encode(line)
+}
+
+// OTLPFileExporter writes each span as a line of OTLP/JSON, the format used
+// by the OpenTelemetry collector's file exporter and receiver. The output can
+// be loaded into any OTLP aware tool.
+type OTLPFileExporter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	service string
+}
+
+// NewOTLPFileExporter returns an exporter that writes to w, reporting spans
+// as coming from the named service.
+func NewOTLPFileExporter(w io.Writer, service string) *OTLPFileExporter {
+	return &OTLPFileExporter{w: w, service: service}
+}
+
+// otlpAttr is a key/value pair in OTLP/JSON.
+type otlpAttr struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+// attrs converts a map to sorted OTLP/JSON attributes.
+func attrs(m map[string]string) []otlpAttr {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	list := make([]otlpAttr, len(keys))
+	for i, k := range keys {
+		list[i].Key = k
+		list[i].Value.StringValue = m[k]
+	}
+	return list
+}
+
+// ExportSpan implements the Exporter interface.
+func (e *OTLPFileExporter) ExportSpan(s *Span) {
+	type status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	type span struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []otlpAttr `json:"attributes,omitempty"`
+		Status            status     `json:"status"`
+	}
+
+	sp := span{
+		TraceID:           s.TraceID,
+		SpanID:            s.SpanID,
+		ParentSpanID:      s.ParentID,
+		Name:              s.Name,
+		Kind:              1, // SPAN_KIND_INTERNAL
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Attributes:        attrs(s.Attributes),
+	}
+	if sp.ParentSpanID == "" {
+		sp.Kind = 2 // SPAN_KIND_SERVER
+	}
+	if s.Err != "" {
+		sp.Status = status{Code: 2, Message: s.Err} // STATUS_CODE_ERROR
+	}
+
+	req := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": attrs(map[string]string{"service.name": e.service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "inventory-optimisation-server/internal/platform/trace"},
+						"spans": []span{sp},
+					},
+				},
+			},
+		},
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	json.NewEncoder(e.w).Encode(req)
+}
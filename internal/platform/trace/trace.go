@@ -0,0 +1,198 @@
+// Package trace provides minimal distributed tracing. Spans are carried in a
+// context.Context and handed to the registered exporters when they end.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ctxKey represents the type of value for the context key.
+type ctxKey int
+
+// key is used to store/retrieve the current Span from a context.Context.
+const key ctxKey = 1
+
+// Span represents a single timed operation within a trace.
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Err        string
+
+	mu    sync.Mutex
+	ended bool
+}
+
+// Exporter receives spans once they have ended.
+type Exporter interface {
+	ExportSpan(s *Span)
+}
+
+// exporters holds the registered exporters.
+var exporters struct {
+	sync.RWMutex
+	list []Exporter
+}
+
+// RegisterExporter adds an exporter that will receive every ended span.
+func RegisterExporter(e Exporter) {
+	exporters.Lock()
+	exporters.list = append(exporters.list, e)
+	exporters.Unlock()
+}
+
+// UnregisterExporter removes a previously registered exporter.
+func UnregisterExporter(e Exporter) {
+	exporters.Lock()
+	defer exporters.Unlock()
+
+	for i, reg := range exporters.list {
+		if reg == e {
+			exporters.list = append(exporters.list[:i], exporters.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// StartSpan starts a span as a child of the span held by ctx, or as the root
+// of a new trace when ctx holds none. The returned context holds the new span.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	var traceID, parentID string
+	if parent := FromContext(ctx); parent != nil {
+		traceID, parentID = parent.TraceID, parent.SpanID
+	}
+
+	return StartSpanWithRemoteParent(ctx, name, traceID, parentID)
+}
+
+// StartSpanWithRemoteParent starts a span that continues a trace started by
+// another process. A blank or invalid traceID starts a new trace.
+func StartSpanWithRemoteParent(ctx context.Context, name, traceID, parentID string) (context.Context, *Span) {
+	if !ValidTraceID(traceID) {
+		traceID, parentID = NewTraceID(), ""
+	}
+
+	s := Span{
+		TraceID:  traceID,
+		SpanID:   NewSpanID(),
+		ParentID: parentID,
+		Name:     name,
+		Start:    time.Now(),
+	}
+
+	return context.WithValue(ctx, key, &s), &s
+}
+
+// FromContext returns the current span, or nil if there is none.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(key).(*Span)
+	return s
+}
+
+// SetAttribute records a key/value pair describing the span.
+func (s *Span) SetAttribute(k, v string) {
+	s.mu.Lock()
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[k] = v
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Err = err.Error()
+	s.mu.Unlock()
+}
+
+// Finish ends the span and hands it to the registered exporters. Calling it
+// more than once has no effect.
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	exporters.RLock()
+	defer exporters.RUnlock()
+
+	for _, e := range exporters.list {
+		e.ExportSpan(s)
+	}
+}
+
+// NewTraceID returns a random 16 byte trace id in hex.
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+// NewSpanID returns a random 8 byte span id in hex.
+func NewSpanID() string {
+	return randomHex(8)
+}
+
+// randomHex returns n random bytes encoded as hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ParseTraceparent extracts the trace id and parent span id from a W3C
+// traceparent header of the form version-traceid-parentid-flags.
+// https://www.w3.org/TR/trace-context/#traceparent-header
+func ParseTraceparent(h string) (traceID, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", false
+	}
+
+	traceID, parentID = parts[1], parts[2]
+	if !ValidTraceID(traceID) || !isHex(parentID, 16) || strings.Trim(parentID, "0") == "" {
+		return "", "", false
+	}
+
+	return traceID, parentID, true
+}
+
+// ValidTraceID reports whether id is a W3C trace id: 16 bytes as lower case
+// hex, not all zero.
+func ValidTraceID(id string) bool {
+	return isHex(id, 32) && strings.Trim(id, "0") != ""
+}
+
+// Traceparent formats a W3C traceparent header naming the span as parent.
+func Traceparent(s *Span) string {
+	return fmt.Sprintf("00-%s-%s-01", s.TraceID, s.SpanID)
+}
+
+// isHex reports whether s is n lower case hex characters.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
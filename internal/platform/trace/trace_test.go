@@ -0,0 +1,90 @@
+package trace_test
+
+import (
+	"context"
+	"testing"
+
+	"inventory-optimisation-server/internal/platform/trace"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// recorder is an exporter that keeps every span it is given.
+type recorder struct {
+	spans []*trace.Span
+}
+
+// ExportSpan implements the Exporter interface.
+func (r *recorder) ExportSpan(s *trace.Span) {
+	r.spans = append(r.spans, s)
+}
+
+// TestParseTraceparent validates parsing of W3C traceparent headers.
+func TestParseTraceparent(t *testing.T) {
+	tt := []struct {
+		header string
+		ok     bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"garbage", false},
+		{"", false},
+	}
+
+	t.Log("Given the need to continue traces started by other services.")
+	{
+		for i, tc := range tt {
+			t.Logf("\tTest %d:\tWhen parsing %q.", i, tc.header)
+			{
+				traceID, parentID, ok := trace.ParseTraceparent(tc.header)
+				if ok != tc.ok {
+					t.Fatalf("\t%s\tShould report ok == %v.", failed, tc.ok)
+				}
+				t.Logf("\t%s\tShould report ok == %v.", success, tc.ok)
+
+				if ok && (traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || parentID != "00f067aa0ba902b7") {
+					t.Fatalf("\t%s\tShould extract the ids : %s %s.", failed, traceID, parentID)
+				}
+			}
+		}
+	}
+}
+
+// TestSpans validates spans are linked together and exported when finished.
+func TestSpans(t *testing.T) {
+	var rec recorder
+	trace.RegisterExporter(&rec)
+	defer trace.UnregisterExporter(&rec)
+
+	t.Log("Given the need to trace nested operations.")
+	{
+		ctx, root := trace.StartSpanWithRemoteParent(context.Background(), "GET /v1/users", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7")
+		_, child := trace.StartSpan(ctx, "mongo.users")
+
+		child.Finish()
+		child.Finish()
+		root.Finish()
+
+		if len(rec.spans) != 2 {
+			t.Fatalf("\t%s\tShould export each span once : got %d.", failed, len(rec.spans))
+		}
+		t.Logf("\t%s\tShould export each span once.", success)
+
+		if child.TraceID != root.TraceID || child.ParentID != root.SpanID {
+			t.Fatalf("\t%s\tShould make the child part of the root's trace.", failed)
+		}
+		t.Logf("\t%s\tShould make the child part of the root's trace.", success)
+
+		if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + root.SpanID + "-01"; trace.Traceparent(root) != want {
+			t.Fatalf("\t%s\tShould format a traceparent header : %s.", failed, trace.Traceparent(root))
+		}
+		t.Logf("\t%s\tShould format a traceparent header.", success)
+	}
+}
@@ -0,0 +1,186 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrBodyTooLarge occurs when a request body is larger than the route
+	// accepts.
+	ErrBodyTooLarge = NewError("body_too_large", http.StatusRequestEntityTooLarge, "Request body is too large")
+
+	// ErrMalformedBody occurs when a request body can not be decoded.
+	ErrMalformedBody = NewError("body_malformed", http.StatusBadRequest, "Request body is malformed")
+)
+
+// tooLarge describes reading past the body limit set with
+// http.MaxBytesReader.
+func tooLarge(err *http.MaxBytesError) error {
+	return Detail(ErrBodyTooLarge, fmt.Sprintf("the body must be at most %d bytes", err.Limit))
+}
+
+// ReadBody reads the whole request body. Use it rather than reading r.Body
+// directly so a body past the route's limit is reported as ErrBodyTooLarge.
+func ReadBody(r *http.Request) ([]byte, error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, readError(err)
+	}
+	return b, nil
+}
+
+// BufferBody reads the whole request body, copying it to w as it goes, and
+// replaces it so handlers can read it again. Up to maxMemory bytes are held
+// in memory, the rest is stored in a temporary file removed by the returned
+// func once the request is done.
+func BufferBody(r *http.Request, maxMemory int64, w io.Writer) (func(), error) {
+	body := io.TeeReader(r.Body, w)
+
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, body, maxMemory+1)
+	switch {
+	case err == io.EOF:
+		r.Body = ioutil.NopCloser(&buf)
+		return func() {}, nil
+	case err != nil:
+		return nil, readError(err)
+	}
+
+	// The body does not fit in memory.
+	f, err := ioutil.TempFile("", "body-")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating body file")
+	}
+	remove := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	if _, err := buf.WriteTo(f); err != nil {
+		remove()
+		return nil, errors.Wrap(err, "writing body file")
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		remove()
+		return nil, readError(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		remove()
+		return nil, errors.Wrap(err, "rewinding body file")
+	}
+
+	r.Body = ioutil.NopCloser(f)
+	return remove, nil
+}
+
+// readError describes an error reading the body.
+func readError(err error) error {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return tooLarge(mbe)
+	}
+	return errors.Wrap(err, "reading body")
+}
+
+// Decode checks the request body is JSON and unmarshals it into v like
+// Unmarshal.
+func Decode(ctx context.Context, r *http.Request, v interface{}) error {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mt != FormatJSON {
+		return Detail(ErrUnsupportedMediaType, "expected "+FormatJSON)
+	}
+
+	return Unmarshal(ctx, r.Body, v)
+}
+
+// ParseForm checks the request body is a multipart form and parses it. Up to
+// maxMemory bytes of files are held in memory, the rest is stored in
+// temporary files removed once the request is done.
+func ParseForm(r *http.Request, maxMemory int64) error {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/form-data" {
+		return Detail(ErrUnsupportedMediaType, "expected multipart/form-data")
+	}
+
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return tooLarge(mbe)
+		}
+		return Detail(ErrMalformedBody, err.Error())
+	}
+
+	return nil
+}
+
+// UnmarshalForm parses the multipart form in the request body like
+// ParseForm, binds it to the struct v points to and validates it like
+// Unmarshal, rejecting unknown fields on routes decoding strictly. Fields
+// are bound by their form tag: string and []string fields to values,
+// *multipart.FileHeader and []*multipart.FileHeader fields to files.
+func UnmarshalForm(ctx context.Context, r *http.Request, maxMemory int64, v interface{}) error {
+	if err := ParseForm(r, maxMemory); err != nil {
+		return err
+	}
+	form := r.MultipartForm
+
+	rv := reflect.ValueOf(v).Elem()
+	t := rv.Type()
+
+	known := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("form")
+		if name == "" || name == "-" {
+			continue
+		}
+		known[name] = true
+
+		f := rv.Field(i)
+		switch f.Interface().(type) {
+		case string:
+			if vs := form.Value[name]; len(vs) > 0 {
+				f.SetString(vs[0])
+			}
+		case []string:
+			f.Set(reflect.ValueOf(form.Value[name]))
+		case *multipart.FileHeader:
+			if fs := form.File[name]; len(fs) > 0 {
+				f.Set(reflect.ValueOf(fs[0]))
+			}
+		case []*multipart.FileHeader:
+			f.Set(reflect.ValueOf(form.File[name]))
+		default:
+			return errors.Errorf("binding form field %s of type %s", name, f.Type())
+		}
+	}
+
+	if strict(ctx) {
+		var inv InvalidError
+		for name := range form.Value {
+			if !known[name] {
+				inv = append(inv, Invalid{Fld: name, Err: "unknown"})
+			}
+		}
+		for name := range form.File {
+			if !known[name] {
+				inv = append(inv, Invalid{Fld: name, Err: "unknown"})
+			}
+		}
+		if inv != nil {
+			return inv
+		}
+	}
+
+	return check(v)
+}
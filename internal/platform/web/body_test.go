@@ -0,0 +1,179 @@
+package web_test
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"inventory-optimisation-server/internal/platform/web"
+
+	"github.com/pkg/errors"
+)
+
+// TestDecode validates request bodies are decoded strictly and within their
+// limit.
+func TestDecode(t *testing.T) {
+	type item struct {
+		SKU string `json:"sku"`
+	}
+	type thing struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+		Items []item `json:"items"`
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		limit       int64
+		strict      bool
+		err         error
+	}{
+		{"a valid body", "application/json; charset=utf-8", `{"name":"a","count":1}`, 0, true, nil},
+		{"no content type", "", `{"name":"a"}`, 0, false, web.ErrUnsupportedMediaType},
+		{"a form", "application/x-www-form-urlencoded", `name=a`, 0, false, web.ErrUnsupportedMediaType},
+		{"malformed JSON", "application/json", `{"name":`, 0, false, web.ErrMalformedBody},
+		{"an empty body", "application/json", ``, 0, false, web.ErrMalformedBody},
+		{"trailing data", "application/json", `{"name":"a"} {"name":"b"}`, 0, false, web.ErrMalformedBody},
+		{"a body past the limit", "application/json", `{"name":"` + strings.Repeat("a", 100) + `"}`, 50, false, web.ErrBodyTooLarge},
+		{"an unknown field", "application/json", `{"name":"a","colour":"red"}`, 0, false, nil},
+		{"an unknown field when strict", "application/json", `{"name":"a","colour":"red"}`, 0, true, web.InvalidError{{Fld: "colour", Err: "unknown"}}},
+		{"a field in another case when strict", "application/json", `{"Name":"a"}`, 0, true, nil},
+		{"an unknown nested field when strict", "application/json", `{"items":[{"sku":"a"},{"sku":"b","size":2}]}`, 0, true, web.InvalidError{{Fld: "items.1.size", Err: "unknown"}}},
+		{"a field of the wrong type", "application/json", `{"count":"one"}`, 0, false, web.InvalidError{{Fld: "count", Err: "type"}}},
+	}
+
+	t.Log("Given the need to decode request bodies.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen decoding %s.", tt.name)
+			{
+				v := web.Values{Strict: tt.strict}
+				ctx := context.WithValue(context.Background(), web.KeyValues, &v)
+
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+				r.Header.Set("Content-Type", tt.contentType)
+				if tt.limit > 0 {
+					r.Body = http.MaxBytesReader(w, r.Body, tt.limit)
+				}
+
+				var th thing
+				err := web.Decode(ctx, r, &th)
+				if !reflect.DeepEqual(errors.Cause(err), tt.err) {
+					t.Fatalf("\t%s\tShould get error %v : got %v.", failed, tt.err, err)
+				}
+				t.Logf("\t%s\tShould get error %v.", success, tt.err)
+			}
+		}
+	}
+}
+
+// TestUnmarshalForm validates multipart forms are bound to their model.
+func TestUnmarshalForm(t *testing.T) {
+	type upload struct {
+		Name  string                  `form:"name"`
+		Tags  []string                `form:"tag"`
+		Files []*multipart.FileHeader `form:"file"`
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("name", "plan")
+	mw.WriteField("tag", "a")
+	mw.WriteField("tag", "b")
+	fw, _ := mw.CreateFormFile("file", "input.xlsx")
+	fw.Write([]byte("data"))
+	mw.Close()
+
+	t.Log("Given the need to bind multipart forms.")
+	{
+		t.Log("\tWhen binding a form with values and files.")
+		{
+			r := httptest.NewRequest("POST", "/", bytes.NewReader(body.Bytes()))
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+
+			var u upload
+			if err := web.UnmarshalForm(context.Background(), r, 1<<20, &u); err != nil {
+				t.Fatalf("\t%s\tShould bind the form : %v.", failed, err)
+			}
+			t.Logf("\t%s\tShould bind the form.", success)
+
+			if u.Name != "plan" || !reflect.DeepEqual(u.Tags, []string{"a", "b"}) || len(u.Files) != 1 || u.Files[0].Filename != "input.xlsx" {
+				t.Fatalf("\t%s\tShould bind every field : got %+v.", failed, u)
+			}
+			t.Logf("\t%s\tShould bind every field.", success)
+		}
+
+		t.Log("\tWhen binding a form past the limit.")
+		{
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/", bytes.NewReader(body.Bytes()))
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			r.Body = http.MaxBytesReader(w, r.Body, 10)
+
+			var u upload
+			if err := web.UnmarshalForm(context.Background(), r, 1<<20, &u); errors.Cause(err) != web.ErrBodyTooLarge {
+				t.Fatalf("\t%s\tShould get error %v : got %v.", failed, web.ErrBodyTooLarge, err)
+			}
+			t.Logf("\t%s\tShould get error %v.", success, web.ErrBodyTooLarge)
+		}
+	}
+}
+
+// TestBufferBody validates request bodies can be read again after being
+// buffered, whether or not they fit in memory.
+func TestBufferBody(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		limit int64
+		err   error
+	}{
+		{"a body that fits in memory", "small", 0, nil},
+		{"a body that does not fit in memory", strings.Repeat("large", 10), 0, nil},
+		{"a body past the limit", strings.Repeat("large", 10), 20, web.ErrBodyTooLarge},
+	}
+
+	t.Log("Given the need to read request bodies twice.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen buffering %s.", tt.name)
+			{
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+				if tt.limit > 0 {
+					r.Body = http.MaxBytesReader(w, r.Body, tt.limit)
+				}
+
+				var copied bytes.Buffer
+				done, err := web.BufferBody(r, 16, &copied)
+				if errors.Cause(err) != tt.err {
+					t.Fatalf("\t%s\tShould get error %v : got %v.", failed, tt.err, err)
+				}
+				t.Logf("\t%s\tShould get error %v.", success, tt.err)
+				if err != nil {
+					continue
+				}
+
+				b, err := web.ReadBody(r)
+				done()
+				if err != nil || string(b) != tt.body {
+					t.Fatalf("\t%s\tShould be able to read the body again : got %q %v.", failed, b, err)
+				}
+				t.Logf("\t%s\tShould be able to read the body again.", success)
+
+				if copied.String() != tt.body {
+					t.Fatalf("\t%s\tShould copy the body : got %q.", failed, copied.String())
+				}
+				t.Logf("\t%s\tShould copy the body.", success)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// CaptureWriter passes a response through to the client while keeping a
+// copy of its status, headers and body, so middleware can store or inspect
+// what was sent.
+type CaptureWriter struct {
+	http.ResponseWriter
+
+	// Status is the status sent, zero until the header is written.
+	Status int
+
+	// SentHeader is a copy of the headers as they were when the status
+	// was sent.
+	SentHeader http.Header
+
+	// Body holds everything written to the body.
+	Body bytes.Buffer
+}
+
+// NewCaptureWriter returns a CaptureWriter wrapping w.
+func NewCaptureWriter(w http.ResponseWriter) *CaptureWriter {
+	return &CaptureWriter{
+		ResponseWriter: w,
+	}
+}
+
+// WriteHeader implements the http.ResponseWriter interface.
+func (cw *CaptureWriter) WriteHeader(status int) {
+	if cw.Status != 0 {
+		return
+	}
+
+	cw.Status = status
+	cw.SentHeader = make(http.Header, len(cw.ResponseWriter.Header()))
+	for k, v := range cw.ResponseWriter.Header() {
+		cw.SentHeader[k] = append([]string(nil), v...)
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements the http.ResponseWriter interface.
+func (cw *CaptureWriter) Write(b []byte) (int, error) {
+	if cw.Status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	cw.Body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (cw *CaptureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,102 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrPreconditionFailed occurs when the entity has changed since the
+	// client retrieved the version it sent in If-Match.
+	ErrPreconditionFailed = NewError("precondition_failed", http.StatusPreconditionFailed, "The resource has changed since it was retrieved")
+
+	// ErrPreconditionRequired occurs when a change is sent without
+	// If-Match and changes are required to be conditional.
+	ErrPreconditionRequired = NewError("precondition_required", http.StatusPreconditionRequired, "The request must send If-Match with the resource's ETag")
+)
+
+// AnyVersion is passed in place of a version to change an entity whatever
+// its current version is.
+const AnyVersion = -1
+
+// ETag returns the entity tag for the version of an entity.
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ContentETag returns an entity tag for data that has no version, such as a
+// list of entities, derived from its JSON encoding.
+func ContentETag(data interface{}) (string, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", errors.Wrap(err, "marshalling for etag")
+	}
+
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// NotModified sets the ETag header and reports whether the client already
+// has the representation tagged etag according to If-None-Match, in which
+// case the handler should respond with 304 and no body.
+func NotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+
+	// If-None-Match uses the weak comparison so tags sent back with the W/
+	// prefix some proxies add still match.
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IfMatch returns the version of the entity the client expects to change
+// from the If-Match header. It returns AnyVersion when the header is
+// missing, unless required is set, or is "*". Only a single entity tag is
+// supported as a client only holds one representation of an entity.
+func IfMatch(r *http.Request, required bool) (int, error) {
+	im := strings.TrimSpace(r.Header.Get("If-Match"))
+
+	switch im {
+	case "":
+		if required {
+			return 0, ErrPreconditionRequired
+		}
+		return AnyVersion, nil
+
+	case "*":
+		return AnyVersion, nil
+	}
+
+	// If-Match uses the strong comparison so weak tags, lists and tags we
+	// did not issue never match.
+	if len(im) < 2 || im[0] != '"' || im[len(im)-1] != '"' {
+		return 0, Detail(ErrPreconditionFailed, "If-Match must hold a single strong ETag")
+	}
+
+	version, err := strconv.Atoi(im[1 : len(im)-1])
+	if err != nil || version < 0 {
+		return 0, Detail(ErrPreconditionFailed, "If-Match holds an ETag that was not issued by this API")
+	}
+
+	return version, nil
+}
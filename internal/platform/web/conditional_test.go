@@ -0,0 +1,93 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"inventory-optimisation-server/internal/platform/web"
+
+	"github.com/pkg/errors"
+)
+
+// TestConditional validates entity tags sent back by clients are matched
+// against the version of an entity.
+func TestConditional(t *testing.T) {
+	t.Log("Given the need to answer conditional requests.")
+	{
+		etag := web.ETag(3)
+
+		notModified := []struct {
+			header string
+			want   bool
+		}{
+			{"", false},
+			{`"3"`, true},
+			{`W/"3"`, true},
+			{`"2", "3"`, true},
+			{"*", true},
+			{`"2"`, false},
+		}
+
+		for _, tt := range notModified {
+			t.Logf("\tWhen sending If-None-Match %q.", tt.header)
+			{
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Set("If-None-Match", tt.header)
+				w := httptest.NewRecorder()
+
+				if got := web.NotModified(w, r, etag); got != tt.want {
+					t.Fatalf("\t%s\tShould report not modified %v : got %v.", failed, tt.want, got)
+				}
+				t.Logf("\t%s\tShould report not modified %v.", success, tt.want)
+
+				if got := w.Header().Get("ETag"); got != etag {
+					t.Fatalf("\t%s\tShould set the ETag %s : got %s.", failed, etag, got)
+				}
+				t.Logf("\t%s\tShould set the ETag.", success)
+			}
+		}
+
+		ifMatch := []struct {
+			header   string
+			required bool
+			version  int
+			err      error
+		}{
+			{"", false, web.AnyVersion, nil},
+			{"", true, 0, web.ErrPreconditionRequired},
+			{"*", true, web.AnyVersion, nil},
+			{`"3"`, true, 3, nil},
+			{`W/"3"`, false, 0, web.ErrPreconditionFailed},
+			{`"abc"`, false, 0, web.ErrPreconditionFailed},
+		}
+
+		for _, tt := range ifMatch {
+			t.Logf("\tWhen sending If-Match %q with required %v.", tt.header, tt.required)
+			{
+				r := httptest.NewRequest("PUT", "/", nil)
+				r.Header.Set("If-Match", tt.header)
+
+				version, err := web.IfMatch(r, tt.required)
+				if errors.Cause(err) != tt.err {
+					t.Fatalf("\t%s\tShould get error %v : got %v.", failed, tt.err, err)
+				}
+				if err == nil && version != tt.version {
+					t.Fatalf("\t%s\tShould get version %d : got %d.", failed, tt.version, version)
+				}
+				t.Logf("\t%s\tShould get the expected version.", success)
+			}
+		}
+
+		t.Log("\tWhen sending If-None-Match with a change.")
+		{
+			r := httptest.NewRequest("DELETE", "/", nil)
+			r.Header.Set("If-None-Match", etag)
+
+			if web.NotModified(httptest.NewRecorder(), r, etag) {
+				t.Fatalf("\t%s\tShould not report not modified for %s.", failed, http.MethodDelete)
+			}
+			t.Logf("\t%s\tShould not report not modified for %s.", success, http.MethodDelete)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package web
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// These are the formats Respond can send.
+const (
+	FormatJSON    = "application/json"
+	FormatCSV     = "text/csv"
+	FormatMsgpack = "application/msgpack"
+)
+
+// ErrNotAcceptable occurs when the client accepts none of the formats the
+// response can be sent in.
+var ErrNotAcceptable = NewError("not_acceptable", http.StatusNotAcceptable, "None of the accepted formats can be sent")
+
+// encodeFunc writes data to w in a format.
+type encodeFunc func(w io.Writer, data interface{}) error
+
+// isList reports whether data is a list of entities, which can be sent as
+// CSV with a row per entity.
+func isList(data interface{}) bool {
+	t := reflect.TypeOf(data)
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		return false
+	}
+
+	e := t.Elem()
+	if e.Kind() == reflect.Ptr {
+		e = e.Elem()
+	}
+	return e.Kind() == reflect.Struct
+}
+
+// encodeJSON returns an encoder for JSON, indented or compact. Lists are
+// encoded an element at a time as they are written rather than all at once
+// in memory.
+func encodeJSON(indent bool) encodeFunc {
+
+	// whole encodes a value on its own, element a value within a list.
+	whole, element := json.Marshal, json.Marshal
+	prefix := ""
+	if indent {
+		whole = func(v interface{}) ([]byte, error) {
+			return json.MarshalIndent(v, "", "  ")
+		}
+		element = func(v interface{}) ([]byte, error) {
+			return json.MarshalIndent(v, "  ", "  ")
+		}
+		prefix = "\n  "
+	}
+
+	f := func(w io.Writer, data interface{}) error {
+		rv := reflect.ValueOf(data)
+
+		// Byte slices are encoded as a single string, and empty lists have
+		// no elements to stream.
+		if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() == reflect.Uint8 || rv.Len() == 0 {
+			b, err := whole(data)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(b)
+			return err
+		}
+
+		bw := bufio.NewWriter(w)
+		bw.WriteByte('[')
+		for i := 0; i < rv.Len(); i++ {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			bw.WriteString(prefix)
+
+			b, err := element(rv.Index(i).Interface())
+			if err != nil {
+				return err
+			}
+			bw.Write(b)
+		}
+		if indent {
+			bw.WriteByte('\n')
+		}
+		bw.WriteByte(']')
+
+		return bw.Flush()
+	}
+
+	return f
+}
+
+// encodeCSV writes a list of entities as CSV with a header row. Columns are
+// named after the JSON fields. Values are written as they are in JSON,
+// strings without their quotes and nulls as empty cells. Strings that a
+// spreadsheet would run as a formula are escaped with a leading quote.
+func encodeCSV(w io.Writer, data interface{}) error {
+	rv := reflect.ValueOf(data)
+
+	t := rv.Type().Elem()
+	ptr := t.Kind() == reflect.Ptr
+	if ptr {
+		t = t.Elem()
+	}
+
+	var (
+		names []string
+		index []int
+	)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		names = append(names, name)
+		index = append(index, i)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(names)
+
+	row := make([]string, len(index))
+	for i := 0; i < rv.Len(); i++ {
+		e := rv.Index(i)
+		if ptr {
+			if e.IsNil() {
+				continue
+			}
+			e = e.Elem()
+		}
+
+		for j, fi := range index {
+			b, err := json.Marshal(e.Field(fi).Interface())
+			if err != nil {
+				return err
+			}
+
+			var s string
+			switch {
+			case string(b) == "null":
+				s = ""
+			case b[0] == '"':
+				json.Unmarshal(b, &s)
+				s = escapeFormula(s)
+			default:
+				s = string(b)
+			}
+			row[j] = s
+		}
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// formulaPrefixes are the characters that make a spreadsheet read a cell as
+// a formula.
+const formulaPrefixes = "=+-@\t\r"
+
+// escapeFormula prefixes s with a quote if a spreadsheet would run it as a
+// formula, so exported data can not run commands on the reader's machine.
+func escapeFormula(s string) string {
+	if s != "" && strings.IndexByte(formulaPrefixes, s[0]) >= 0 {
+		return "'" + s
+	}
+	return s
+}
+
+// encodeMsgpack writes data as MessagePack. The data is converted through
+// its JSON form so field names, omitted fields and custom encodings are the
+// same as in JSON. Like encodeJSON lists are converted an element at a time
+// as they are written rather than all at once in memory.
+func encodeMsgpack(w io.Writer, data interface{}) error {
+	bw := bufio.NewWriter(w)
+
+	rv := reflect.ValueOf(data)
+	if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() == reflect.Uint8 {
+		if err := writeMsgpackJSON(bw, data); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
+	appendMsgpackLen(bw, rv.Len(), 0x90, 15, 0, 0xdc, 0xdd)
+	for i := 0; i < rv.Len(); i++ {
+		if err := writeMsgpackJSON(bw, rv.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// writeMsgpackJSON writes v as MessagePack converted through its JSON form.
+func writeMsgpackJSON(b *bufio.Writer, v interface{}) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	d, err := decodeJSON(j)
+	if err != nil {
+		return err
+	}
+
+	return appendMsgpack(b, d)
+}
+
+// appendMsgpack appends a value decoded from JSON to b as MessagePack.
+// https://github.com/msgpack/msgpack/blob/master/spec.md
+func appendMsgpack(b *bufio.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteByte(0xc0)
+
+	case bool:
+		if v {
+			b.WriteByte(0xc3)
+		} else {
+			b.WriteByte(0xc2)
+		}
+
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			appendMsgpackInt(b, i)
+			break
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return errors.Wrapf(err, "encoding number %s", v)
+		}
+		b.WriteByte(0xcb)
+		binary.Write(b, binary.BigEndian, math.Float64bits(f))
+
+	case string:
+		appendMsgpackLen(b, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		b.WriteString(v)
+
+	case []interface{}:
+		appendMsgpackLen(b, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			if err := appendMsgpack(b, e); err != nil {
+				return err
+			}
+		}
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		appendMsgpackLen(b, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			appendMsgpack(b, k)
+			if err := appendMsgpack(b, v[k]); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return errors.Errorf("encoding %T", v)
+	}
+
+	return nil
+}
+
+// appendMsgpackInt appends an integer in the smallest form that holds it.
+func appendMsgpackInt(b *bufio.Writer, i int64) {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		b.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		b.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		b.WriteByte(0xd0)
+		b.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		b.WriteByte(0xd1)
+		binary.Write(b, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		b.WriteByte(0xd2)
+		binary.Write(b, binary.BigEndian, int32(i))
+	default:
+		b.WriteByte(0xd3)
+		binary.Write(b, binary.BigEndian, i)
+	}
+}
+
+// appendMsgpackLen appends the header of a string, array or map of length
+// n. Short values use the fix form, longer ones an 8, 16 or 32 bit length.
+// Arrays and maps have no 8 bit form.
+func appendMsgpackLen(b *bufio.Writer, n int, fix byte, fixMax int, c8, c16, c32 byte) {
+	switch {
+	case n <= fixMax:
+		b.WriteByte(fix | byte(n))
+	case c8 != 0 && n <= math.MaxUint8:
+		b.WriteByte(c8)
+		b.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(c16)
+		binary.Write(b, binary.BigEndian, uint16(n))
+	default:
+		b.WriteByte(c32)
+		binary.Write(b, binary.BigEndian, uint32(n))
+	}
+}
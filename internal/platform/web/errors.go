@@ -0,0 +1,167 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// APIError is an error with a stable, machine readable code that clients
+// can rely on. Packages declare their errors once with NewError and return
+// them, wrapped or not, from any layer. They are sent to the client as RFC
+// 7807 problem details.
+type APIError struct {
+	Code   string
+	Status int
+	Title  string
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	return e.Title
+}
+
+// detailError is an error with a description of this occurrence of it.
+type detailError struct {
+	err    error
+	detail string
+}
+
+// Error implements the error interface.
+func (e *detailError) Error() string {
+	return e.err.Error() + ": " + e.detail
+}
+
+// Cause returns the error described so errors.Cause still finds the
+// APIError.
+func (e *detailError) Cause() error {
+	return e.err
+}
+
+// Unwrap returns the error described.
+func (e *detailError) Unwrap() error {
+	return e.err
+}
+
+// Detail adds a description of this occurrence of err for the client, such
+// as which part of the request was wrong. It is sent as the detail of the
+// problem when err is caused by an APIError. Detail returns nil if err is
+// nil.
+func Detail(err error, detail string) error {
+	if err == nil {
+		return nil
+	}
+	return &detailError{err: err, detail: detail}
+}
+
+// detailOf returns the outermost detail added to err with Detail.
+func detailOf(err error) string {
+	for err != nil {
+		if d, ok := err.(*detailError); ok {
+			return d.detail
+		}
+		c, ok := err.(interface{ Cause() error })
+		if !ok {
+			return ""
+		}
+		err = c.Cause()
+	}
+	return ""
+}
+
+// registry holds every declared APIError by code.
+var registry = struct {
+	sync.Mutex
+	errs map[string]*APIError
+}{
+	errs: make(map[string]*APIError),
+}
+
+// NewError declares an APIError. Codes must be unique across the program,
+// declaring the same code twice panics.
+func NewError(code string, status int, title string) *APIError {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.errs[code]; ok {
+		panic(fmt.Sprintf("web: error code %q declared twice", code))
+	}
+
+	e := APIError{
+		Code:   code,
+		Status: status,
+		Title:  title,
+	}
+	registry.errs[code] = &e
+
+	return &e
+}
+
+// Errors returns every declared APIError ordered by code.
+func Errors() []*APIError {
+	registry.Lock()
+	defer registry.Unlock()
+
+	errs := make([]*APIError, 0, len(registry.errs))
+	for _, e := range registry.errs {
+		errs = append(errs, e)
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Code < errs[j].Code })
+
+	return errs
+}
+
+// These are the errors shared by every endpoint.
+var (
+	// ErrInternal is sent for any error that was not declared. The cause
+	// is logged but never sent to the client.
+	ErrInternal = NewError("internal", http.StatusInternalServerError, "Internal server error")
+
+	// ErrNotHealthy occurs when the service is having problems.
+	ErrNotHealthy = NewError("not_healthy", http.StatusServiceUnavailable, "Not healthy")
+
+	// ErrNotFound occurs when the route or entity does not exist.
+	ErrNotFound = NewError("not_found", http.StatusNotFound, "Entity not found")
+
+	// ErrInvalidID occurs when an ID is not in a valid form.
+	ErrInvalidID = NewError("invalid_id", http.StatusBadRequest, "ID is not in its proper form")
+
+	// ErrValidation occurs when there are validation errors.
+	ErrValidation = NewError("validation_failed", http.StatusBadRequest, "Validation errors occurred")
+
+	// ErrUnauthorized occurs when there was an issue validing the client's
+	// credentials.
+	ErrUnauthorized = NewError("unauthorized", http.StatusUnauthorized, "Unauthorized")
+
+	// ErrForbidden occurs when we know who the user is but they attempt a
+	// forbidden action.
+	ErrForbidden = NewError("forbidden", http.StatusForbidden, "Forbidden")
+)
+
+// ProblemType is the prefix of the type URI of every problem. The error
+// code completes it.
+const ProblemType = "urn:inventory-optimisation:problem:"
+
+// Problem is the response for errors that occur within the API.
+// https://tools.ietf.org/html/rfc7807
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	TraceID  string       `json:"trace_id,omitempty"`
+	Fields   InvalidError `json:"fields,omitempty"`
+}
+
+// newProblem returns the problem describing the APIError.
+func newProblem(e *APIError) Problem {
+	return Problem{
+		Type:   ProblemType + e.Code,
+		Title:  e.Title,
+		Status: e.Status,
+		Code:   e.Code,
+	}
+}
@@ -0,0 +1,133 @@
+package web_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+
+	"github.com/pkg/errors"
+)
+
+// TestError validates errors are described to the client as problem details
+// without leaking the cause of internal errors.
+func TestError(t *testing.T) {
+	fields := web.InvalidError{{Fld: "email", Err: "required"}}
+
+	tests := []struct {
+		name string
+		err  error
+		want web.Problem
+	}{
+		{"a declared error", web.ErrNotFound, web.Problem{
+			Type:     web.ProblemType + "not_found",
+			Title:    "Entity not found",
+			Status:   http.StatusNotFound,
+			Instance: "/v1/users/1",
+			Code:     "not_found",
+			TraceID:  "4bf92f3577b34da6a3ce929d0e0e4736",
+		}},
+		{"a wrapped declared error", errors.Wrap(web.ErrForbidden, "not an admin"), web.Problem{
+			Type:     web.ProblemType + "forbidden",
+			Title:    "Forbidden",
+			Status:   http.StatusForbidden,
+			Instance: "/v1/users/1",
+			Code:     "forbidden",
+			TraceID:  "4bf92f3577b34da6a3ce929d0e0e4736",
+		}},
+		{"a declared error with detail", errors.Wrap(web.Detail(web.ErrPatchFailed, "operation 0 (test /name) could not be applied"), "patching user"), web.Problem{
+			Type:     web.ProblemType + "patch_failed",
+			Title:    "Patch could not be applied",
+			Status:   http.StatusConflict,
+			Detail:   "operation 0 (test /name) could not be applied",
+			Instance: "/v1/users/1",
+			Code:     "patch_failed",
+			TraceID:  "4bf92f3577b34da6a3ce929d0e0e4736",
+		}},
+		{"an undeclared error with detail", web.Detail(errors.New("db.users.find: password=hunter2"), "finding user"), web.Problem{
+			Type:     web.ProblemType + "internal",
+			Title:    "Internal server error",
+			Status:   http.StatusInternalServerError,
+			Instance: "/v1/users/1",
+			Code:     "internal",
+			TraceID:  "4bf92f3577b34da6a3ce929d0e0e4736",
+		}},
+		{"a validation failure", errors.Wrap(fields, "decoding user"), web.Problem{
+			Type:     web.ProblemType + "validation_failed",
+			Title:    "Validation errors occurred",
+			Status:   http.StatusBadRequest,
+			Detail:   "field validation failure",
+			Instance: "/v1/users/1",
+			Code:     "validation_failed",
+			TraceID:  "4bf92f3577b34da6a3ce929d0e0e4736",
+			Fields:   fields,
+		}},
+		{"an undeclared error", errors.New("db.users.find: password=hunter2"), web.Problem{
+			Type:     web.ProblemType + "internal",
+			Title:    "Internal server error",
+			Status:   http.StatusInternalServerError,
+			Instance: "/v1/users/1",
+			Code:     "internal",
+			TraceID:  "4bf92f3577b34da6a3ce929d0e0e4736",
+		}},
+	}
+
+	t.Log("Given the need to describe errors to clients.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen handling %s.", tt.name)
+			{
+				v := web.Values{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", Path: "/v1/users/1"}
+				ctx := context.WithValue(context.Background(), web.KeyValues, &v)
+				w := httptest.NewRecorder()
+
+				web.Error(ctx, log.New(ioutil.Discard, log.ErrorLevel), w, tt.err)
+
+				if w.Code != tt.want.Status || v.StatusCode != tt.want.Status {
+					t.Fatalf("\t%s\tShould receive a status code of %d : got %d.", failed, tt.want.Status, w.Code)
+				}
+				t.Logf("\t%s\tShould receive a status code of %d.", success, tt.want.Status)
+
+				if got := w.Header().Get("Content-Type"); got != "application/problem+json" {
+					t.Fatalf("\t%s\tShould receive application/problem+json : got %s.", failed, got)
+				}
+				t.Logf("\t%s\tShould receive application/problem+json.", success)
+
+				var got web.Problem
+				if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+					t.Fatalf("\t%s\tShould be able to decode the problem : %s.", failed, err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Log("\t\tGot :", got)
+					t.Log("\t\tWant:", tt.want)
+					t.Fatalf("\t%s\tShould receive the expected problem.", failed)
+				}
+				t.Logf("\t%s\tShould receive the expected problem.", success)
+			}
+		}
+	}
+}
+
+// TestNewError validates error codes can only be declared once.
+func TestNewError(t *testing.T) {
+	t.Log("Given the need for error codes clients can rely on.")
+	{
+		t.Log("\tWhen declaring a code that is already declared.")
+		{
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("\t%s\tShould panic.", failed)
+				}
+				t.Logf("\t%s\tShould panic.", success)
+			}()
+
+			web.NewError("not_found", http.StatusNotFound, "Not found again")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/log"
+)
+
+// VersionHeader is the request header clients can use to pick an API
+// version instead of putting it in the path. The version that served the
+// request is echoed back in the same header.
+const VersionHeader = "API-Version"
+
+// ErrUnsupportedVersion occurs when a client asks for a version of the API
+// that is not served.
+var ErrUnsupportedVersion = NewError("unsupported_version", http.StatusBadRequest, "API version is not supported")
+
+// Group is a set of routes sharing a path prefix and middleware.
+type Group struct {
+	app    *App
+	prefix string
+	mw     []Middleware
+}
+
+// Group returns a group whose routes are mounted under the prefix and
+// wrapped with the middleware, after the application-wide middleware.
+func (a *App) Group(prefix string, mw ...Middleware) *Group {
+	return &Group{
+		app:    a,
+		prefix: strings.TrimSuffix(prefix, "/"),
+		mw:     mw,
+	}
+}
+
+// Group returns a nested group. Its prefix is appended to the parent's and
+// its middleware runs after the parent's.
+func (g *Group) Group(prefix string, mw ...Middleware) *Group {
+	return &Group{
+		app:    g.app,
+		prefix: g.prefix + strings.TrimSuffix(prefix, "/"),
+		mw:     join(g.mw, mw),
+	}
+}
+
+// Handle mounts the handler for the verb and path relative to the group's
+// prefix. The route's middleware runs after the group's.
+func (g *Group) Handle(verb, path string, handler Handler, mw ...Middleware) *Route {
+	return g.app.Handle(verb, g.prefix+path, handler, join(g.mw, mw)...)
+}
+
+// join returns a new slice holding a followed by b so groups never share
+// a backing array.
+func join(a, b []Middleware) []Middleware {
+	mw := make([]Middleware, 0, len(a)+len(b))
+	mw = append(mw, a...)
+	mw = append(mw, b...)
+	return mw
+}
+
+// Version returns a group serving a version of the API under /name, such as
+// /v1. The same handlers can be mounted in several versions and tell them
+// apart with Values.Version.
+//
+// A request whose path does not start with a served version is routed to
+// the version named in the API-Version header, or to the first version
+// declared when there is no header.
+func (a *App) Version(name string, mw ...Middleware) *Group {
+	if a.versions == nil {
+		a.versions = make(map[string]bool)
+	}
+	if len(a.versions) == 0 {
+		a.defaultVersion = name
+	}
+	a.versions[name] = true
+
+	// Record the version for the handlers and tell the client which
+	// version answered.
+	version := func(next Handler) Handler {
+		h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			ctx.Value(KeyValues).(*Values).Version = name
+			w.Header().Set(VersionHeader, name)
+			return next(ctx, log, w, r, params)
+		}
+		return h
+	}
+
+	return a.Group("/"+name, append([]Middleware{version}, mw...)...)
+}
+
+// ServeHTTP routes the request, first moving requests that do not name a
+// version in their path under the negotiated version.
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(a.versions) > 0 {
+		seg := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+		if !a.versions[seg] {
+			version := r.Header.Get(VersionHeader)
+			if version == "" {
+				version = a.defaultVersion
+			}
+
+			if !a.versions[version] {
+				v := Values{
+					Now:  time.Now(),
+					Path: r.URL.Path,
+				}
+				ctx := context.WithValue(r.Context(), KeyValues, &v)
+				Error(ctx, a.log, w, ErrUnsupportedVersion)
+				return
+			}
+
+			// The router matches on the raw request URI so move all three.
+			r.URL.Path = "/" + version + r.URL.Path
+			if r.URL.RawPath != "" {
+				r.URL.RawPath = "/" + version + r.URL.RawPath
+			}
+			if strings.HasPrefix(r.RequestURI, "/") {
+				r.RequestURI = "/" + version + r.RequestURI
+			}
+		}
+	}
+
+	a.TreeMux.ServeHTTP(w, r)
+}
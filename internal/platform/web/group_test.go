@@ -0,0 +1,87 @@
+package web_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// TestVersions validates versioned groups are reachable by path and by
+// header and share their middleware.
+func TestVersions(t *testing.T) {
+	var calls []string
+	mark := func(name string) web.Middleware {
+		return func(next web.Handler) web.Handler {
+			return func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+				calls = append(calls, name)
+				return next(ctx, log, w, r, params)
+			}
+		}
+	}
+
+	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		v := ctx.Value(web.KeyValues).(*web.Values)
+		web.Respond(ctx, log, w, map[string]string{"version": v.Version, "id": params["id"]}, http.StatusOK)
+		return nil
+	}
+
+	app := web.New(log.New(ioutil.Discard, log.ErrorLevel))
+	for _, v := range []*web.Group{app.Version("v1"), app.Version("v2")} {
+		v.Group("/things", mark("group")).Handle("GET", "/:id", h, mark("route"))
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		header  string
+		status  int
+		version string
+	}{
+		{"version in the path", "/v2/things/1", "", http.StatusOK, "v2"},
+		{"no version", "/things/1", "", http.StatusOK, "v1"},
+		{"version in the header", "/things/1", "v2", http.StatusOK, "v2"},
+		{"path wins over header", "/v1/things/1", "v2", http.StatusOK, "v1"},
+		{"unknown version", "/things/1", "v9", http.StatusBadRequest, ""},
+	}
+
+	t.Log("Given the need to serve several versions of the API.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen asking for a route with %s.", tt.name)
+			{
+				calls = nil
+
+				r := httptest.NewRequest("GET", tt.path, nil)
+				if tt.header != "" {
+					r.Header.Set(web.VersionHeader, tt.header)
+				}
+				w := httptest.NewRecorder()
+				app.ServeHTTP(w, r)
+
+				if w.Code != tt.status {
+					t.Fatalf("\t%s\tShould receive a %d status : %d %s.", failed, tt.status, w.Code, w.Body)
+				}
+				t.Logf("\t%s\tShould receive a %d status.", success, tt.status)
+
+				if got := w.Header().Get(web.VersionHeader); got != tt.version {
+					t.Fatalf("\t%s\tShould be served by version %q : %q.", failed, tt.version, got)
+				}
+				t.Logf("\t%s\tShould be served by version %q.", success, tt.version)
+
+				if tt.status == http.StatusOK && (len(calls) != 2 || calls[0] != "group" || calls[1] != "route") {
+					t.Fatalf("\t%s\tShould run the group middleware before the route's : %v.", failed, calls)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// accepted is one range of an Accept style header with its quality.
+type accepted struct {
+	value  string
+	params map[string]string
+	q      float64
+}
+
+// parseAccept parses an Accept, Accept-Encoding or similar header.
+func parseAccept(header string) []accepted {
+	var as []accepted
+
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		// Codings have no parameters of their own but the quality is
+		// written the same way so parse them as media types.
+		value, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		a := accepted{value: value, params: params, q: 1}
+		if q, ok := params["q"]; ok {
+			f, err := strconv.ParseFloat(q, 64)
+			if err != nil || f < 0 || f > 1 {
+				continue
+			}
+			a.q = f
+			delete(params, "q")
+		}
+
+		as = append(as, a)
+	}
+
+	return as
+}
+
+// specificity reports how closely the range matches the offer, or -1 when
+// it does not match. Exact matches beat type/* which beats */*.
+func specificity(rng, offer string) int {
+	switch {
+	case rng == offer:
+		return 2
+	case rng == "*" || rng == "*/*":
+		return 0
+	case strings.HasSuffix(rng, "/*") && strings.HasPrefix(offer, rng[:len(rng)-1]):
+		return 1
+	}
+	return -1
+}
+
+// Negotiate picks the offer the client prefers according to an Accept style
+// header, along with the parameters of the range that selected it. Offers
+// are listed in the server's order of preference, which breaks ties. An
+// empty header accepts the first offer. It returns an empty offer when none
+// is acceptable.
+func Negotiate(header string, offers []string) (string, map[string]string) {
+	if strings.TrimSpace(header) == "" {
+		if len(offers) == 0 {
+			return "", nil
+		}
+		return offers[0], nil
+	}
+
+	as := parseAccept(header)
+
+	var (
+		best       string
+		bestParams map[string]string
+		bestQ      float64
+	)
+	for _, offer := range offers {
+
+		// The most specific range matching the offer sets its quality.
+		spec := -1
+		var match accepted
+		for _, a := range as {
+			if s := specificity(a.value, offer); s > spec {
+				spec, match = s, a
+			}
+		}
+
+		if spec >= 0 && match.q > bestQ {
+			best, bestParams, bestQ = offer, match.params, match.q
+		}
+	}
+
+	return best, bestParams
+}
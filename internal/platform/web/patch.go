@@ -0,0 +1,361 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// These are the patch formats accepted by Patch.
+const (
+	MergePatch = "application/merge-patch+json"
+	JSONPatch  = "application/json-patch+json"
+)
+
+var (
+	// ErrUnsupportedMediaType occurs when the request body is in a format
+	// the route does not accept.
+	ErrUnsupportedMediaType = NewError("unsupported_media_type", http.StatusUnsupportedMediaType, "Content type is not supported")
+
+	// ErrInvalidPatch occurs when a patch document is malformed.
+	ErrInvalidPatch = NewError("patch_invalid", http.StatusBadRequest, "Patch document is malformed")
+
+	// ErrPatchFailed occurs when a patch can not be applied to the entity,
+	// because a location it names does not exist or a test operation failed.
+	ErrPatchFailed = NewError("patch_failed", http.StatusConflict, "Patch could not be applied")
+)
+
+// Patch applies the patch in the request body to doc, the JSON form of the
+// entity being changed, and returns the patched JSON. The body is a JSON
+// Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) depending on its
+// content type. The result should be passed to Unmarshal so it is validated
+// like any other request body.
+func Patch(r *http.Request, doc []byte) ([]byte, error) {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mt != MergePatch && mt != JSONPatch) {
+		return nil, Detail(ErrUnsupportedMediaType, "expected "+MergePatch+" or "+JSONPatch)
+	}
+
+	body, err := ReadBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := decodeJSON(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding entity")
+	}
+
+	switch mt {
+	case MergePatch:
+		p, err := decodeJSON(body)
+		if err != nil {
+			return nil, Detail(ErrInvalidPatch, err.Error())
+		}
+		target = mergePatch(target, p)
+
+	case JSONPatch:
+		var ops []patchOp
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return nil, Detail(ErrInvalidPatch, "a JSON Patch must be an array of operations")
+		}
+		for i, op := range ops {
+			if target, err = op.apply(target); err != nil {
+				return nil, Detail(err, op.describe(i, err))
+			}
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+// decodeJSON decodes a JSON document keeping numbers as they were written.
+func decodeJSON(b []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// mergePatch applies a merge patch to the target as described in RFC 7396.
+// Members set to null are removed, objects are merged and anything else
+// replaces what was there.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+
+	return t
+}
+
+// patchOp is a single operation of a JSON Patch.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// describe tells the client which operation, the i-th of the patch, failed
+// with err.
+func (op patchOp) describe(i int, err error) string {
+	desc := fmt.Sprintf("operation %d (%s", i, op.Op)
+	if op.Path != nil {
+		desc += " " + *op.Path
+	}
+	desc += ")"
+
+	if errors.Cause(err) == ErrInvalidPatch {
+		return desc + " is malformed"
+	}
+	return desc + " could not be applied"
+}
+
+// apply applies the operation to doc and returns the changed document.
+func (op patchOp) apply(doc interface{}) (interface{}, error) {
+	if op.Path == nil {
+		return nil, ErrInvalidPatch
+	}
+	path, err := pointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, ErrInvalidPatch
+		}
+		value, err := decodeJSON(op.Value)
+		if err != nil {
+			return nil, ErrInvalidPatch
+		}
+
+		switch op.Op {
+		case "add":
+			return add(doc, path, value)
+
+		case "replace":
+			if len(path) == 0 {
+				return value, nil
+			}
+			if doc, err = remove(doc, path); err != nil {
+				return nil, err
+			}
+			return add(doc, path, value)
+
+		default:
+			cur, err := get(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(cur, value) {
+				return nil, ErrPatchFailed
+			}
+			return doc, nil
+		}
+
+	case "remove":
+		return remove(doc, path)
+
+	case "move", "copy":
+		if op.From == nil {
+			return nil, ErrInvalidPatch
+		}
+		from, err := pointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "copy" {
+
+			// The copy must not share objects or arrays with the original
+			// or later operations would change both.
+			b, err := json.Marshal(value)
+			if err != nil {
+				return nil, errors.Wrap(err, "copying value")
+			}
+			if value, err = decodeJSON(b); err != nil {
+				return nil, errors.Wrap(err, "copying value")
+			}
+			return add(doc, path, value)
+		}
+
+		// A location can not be moved into one of its own children.
+		if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+			return nil, ErrPatchFailed
+		}
+		if doc, err = remove(doc, from); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	}
+
+	return nil, ErrInvalidPatch
+}
+
+// pointer splits a JSON Pointer (RFC 6901) into its reference tokens. The
+// empty pointer refers to the whole document.
+func pointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, ErrInvalidPatch
+	}
+
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// index parses an array index that must be less than n.
+func index(token string, n int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i >= n || (len(token) > 1 && token[0] == '0') {
+		return 0, ErrPatchFailed
+	}
+	return i, nil
+}
+
+// get returns the value at the path.
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, t := range path {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, ok := d[t]
+			if !ok {
+				return nil, ErrPatchFailed
+			}
+			doc = v
+
+		case []interface{}:
+			i, err := index(t, len(d))
+			if err != nil {
+				return nil, err
+			}
+			doc = d[i]
+
+		default:
+			return nil, ErrPatchFailed
+		}
+	}
+	return doc, nil
+}
+
+// change finds the container holding the last location of the path and
+// replaces it with what f returns. It returns the changed document.
+func change(doc interface{}, path []string, f func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return f(doc, path[0])
+	}
+
+	child, err := get(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = change(child, path[1:], f); err != nil {
+		return nil, err
+	}
+
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		d[path[0]] = child
+	case []interface{}:
+		i, _ := index(path[0], len(d))
+		d[i] = child
+	}
+	return doc, nil
+}
+
+// add adds the value at the path, inserting it when the path names an array
+// element.
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	f := func(parent interface{}, token string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[token] = value
+			return p, nil
+
+		case []interface{}:
+			if token == "-" {
+				return append(p, value), nil
+			}
+			i, err := index(token, len(p)+1)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+			return p, nil
+		}
+		return nil, ErrPatchFailed
+	}
+
+	return change(doc, path, f)
+}
+
+// remove removes the value at the path.
+func remove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, ErrPatchFailed
+	}
+
+	f := func(parent interface{}, token string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := p[token]; !ok {
+				return nil, ErrPatchFailed
+			}
+			delete(p, token)
+			return p, nil
+
+		case []interface{}:
+			i, err := index(token, len(p))
+			if err != nil {
+				return nil, err
+			}
+			return append(p[:i:i], p[i+1:]...), nil
+		}
+		return nil, ErrPatchFailed
+	}
+
+	return change(doc, path, f)
+}
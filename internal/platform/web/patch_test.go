@@ -0,0 +1,77 @@
+package web_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"inventory-optimisation-server/internal/platform/web"
+
+	"github.com/pkg/errors"
+)
+
+// TestPatch validates merge patches and JSON patches are applied to an
+// entity as their RFCs describe.
+func TestPatch(t *testing.T) {
+	doc := `{"name":"Jacob","roles":["ADMIN","USER"],"address":{"city":"Leeds"}}`
+
+	tests := []struct {
+		name        string
+		contentType string
+		patch       string
+		want        string
+		err         error
+	}{
+		{"merge patch", web.MergePatch, `{"name":"Anna","roles":[],"address":{"city":null}}`, `{"name":"Anna","roles":[],"address":{}}`, nil},
+		{"merge patch removing a member", web.MergePatch, `{"address":null}`, `{"name":"Jacob","roles":["ADMIN","USER"]}`, nil},
+		{"json patch", web.JSONPatch, `[
+			{"op":"test","path":"/name","value":"Jacob"},
+			{"op":"replace","path":"/name","value":"Anna"},
+			{"op":"remove","path":"/roles/0"},
+			{"op":"add","path":"/roles/-","value":"AUDITOR"},
+			{"op":"move","from":"/address/city","path":"/city"},
+			{"op":"copy","from":"/roles","path":"/address/roles"}
+		]`, `{"name":"Anna","roles":["USER","AUDITOR"],"city":"Leeds","address":{"roles":["USER","AUDITOR"]}}`, nil},
+		{"json patch with an escaped pointer", web.JSONPatch, `[{"op":"add","path":"/a~1b~0c","value":1}]`, `{"name":"Jacob","roles":["ADMIN","USER"],"address":{"city":"Leeds"},"a/b~c":1}`, nil},
+		{"failed test", web.JSONPatch, `[{"op":"test","path":"/name","value":"Anna"}]`, "", web.ErrPatchFailed},
+		{"missing location", web.JSONPatch, `[{"op":"remove","path":"/email"}]`, "", web.ErrPatchFailed},
+		{"array index out of range", web.JSONPatch, `[{"op":"add","path":"/roles/3","value":"X"}]`, "", web.ErrPatchFailed},
+		{"move into a child", web.JSONPatch, `[{"op":"move","from":"/address","path":"/address/home"}]`, "", web.ErrPatchFailed},
+		{"unknown operation", web.JSONPatch, `[{"op":"merge","path":"/name","value":"Anna"}]`, "", web.ErrInvalidPatch},
+		{"malformed patch", web.MergePatch, `{"name":`, "", web.ErrInvalidPatch},
+		{"plain json", "application/json", `{"name":"Anna"}`, "", web.ErrUnsupportedMediaType},
+	}
+
+	t.Log("Given the need to apply patches to an entity.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen applying a %s.", tt.name)
+			{
+				r := httptest.NewRequest("PATCH", "/", strings.NewReader(tt.patch))
+				r.Header.Set("Content-Type", tt.contentType)
+
+				got, err := web.Patch(r, []byte(doc))
+				if errors.Cause(err) != tt.err {
+					t.Fatalf("\t%s\tShould get error %v : got %v.", failed, tt.err, err)
+				}
+				t.Logf("\t%s\tShould get error %v.", success, tt.err)
+
+				if tt.err != nil {
+					continue
+				}
+
+				var g, w interface{}
+				json.Unmarshal(got, &g)
+				json.Unmarshal([]byte(tt.want), &w)
+				if !reflect.DeepEqual(g, w) {
+					t.Log("\t\tGot :", string(got))
+					t.Log("\t\tWant:", tt.want)
+					t.Fatalf("\t%s\tShould get the patched entity.", failed)
+				}
+				t.Logf("\t%s\tShould get the patched entity.", success)
+			}
+		}
+	}
+}
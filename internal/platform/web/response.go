@@ -1,92 +1,88 @@
 package web
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"mime/multipart"
-	"reflect"
-	"context"
-	"encoding/json"
-	"log"
 	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"inventory-optimisation-server/internal/platform/log"
 
 	"github.com/pkg/errors"
 )
 
-var (
-	// ErrNotHealthy occurs when the service is having problems.
-	ErrNotHealthy = errors.New("Not healthy")
+// Error handles all error responses for the API. Declared APIErrors, with
+// any detail added by Detail, and field validation failures are described
+// to the client, anything else is reported as an internal error without
+// detail.
+func Error(ctx context.Context, log *log.Logger, w http.ResponseWriter, err error) {
+	var p Problem
 
-	// ErrNotFound is abstracting the mgo not found error.
-	ErrNotFound = errors.New("Entity not found")
+	switch e := errors.Cause(err).(type) {
+	case *APIError:
+		p = newProblem(e)
+		p.Detail = detailOf(err)
 
-	// ErrInvalidID occurs when an ID is not in a valid form.
-	ErrInvalidID = errors.New("ID is not in its proper form")
-	
-	// ErrValidation occurs when there are validation errors.
-	ErrValidation = errors.New("Validation errors occurred")
+	case InvalidError:
+		p = newProblem(ErrValidation)
+		p.Detail = "field validation failure"
+		p.Fields = e
 
-	// ErrUnauthorized occurs when there was an issue validing the client's
-	// credentials.
-	ErrUnauthorized = errors.New("Unauthorized")
+	default:
+		p = newProblem(ErrInternal)
+	}
 
-	// ErrForbidden occurs when we know who the user is but they attempt a
-	// forbidden action.
-	ErrForbidden = errors.New("Forbidden")
-)
+	v := ctx.Value(KeyValues).(*Values)
+	p.Instance = v.Path
+	p.TraceID = v.TraceID
 
-// JSONError is the response for errors that occur within the API.
-type JSONError struct {
-	Error  string       `json:"error"`
-	Fields InvalidError `json:"fields,omitempty"`
+	respond(ctx, log, w, p, p.Status, "application/problem+json", encodeJSON(true))
 }
 
-// Error handles all error responses for the API.
-func Error(cxt context.Context, log *log.Logger, w http.ResponseWriter, err error) {
-	switch errors.Cause(err) {
-	case ErrNotHealthy:
-		RespondError(cxt, log, w, err, http.StatusInternalServerError)
-		return
-
-	case ErrNotFound:
-		RespondError(cxt, log, w, err, http.StatusNotFound)
-		return
-
-	case ErrValidation, ErrInvalidID:
-		RespondError(cxt, log, w, err, http.StatusBadRequest)
-		return
-
-	case ErrUnauthorized:
-		RespondError(cxt, log, w, err, http.StatusUnauthorized)
+// Respond sends data to the client in the format negotiated with the
+// request's Accept header: JSON, MessagePack or, for lists, CSV. JSON is
+// indented unless the client accepts application/json;pretty=false.
+// If code is StatusNoContent, v is expected to be nil.
+func Respond(ctx context.Context, log *log.Logger, w http.ResponseWriter, data interface{}, code int) {
+	if code == http.StatusNoContent || data == nil {
+		respond(ctx, log, w, nil, code, "", nil)
 		return
+	}
 
-	case ErrForbidden:
-		RespondError(cxt, log, w, err, http.StatusForbidden)
+	if fh, ok := data.(*multipart.FileHeader); ok {
+		respondFile(ctx, log, w, fh, code)
 		return
 	}
 
-	switch e := errors.Cause(err).(type) {
-	case InvalidError:
-		v := JSONError{
-			Error:  "field validation failure",
-			Fields: e,
-		}
+	v := ctx.Value(KeyValues).(*Values)
 
-		Respond(cxt, log, w, v, http.StatusBadRequest)
-		return
+	offers := []string{FormatJSON, FormatMsgpack, "application/x-msgpack"}
+	if isList(data) {
+		offers = append(offers, FormatCSV)
 	}
 
-	RespondError(cxt, log, w, err, http.StatusInternalServerError)
-}
-
-// RespondError sends JSON describing the error
-func RespondError(ctx context.Context, log *log.Logger, w http.ResponseWriter, err error, code int) {
-	Respond(ctx, log, w, JSONError{Error: err.Error()}, code)
+	format, params := Negotiate(v.Accept, offers)
+	w.Header().Add("Vary", "Accept")
+
+	switch format {
+	case FormatJSON:
+		respond(ctx, log, w, data, code, FormatJSON, encodeJSON(params["pretty"] != "false"))
+	case FormatCSV:
+		respond(ctx, log, w, data, code, FormatCSV+"; charset=utf-8", encodeCSV)
+	case "":
+		Error(ctx, log, w, ErrNotAcceptable)
+	default:
+		respond(ctx, log, w, data, code, format, encodeMsgpack)
+	}
 }
 
-// Respond sends JSON to the client.
-// If code is StatusNoContent, v is expected to be nil.
-func Respond(ctx context.Context, log *log.Logger, w http.ResponseWriter, data interface{}, code int) {
+// respond sends data encoded with encode as the content type. Large lists
+// are encoded as they are sent so by the time an encoding error occurs the
+// status has gone, it can only be logged.
+func respond(ctx context.Context, log *log.Logger, w http.ResponseWriter, data interface{}, code int, contentType string, encode encodeFunc) {
 
 	// Set the status code for the request logger middleware.
 	v := ctx.Value(KeyValues).(*Values)
@@ -99,34 +95,74 @@ func Respond(ctx context.Context, log *log.Logger, w http.ResponseWriter, data i
 		return
 	}
 
-	var multipartFileHeader *multipart.FileHeader
-	if reflect.TypeOf(data) == reflect.TypeOf(multipartFileHeader) {
-		multipartFileHeader = data.(*multipart.FileHeader)
-		file, err := multipartFileHeader.Open()
-		if err != nil {
-			RespondError(ctx, log, w, err, http.StatusInternalServerError)
-		}
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", multipartFileHeader.Filename))
-		w.WriteHeader(code)
-		io.Copy(w, file)
-	} else {
-		// Marshal the data into a JSON string.
-		jsonData, err := json.MarshalIndent(data, "", "  ")
-		if err != nil {
-			log.Printf("Respond %v Marshalling JSON response\n", err)
-
-			// Should respond with internal server error.
-			RespondError(ctx, log, w, err, http.StatusInternalServerError)
-			return
-		}
+	// Set the content type and write the status code to the response.
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
 
-		// Set the content type and headers once we know marshaling has succeeded.
-		w.Header().Set("Content-Type", "application/json")
+	// Send the result back to the client.
+	if err := encode(w, data); err != nil {
+		log.Error("encoding response", "content_type", contentType, "error", err)
+	}
+}
 
-		// Write the status code to the response and context.
-		w.WriteHeader(code)
+// respondFile sends the uploaded file as an attachment.
+func respondFile(ctx context.Context, log *log.Logger, w http.ResponseWriter, fh *multipart.FileHeader, code int) {
+	file, err := fh.Open()
+	if err != nil {
+		Error(ctx, log, w, err)
+		return
+	}
+	defer file.Close()
 
-		// Send the result back to the client.
-		w.Write(jsonData)
+	v := ctx.Value(KeyValues).(*Values)
+	v.StatusCode = code
+
+	w.Header().Set("Content-Disposition", contentDisposition(fh.Filename))
+	w.WriteHeader(code)
+	io.Copy(w, file)
+}
+
+// contentDisposition returns a Content-Disposition header sending a file as
+// an attachment under the name it was uploaded with (RFC 6266). The name is
+// chosen by whoever uploaded it so anything that could end the header or
+// point outside the download directory is removed. Clients that understand
+// filename* get the name as it was, others get it with characters outside
+// printable ASCII replaced.
+func contentDisposition(filename string) string {
+
+	// Only the last element of a path is kept, whichever separator it uses.
+	filename = strings.Replace(filename, "\\", "/", -1)
+	if i := strings.LastIndex(filename, "/"); i >= 0 {
+		filename = filename[i+1:]
+	}
+
+	var name, ascii strings.Builder
+	for _, c := range filename {
+		switch {
+		case c < ' ' || c == 0x7f || c == utf8.RuneError:
+			continue
+		case c > '~' || c == '"' || c == '\\' || c == '%':
+			ascii.WriteByte('_')
+		default:
+			ascii.WriteRune(c)
+		}
+		name.WriteRune(c)
 	}
+
+	if n := name.String(); n == "" || n == "." || n == ".." {
+		return "attachment"
+	}
+
+	// filename* is percent encoded apart from the characters RFC 5987
+	// allows as they are.
+	var enc strings.Builder
+	for _, b := range []byte(name.String()) {
+		if b < 0x80 && (b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || strings.IndexByte("!#$&+-.^_`|~", b) >= 0) {
+			enc.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&enc, "%%%02X", b)
+	}
+
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, ascii.String(), enc.String())
 }
@@ -0,0 +1,132 @@
+package web_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+)
+
+// TestRespond validates responses are sent in the format the client asks
+// for.
+func TestRespond(t *testing.T) {
+	type thing struct {
+		ID    string   `json:"id"`
+		Tags  []string `json:"tags"`
+		Count int      `json:"count"`
+		Note  *string  `json:"note"`
+	}
+	things := []thing{
+		{ID: "a", Tags: []string{"x"}, Count: 1},
+		{ID: "b", Count: -200},
+	}
+
+	formulas := []thing{
+		{ID: "=1+2"},
+		{ID: "@SUM(A1)"},
+		{ID: "-2+3"},
+	}
+
+	indented, _ := json.MarshalIndent(things, "", "  ")
+	compact, _ := json.Marshal(things)
+
+	tests := []struct {
+		name        string
+		accept      string
+		data        interface{}
+		status      int
+		contentType string
+		body        string
+	}{
+		{"no preference", "", things, http.StatusOK, "application/json", string(indented)},
+		{"json", "application/json", things, http.StatusOK, "application/json", string(indented)},
+		{"compact json", "application/json;pretty=false", things, http.StatusOK, "application/json", string(compact)},
+		{"csv", "text/csv, application/json;q=0.5", things, http.StatusOK, "text/csv; charset=utf-8", "id,tags,count,note\na,\"[\"\"x\"\"]\",1,\nb,,-200,\n"},
+		{"msgpack", "application/msgpack", map[string]interface{}{"a": 1, "b": []string{"x"}}, http.StatusOK, "application/msgpack", "\x82\xa1a\x01\xa1b\x91\xa1x"},
+		{"csv of cells a spreadsheet runs", "text/csv", formulas, http.StatusOK, "text/csv; charset=utf-8", "id,tags,count,note\n'=1+2,,0,\n'@SUM(A1),,0,\n'-2+3,,0,\n"},
+		{"msgpack of a list", "application/msgpack", things[:1], http.StatusOK, "application/msgpack", "\x91\x84\xa5count\x01\xa2id\xa1a\xa4note\xc0\xa4tags\x91\xa1x"},
+		{"csv for a single entity", "text/csv", things[0], http.StatusNotAcceptable, "application/problem+json", ""},
+		{"any text", "text/*", things, http.StatusOK, "text/csv; charset=utf-8", ""},
+	}
+
+	t.Log("Given the need to send responses in several formats.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen asking for %s.", tt.name)
+			{
+				v := web.Values{Accept: tt.accept}
+				ctx := context.WithValue(context.Background(), web.KeyValues, &v)
+				w := httptest.NewRecorder()
+
+				web.Respond(ctx, log.New(ioutil.Discard, log.ErrorLevel), w, tt.data, http.StatusOK)
+
+				if w.Code != tt.status {
+					t.Fatalf("\t%s\tShould receive a status code of %d : got %d.", failed, tt.status, w.Code)
+				}
+				t.Logf("\t%s\tShould receive a status code of %d.", success, tt.status)
+
+				if got := w.Header().Get("Content-Type"); got != tt.contentType {
+					t.Fatalf("\t%s\tShould receive %s : got %s.", failed, tt.contentType, got)
+				}
+				t.Logf("\t%s\tShould receive %s.", success, tt.contentType)
+
+				if tt.body != "" && w.Body.String() != tt.body {
+					t.Log("\t\tGot :", w.Body.String())
+					t.Log("\t\tWant:", tt.body)
+					t.Fatalf("\t%s\tShould receive the encoded body.", failed)
+				}
+				t.Logf("\t%s\tShould receive the encoded body.", success)
+			}
+		}
+	}
+}
+
+// TestRespondFile validates uploaded files are sent back under a name that
+// can not break out of the Content-Disposition header.
+func TestRespondFile(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "résumé \"q\";%.csv")
+	fw.Write([]byte("data"))
+	mw.Close()
+
+	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			return err
+		}
+		web.Respond(ctx, log, w, r.MultipartForm.File["file"][0], http.StatusOK)
+		return nil
+	}
+
+	app := web.New(log.New(ioutil.Discard, log.ErrorLevel))
+	app.Handle("POST", "/", h)
+
+	t.Log("Given the need to send uploaded files back.")
+	{
+		t.Log("\tWhen the file name holds quotes and characters outside ASCII.")
+		{
+			r := httptest.NewRequest("POST", "/", &body)
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			want := `attachment; filename="r_sum_ _q_;_.csv"; filename*=UTF-8''r%C3%A9sum%C3%A9%20%22q%22%3B%25.csv`
+			if got := w.Header().Get("Content-Disposition"); got != want {
+				t.Fatalf("\t%s\tShould encode the name : got %s.", failed, got)
+			}
+			t.Logf("\t%s\tShould encode the name.", success)
+
+			if w.Body.String() != "data" {
+				t.Fatalf("\t%s\tShould send the file : got %q.", failed, w.Body.String())
+			}
+			t.Logf("\t%s\tShould send the file.", success)
+		}
+	}
+}
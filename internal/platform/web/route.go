@@ -0,0 +1,96 @@
+package web
+
+import "time"
+
+// These are the ways a route can authenticate its caller.
+const (
+	AuthBearer = "bearer"
+	AuthBasic  = "basic"
+)
+
+// Route is a mounted handler along with its documentation.
+type Route struct {
+	Verb string
+	Path string
+	Doc  Doc
+
+	// MaxBody is the largest request body the route accepts, the
+	// application's limit applies when zero.
+	MaxBody int64
+
+	// ReadTimeout is how long the route gives clients to send the request
+	// body, the application's limit applies when zero.
+	ReadTimeout time.Duration
+
+	// Timeout is how long the route has to handle a request, the
+	// application's limit applies when zero.
+	Timeout time.Duration
+
+	// Strict rejects request bodies with unknown fields even when the
+	// application does not.
+	Strict bool
+}
+
+// Doc describes a route for the generated API documentation.
+type Doc struct {
+	Summary string
+
+	// Request and Response are values of the body types, such as
+	// user.NewUser{} or []user.User{}. Nil means there is no body.
+	Request  interface{}
+	Response interface{}
+
+	// Status is the status of a successful response, 200 when zero.
+	Status int
+
+	// Query maps the query parameters to their description.
+	Query map[string]string
+
+	// Auth is how the caller authenticates, empty when it does not. A
+	// route that works with or without credentials sets AuthOptional.
+	Auth         string
+	AuthOptional bool
+}
+
+// Describe sets the documentation of the route.
+func (rt *Route) Describe(d Doc) *Route {
+	rt.Doc = d
+	return rt
+}
+
+// LimitBody sets the largest request body the route accepts, overriding the
+// application's limit.
+func (rt *Route) LimitBody(n int64) *Route {
+	rt.MaxBody = n
+	return rt
+}
+
+// LimitRead sets how long the route gives clients to send the request body,
+// overriding the application's limit.
+func (rt *Route) LimitRead(d time.Duration) *Route {
+	rt.ReadTimeout = d
+	return rt
+}
+
+// LimitTime sets how long the route has to handle a request, overriding the
+// application's limit.
+func (rt *Route) LimitTime(d time.Duration) *Route {
+	rt.Timeout = d
+	return rt
+}
+
+// StrictDecoding makes the route reject request bodies with fields the
+// model does not have.
+func (rt *Route) StrictDecoding() *Route {
+	rt.Strict = true
+	return rt
+}
+
+// Routes returns every route mounted on the application in order.
+func (a *App) Routes() []Route {
+	routes := make([]Route, len(a.routes))
+	for i, rt := range a.routes {
+		routes[i] = *rt
+	}
+	return routes
+}
@@ -1,10 +1,16 @@
 package web
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
 
+	"github.com/pkg/errors"
 	validator "gopkg.in/go-playground/validator.v8"
 )
 
@@ -32,13 +38,145 @@ func (err InvalidError) Error() string {
 	return str
 }
 
+// strict reports whether the route rejects bodies with fields the model
+// does not have, see App.StrictDecoding.
+func strict(ctx context.Context) bool {
+	v, ok := ctx.Value(KeyValues).(*Values)
+	return ok && v.Strict
+}
+
 // Unmarshal decodes the input to the struct type and checks the
-// fields to verify the value is in a proper state.
-func Unmarshal(r io.Reader, v interface{}) error {
-	if err := json.NewDecoder(r).Decode(v); err != nil {
-		return err
+// fields to verify the value is in a proper state. The input must hold a
+// single JSON value. Routes decoding strictly reject fields the model does
+// not have.
+func Unmarshal(ctx context.Context, r io.Reader, v interface{}) error {
+	d := json.NewDecoder(r)
+
+	var raw json.RawMessage
+	if err := d.Decode(&raw); err != nil {
+		return decodeError(err)
+	}
+
+	// Anything after the value means the body is not what the client
+	// meant to send.
+	if _, err := d.Token(); err != io.EOF {
+		if err != nil {
+			return decodeError(err)
+		}
+		return Detail(ErrMalformedBody, "the body holds more than one JSON value")
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return decodeError(err)
+	}
+
+	if strict(ctx) {
+		if inv := unknownFields(raw, reflect.TypeOf(v), ""); inv != nil {
+			sort.Slice(inv, func(i, j int) bool { return inv[i].Fld < inv[j].Fld })
+			return inv
+		}
+	}
+
+	return check(v)
+}
+
+// unmarshalerType is the type of values that decode JSON themselves.
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// unknownFields returns the members of the JSON value in raw, and of the
+// objects within it, that have no field in a value of type t. Members are
+// named by their path from the body, such as items.0.sku.
+func unknownFields(raw json.RawMessage, t reflect.Type, prefix string) InvalidError {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return nil
+	}
+
+	// Values that are not what t expects were reported by json.Unmarshal
+	// so they are skipped here.
+	var inv InvalidError
+	switch t.Kind() {
+	case reflect.Struct:
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(raw, &obj) != nil {
+			return nil
+		}
+		fields := jsonFields(t)
+		for name, value := range obj {
+			ft, ok := fields[strings.ToLower(name)]
+			if !ok {
+				inv = append(inv, Invalid{Fld: prefix + name, Err: "unknown"})
+				continue
+			}
+			inv = append(inv, unknownFields(value, ft, prefix+name+".")...)
+		}
+
+	case reflect.Map:
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(raw, &obj) != nil {
+			return nil
+		}
+		for name, value := range obj {
+			inv = append(inv, unknownFields(value, t.Elem(), prefix+name+".")...)
+		}
+
+	case reflect.Slice, reflect.Array:
+		var arr []json.RawMessage
+		if json.Unmarshal(raw, &arr) != nil {
+			return nil
+		}
+		for i, value := range arr {
+			inv = append(inv, unknownFields(value, t.Elem(), fmt.Sprintf("%s%d.", prefix, i))...)
+		}
 	}
 
+	return inv
+}
+
+// jsonFields maps the lower cased JSON names of the fields of the struct
+// type t to their types. Like encoding/json it includes the fields of
+// embedded structs and matches names regardless of case.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for n, t := range jsonFields(ft) {
+					if _, ok := fields[n]; !ok {
+						fields[n] = t
+					}
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f.Type
+	}
+	return fields
+}
+
+// check validates the fields of the struct v points to.
+func check(v interface{}) error {
 	var inv InvalidError
 	if fve := validate.Struct(v); fve != nil {
 		for _, fe := range fve.(validator.ValidationErrors) {
@@ -49,3 +187,27 @@ func Unmarshal(r io.Reader, v interface{}) error {
 
 	return nil
 }
+
+// decodeError maps an error decoding JSON to the error sent to the client.
+// Fields of the wrong type or unknown to the model are reported like
+// validation failures so the client is told which field is wrong.
+func decodeError(err error) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return Detail(ErrMalformedBody, fmt.Sprintf("%s at offset %d", e, e.Offset))
+	case *json.UnmarshalTypeError:
+		return InvalidError{{Fld: e.Field, Err: "type"}}
+	}
+
+	var mbe *http.MaxBytesError
+	switch {
+	case errors.As(err, &mbe):
+		return tooLarge(mbe)
+	case err == io.EOF:
+		return Detail(ErrMalformedBody, "the body is empty")
+	case err == io.ErrUnexpectedEOF:
+		return Detail(ErrMalformedBody, "the body ends before the JSON value does")
+	}
+
+	return errors.Wrap(err, "decoding body")
+}
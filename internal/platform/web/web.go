@@ -2,10 +2,14 @@ package web
 
 import (
 	"context"
-	"log"
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/trace"
+
 	"github.com/dimfeld/httptreemux"
 )
 
@@ -17,7 +21,16 @@ const KeyValues ctxKey = 1
 
 // Values represent state for each request.
 type Values struct {
+	TraceID    string
+	RequestID  string
+	UserID     string
+	Route      string
+	Path       string
+	Version    string
+	Accept     string
 	Now        time.Time
+	Timeout    time.Duration
+	Strict     bool
 	StatusCode int
 	Error      bool
 }
@@ -33,43 +46,217 @@ type App struct {
 	*httptreemux.TreeMux
 	log *log.Logger
 	mw  []Middleware
+
+	// versions are the API versions served, see Version.
+	versions       map[string]bool
+	defaultVersion string
+
+	// routes are kept in the order they were mounted for documentation.
+	routes []*Route
+
+	// maxBody is the largest request body accepted by routes that do not
+	// set their own limit, see LimitBody.
+	maxBody int64
+
+	// readTimeout is how long routes that do not set their own limit give
+	// clients to send the request body, see LimitRead.
+	readTimeout time.Duration
+
+	// timeout is how long routes that do not set their own limit have to
+	// handle a request, and writeTimeout how long they then have to send
+	// the response, see LimitTime.
+	timeout      time.Duration
+	writeTimeout time.Duration
+
+	// strict makes every route reject request bodies with unknown fields,
+	// see StrictDecoding.
+	strict bool
 }
 
 // New creates an App value that handle a set of routes for the application.
 func New(log *log.Logger, mw ...Middleware) *App {
-	return &App{
+	a := App{
 		TreeMux: httptreemux.New(),
 		log:     log,
 		mw:      mw,
 	}
+
+	// OPTIONS requests to a mounted path pass through the application
+	// middleware, which answers CORS preflights, before reaching options.
+	// The route is not mounted so it is left out of the documentation.
+	a.TreeMux.OptionsHandler = a.serve(&Route{Verb: http.MethodOptions, Path: "*"}, wrapMiddleware(options, mw))
+
+	return &a
+}
+
+// options answers OPTIONS requests that are not CORS preflights.
+func options(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	Respond(ctx, log, w, nil, http.StatusNoContent)
+	return nil
 }
 
 // Handle is our mechanism for mounting Handlers for a given HTTP verb and path
-// pair, this makes for really easy, convenient routing.
-func (a *App) Handle(verb, path string, handler Handler, mw ...Middleware) {
+// pair, this makes for really easy, convenient routing. The returned Route
+// can be described for the API documentation.
+func (a *App) Handle(verb, path string, handler Handler, mw ...Middleware) *Route {
 
 	// Wrap up the application-wide first, this will call the first function
 	// of each middleware which will return a function of type Handler.
 	handler = wrapMiddleware(wrapMiddleware(handler, mw), a.mw)
 
+	rt := Route{
+		Verb: verb,
+		Path: path,
+	}
+
+	// Add this handler for the specified verb and route.
+	a.TreeMux.Handle(verb, path, a.serve(&rt, handler))
+
+	a.routes = append(a.routes, &rt)
+
+	return &rt
+}
+
+// serve returns the function the router calls for requests to the route,
+// which sets up the request values and calls the wrapped handler.
+func (a *App) serve(rt *Route, handler Handler) httptreemux.HandlerFunc {
+	verb, path := rt.Verb, rt.Path
+
 	// The function to execute for each request.
 	h := func(w http.ResponseWriter, r *http.Request, params map[string]string) {
 
+		// Reading past the limit fails so a client can not exhaust memory
+		// or disk with a huge body.
+		limit := rt.MaxBody
+		if limit == 0 {
+			limit = a.maxBody
+		}
+		if limit > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+
+		// A client trickling the body in to hold the connection open is cut
+		// off. Writers that can not set deadlines, such as in tests, are
+		// left to the server's timeouts.
+		rc := http.NewResponseController(w)
+		readTimeout := rt.ReadTimeout
+		if readTimeout == 0 {
+			readTimeout = a.readTimeout
+		}
+		if readTimeout > 0 {
+			rc.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+
+		// The route's time limit replaces the server's write timeout so
+		// slow routes are not cut off while responding.
+		timeout := rt.Timeout
+		if timeout == 0 {
+			timeout = a.timeout
+		}
+		if timeout > 0 && a.writeTimeout > 0 {
+			rc.SetWriteDeadline(time.Now().Add(timeout + a.writeTimeout))
+		}
+
+		// Continue the caller's trace when they sent one, otherwise start a
+		// new trace. A request id is only adopted as the trace id when it is
+		// a valid one, such as set by a proxy that traces.
+		reqID := requestID(r.Header.Get("X-Request-ID"))
+		traceID, parentID, ok := trace.ParseTraceparent(r.Header.Get("traceparent"))
+		if !ok && trace.ValidTraceID(reqID) {
+			traceID, parentID = reqID, ""
+		}
+
+		// Build on the request's context so client disconnects, server
+		// shutdown and deadlines reach every handler.
+		ctx, span := trace.StartSpanWithRemoteParent(r.Context(), verb+" "+path, traceID, parentID)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", path)
+		span.SetAttribute("http.target", r.URL.Path)
+		if reqID != "" {
+			span.SetAttribute("http.request_id", reqID)
+		} else {
+			reqID = span.TraceID
+		}
+
 		// Set the context with the required values to
 		// process the request.
 		v := Values{
-			Now: time.Now(),
+			TraceID:   span.TraceID,
+			RequestID: reqID,
+			Route:     path,
+			Path:      r.URL.Path,
+			Accept:    r.Header.Get("Accept"),
+			Now:       time.Now(),
+			Timeout:   timeout,
+			Strict:    a.strict || rt.Strict,
 		}
-
-		var ctx context.Context
 		ctx = context.WithValue(ctx, KeyValues, &v)
 
+		// Echo the id so clients can quote it when reporting a problem.
+		w.Header().Set("X-Request-ID", v.RequestID)
+
+		// Every entry logged while handling the request carries its trace
+		// id and route, and the client's request id when it has its own.
+		kv := []interface{}{"trace_id", v.TraceID, "route", verb + " " + path}
+		if v.RequestID != v.TraceID {
+			kv = append(kv, "request_id", v.RequestID)
+		}
+		log := a.log.With(kv...)
+
 		// Call the wrapped handler functions.
-		if err := handler(ctx, a.log, w, r, params); err != nil {
-			Error(ctx, a.log, w, err)
+		if err := handler(ctx, log, w, r, params); err != nil {
+			Error(ctx, log, w, err)
 		}
+
+		span.SetAttribute("http.status_code", strconv.Itoa(v.StatusCode))
+		if v.Error {
+			span.SetError(errors.New(http.StatusText(v.StatusCode)))
+		}
+		span.Finish()
 	}
 
-	// Add this handler for the specified verb and route.
-	a.TreeMux.Handle(verb, path, h)
+	return h
+}
+
+// LimitBody sets the largest request body accepted by routes that do not set
+// their own limit. Zero means no limit.
+func (a *App) LimitBody(n int64) {
+	a.maxBody = n
+}
+
+// LimitRead sets how long routes that do not set their own limit give
+// clients to send the request body, counted from when the request is
+// routed. Zero leaves it to the server's read timeout.
+func (a *App) LimitRead(d time.Duration) {
+	a.readTimeout = d
+}
+
+// LimitTime sets how long routes that do not set their own limit have to
+// handle a request, and how long any route then has to send its response.
+// The handler limit is enforced by middleware reading Values.Timeout. Zero
+// leaves it to the server's write timeout.
+func (a *App) LimitTime(handler, write time.Duration) {
+	a.timeout = handler
+	a.writeTimeout = write
+}
+
+// StrictDecoding makes Unmarshal and UnmarshalForm reject request bodies
+// with fields the model does not have, rather than ignoring them, on every
+// route. Routes can also opt in on their own.
+func (a *App) StrictDecoding(on bool) {
+	a.strict = on
+}
+
+// requestID returns the id if it is safe to log and echo back to the client,
+// otherwise it returns an empty string.
+func requestID(id string) string {
+	if len(id) == 0 || len(id) > 128 {
+		return ""
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return ""
+		}
+	}
+	return id
 }
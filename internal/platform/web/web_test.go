@@ -0,0 +1,154 @@
+package web_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"inventory-optimisation-server/internal/platform/log"
+	"inventory-optimisation-server/internal/platform/web"
+)
+
+// TestOptions validates OPTIONS requests to mounted paths pass through the
+// application middleware.
+func TestOptions(t *testing.T) {
+	mw := func(next web.Handler) web.Handler {
+		return func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			w.Header().Set("X-Seen", "true")
+			return next(ctx, log, w, r, params)
+		}
+	}
+
+	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		web.Respond(ctx, log, w, nil, http.StatusOK)
+		return nil
+	}
+
+	app := web.New(log.New(ioutil.Discard, log.ErrorLevel), mw)
+	app.Handle("GET", "/things/:id", h)
+
+	t.Log("Given the need to answer OPTIONS requests.")
+	{
+		t.Log("\tWhen sending OPTIONS to a mounted path.")
+		{
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/things/1", nil))
+
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("\t%s\tShould receive a status code of %d : got %d.", failed, http.StatusNoContent, w.Code)
+			}
+			t.Logf("\t%s\tShould receive a status code of %d.", success, http.StatusNoContent)
+
+			if w.Header().Get("X-Seen") != "true" {
+				t.Fatalf("\t%s\tShould pass through the application middleware.", failed)
+			}
+			t.Logf("\t%s\tShould pass through the application middleware.", success)
+
+			if n := len(app.Routes()); n != 1 {
+				t.Fatalf("\t%s\tShould not document the OPTIONS route : got %d routes.", failed, n)
+			}
+			t.Logf("\t%s\tShould not document the OPTIONS route.", success)
+		}
+
+		t.Log("\tWhen sending OPTIONS to an unknown path.")
+		{
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/other", nil))
+
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("\t%s\tShould receive a status code of %d : got %d.", failed, http.StatusNotFound, w.Code)
+			}
+			t.Logf("\t%s\tShould receive a status code of %d.", success, http.StatusNotFound)
+		}
+	}
+}
+
+// TestLimitTime validates routes get their own time limit or the
+// application's.
+func TestLimitTime(t *testing.T) {
+	var got time.Duration
+	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		got = ctx.Value(web.KeyValues).(*web.Values).Timeout
+		web.Respond(ctx, log, w, nil, http.StatusNoContent)
+		return nil
+	}
+
+	app := web.New(log.New(ioutil.Discard, log.ErrorLevel))
+	app.LimitTime(2*time.Second, time.Second)
+	app.Handle("GET", "/default", h)
+	app.Handle("POST", "/upload", h).LimitTime(time.Minute)
+
+	tests := []struct {
+		verb    string
+		path    string
+		timeout time.Duration
+	}{
+		{"GET", "/default", 2 * time.Second},
+		{"POST", "/upload", time.Minute},
+	}
+
+	t.Log("Given the need to limit how long routes take.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen sending %s %s.", tt.verb, tt.path)
+			{
+				app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.verb, tt.path, nil))
+
+				if got != tt.timeout {
+					t.Fatalf("\t%s\tShould have a limit of %s : got %s.", failed, tt.timeout, got)
+				}
+				t.Logf("\t%s\tShould have a limit of %s.", success, tt.timeout)
+			}
+		}
+	}
+}
+
+// TestRequestID validates the client's request id is echoed back but only
+// becomes the trace id when it is a valid one.
+func TestRequestID(t *testing.T) {
+	var got web.Values
+	h := func(ctx context.Context, log *log.Logger, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		got = *ctx.Value(web.KeyValues).(*web.Values)
+		web.Respond(ctx, log, w, nil, http.StatusNoContent)
+		return nil
+	}
+
+	app := web.New(log.New(ioutil.Discard, log.ErrorLevel))
+	app.Handle("GET", "/things", h)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	tests := []struct {
+		requestID string
+		traceID   bool
+	}{
+		{"order-1234", false},
+		{traceID, true},
+	}
+
+	t.Log("Given the need to correlate requests with the client's id.")
+	{
+		for _, tt := range tests {
+			t.Logf("\tWhen sending the request id %q.", tt.requestID)
+			{
+				r := httptest.NewRequest("GET", "/things", nil)
+				r.Header.Set("X-Request-ID", tt.requestID)
+				w := httptest.NewRecorder()
+				app.ServeHTTP(w, r)
+
+				if got.RequestID != tt.requestID || w.Header().Get("X-Request-ID") != tt.requestID {
+					t.Fatalf("\t%s\tShould echo the request id : got %q.", failed, w.Header().Get("X-Request-ID"))
+				}
+				t.Logf("\t%s\tShould echo the request id.", success)
+
+				if (got.TraceID == traceID) != tt.traceID || len(got.TraceID) != 32 {
+					t.Fatalf("\t%s\tShould only adopt a valid trace id : got %q.", failed, got.TraceID)
+				}
+				t.Logf("\t%s\tShould only adopt a valid trace id.", success)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package user
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// These are the supported values for Hasher.Algorithm.
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// argon2idPrefix identifies hashes stored in the PHC string format produced
+// by the argon2id algorithm. Anything else is treated as a bcrypt hash.
+var argon2idPrefix = []byte("$argon2id$")
+
+// Argon2Params are the work factors used when hashing with argon2id.
+type Argon2Params struct {
+	Time       uint32
+	Memory     uint32 // In KiB.
+	Threads    uint8
+	KeyLength  uint32
+	SaltLength uint32
+}
+
+// Hasher generates and verifies password hashes. The zero value hashes with
+// bcrypt at bcrypt.DefaultCost.
+type Hasher struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+// Hash returns the hash of the password using the configured algorithm.
+func (h Hasher) Hash(password string) ([]byte, error) {
+	switch h.algorithm() {
+	case AlgorithmArgon2id:
+		p := h.argon2()
+
+		salt := make([]byte, p.SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, errors.Wrap(err, "generating salt")
+		}
+
+		key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLength)
+
+		hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, p.Memory, p.Time, p.Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		)
+		return []byte(hash), nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost())
+	if err != nil {
+		return nil, errors.Wrap(err, "generating password hash")
+	}
+	return hash, nil
+}
+
+// Compare checks the password against a hash produced by either algorithm.
+// It returns ErrAuthenticationFailure when they do not match.
+func (h Hasher) Compare(hash []byte, password string) error {
+	if !bytes.HasPrefix(hash, argon2idPrefix) {
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+			return ErrAuthenticationFailure
+		}
+		return nil
+	}
+
+	p, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return errors.Wrap(err, "decoding password hash")
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrAuthenticationFailure
+	}
+
+	return nil
+}
+
+// NeedsRehash reports whether the hash was produced with a different
+// algorithm or weaker work factors than the Hasher is configured for.
+func (h Hasher) NeedsRehash(hash []byte) bool {
+	if !bytes.HasPrefix(hash, argon2idPrefix) {
+		if h.algorithm() != AlgorithmBcrypt {
+			return true
+		}
+
+		cost, err := bcrypt.Cost(hash)
+		if err != nil {
+			return true
+		}
+		return cost < h.bcryptCost()
+	}
+
+	if h.algorithm() != AlgorithmArgon2id {
+		return true
+	}
+
+	p, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	want := h.argon2()
+	return p.Time < want.Time || p.Memory < want.Memory || p.Threads < want.Threads || p.KeyLength < want.KeyLength
+}
+
+// algorithm returns the configured algorithm, defaulting to bcrypt.
+func (h Hasher) algorithm() string {
+	if strings.EqualFold(h.Algorithm, AlgorithmArgon2id) {
+		return AlgorithmArgon2id
+	}
+	return AlgorithmBcrypt
+}
+
+// bcryptCost returns the configured cost, defaulting to bcrypt.DefaultCost.
+func (h Hasher) bcryptCost() int {
+	if h.BcryptCost < bcrypt.MinCost {
+		return bcrypt.DefaultCost
+	}
+	return h.BcryptCost
+}
+
+// argon2 returns the configured parameters with defaults applied. The
+// defaults are the ones recommended by the argon2 package documentation.
+func (h Hasher) argon2() Argon2Params {
+	p := h.Argon2
+	if p.Time == 0 {
+		p.Time = 1
+	}
+	if p.Memory == 0 {
+		p.Memory = 64 * 1024
+	}
+	if p.Threads == 0 {
+		p.Threads = 4
+	}
+	if p.KeyLength == 0 {
+		p.KeyLength = 32
+	}
+	if p.SaltLength == 0 {
+		p.SaltLength = 16
+	}
+	return p
+}
+
+// decodeArgon2id parses a PHC formatted argon2id hash of the form
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>.
+func decodeArgon2id(hash []byte) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 {
+		return p, nil, nil, errors.New("invalid argon2id hash format")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, errors.Wrap(err, "parsing version")
+	}
+	if version != argon2.Version {
+		return p, nil, nil, errors.Errorf("unsupported argon2 version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, errors.Wrap(err, "parsing parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errors.Wrap(err, "decoding salt")
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, errors.Wrap(err, "decoding key")
+	}
+
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
@@ -0,0 +1,77 @@
+package user_test
+
+import (
+	"testing"
+
+	"inventory-optimisation-server/internal/user"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestHasher validates hashing, comparing and rehash detection across the
+// supported algorithms.
+func TestHasher(t *testing.T) {
+	const (
+		success = "\u2713"
+		failed  = "\u2717"
+	)
+
+	weak := user.Hasher{BcryptCost: bcrypt.MinCost}
+	strong := user.Hasher{BcryptCost: bcrypt.MinCost + 1}
+	argon := user.Hasher{
+		Algorithm: user.AlgorithmArgon2id,
+		Argon2:    user.Argon2Params{Time: 1, Memory: 1024, Threads: 1},
+	}
+
+	t.Log("Given the need to hash and verify passwords.")
+	{
+		for _, h := range []user.Hasher{weak, argon} {
+			t.Logf("\tWhen hashing with %q.", h.Algorithm)
+			{
+				hash, err := h.Hash("Gophers4Ever")
+				if err != nil {
+					t.Fatalf("\t%s\tShould be able to hash the password : %s.", failed, err)
+				}
+				t.Logf("\t%s\tShould be able to hash the password.", success)
+
+				if err := h.Compare(hash, "Gophers4Ever"); err != nil {
+					t.Fatalf("\t%s\tShould accept the right password : %s.", failed, err)
+				}
+				t.Logf("\t%s\tShould accept the right password.", success)
+
+				if err := h.Compare(hash, "gophers4ever"); err != user.ErrAuthenticationFailure {
+					t.Fatalf("\t%s\tShould reject the wrong password : %v.", failed, err)
+				}
+				t.Logf("\t%s\tShould reject the wrong password.", success)
+
+				if h.NeedsRehash(hash) {
+					t.Fatalf("\t%s\tShould not need a rehash with the same settings.", failed)
+				}
+				t.Logf("\t%s\tShould not need a rehash with the same settings.", success)
+			}
+		}
+
+		t.Log("\tWhen the configured work factor is raised.")
+		{
+			hash, err := weak.Hash("Gophers4Ever")
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to hash the password : %s.", failed, err)
+			}
+
+			if !strong.NeedsRehash(hash) {
+				t.Fatalf("\t%s\tShould need a rehash for a higher bcrypt cost.", failed)
+			}
+			t.Logf("\t%s\tShould need a rehash for a higher bcrypt cost.", success)
+
+			if !argon.NeedsRehash(hash) {
+				t.Fatalf("\t%s\tShould need a rehash when moving to argon2id.", failed)
+			}
+			t.Logf("\t%s\tShould need a rehash when moving to argon2id.", success)
+
+			if err := argon.Compare(hash, "Gophers4Ever"); err != nil {
+				t.Fatalf("\t%s\tShould still verify bcrypt hashes : %s.", failed, err)
+			}
+			t.Logf("\t%s\tShould still verify bcrypt hashes.", success)
+		}
+	}
+}
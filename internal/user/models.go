@@ -15,8 +15,18 @@ type User struct {
 
 	PasswordHash []byte `bson:"password_hash" json:"-"`
 
-	DateModified time.Time `bson:"date_modified" json:"date_modified"`
-	DateCreated  time.Time `bson:"date_created,omitempty" json:"date_created"`
+	// ExternalIssuer and ExternalSubject identify users provisioned from an
+	// external identity provider.
+	ExternalIssuer  string `bson:"external_issuer,omitempty" json:"external_issuer,omitempty"`
+	ExternalSubject string `bson:"external_subject,omitempty" json:"-"`
+
+	// Version is incremented by every change so clients can tell whether
+	// the user changed since they retrieved it. It is sent as the ETag.
+	Version int `bson:"version" json:"version"`
+
+	DateModified time.Time  `bson:"date_modified" json:"date_modified"`
+	DateCreated  time.Time  `bson:"date_created,omitempty" json:"date_created"`
+	DeletedAt    *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
 }
 
 // NewUser contains information needed to create a new User.
@@ -28,18 +38,25 @@ type NewUser struct {
 	PasswordConfirm string   `json:"password_confirm" validate:"eqfield=Password"`
 }
 
-// UpdateUser defines what information may be provided to modify an existing
-// User. All fields are optional so clients can send just the fields they want
-// changed. It uses pointer fields so we can differentiate between a field that
-// was not provided and a field that was provided as explicitly blank. Normally
-// we do not want to use pointers to basic types but we make exceptions around
-// marshalling/unmarshalling.
+// UpdateUser defines the information that replaces an existing User with
+// PUT. It is also the document PATCH is applied to, so clearing a field
+// means sending it empty. The password is left unchanged when it is empty.
 type UpdateUser struct {
-	Name            *string  `json:"name"`
-	Email           *string  `json:"email"` // TODO(jlw) enforce uniqueness.
-	Roles           []string `json:"roles"` // TODO(jlw) Ensure only includes valid roles.
-	Password        *string  `json:"password"`
-	PasswordConfirm *string  `json:"password_confirm" validate:"omitempty,eqfield=Password"`
+	Name            string   `json:"name" validate:"required"`
+	Email           string   `json:"email" validate:"required"` // TODO(jlw) enforce uniqueness.
+	Roles           []string `json:"roles" validate:"required"` // TODO(jlw) Ensure only includes valid roles.
+	Password        string   `json:"password,omitempty"`
+	PasswordConfirm string   `json:"password_confirm,omitempty" validate:"eqfield=Password"`
+}
+
+// ExternalUser contains the information an external identity provider
+// asserts about a user logging in through it.
+type ExternalUser struct {
+	Issuer  string
+	Subject string
+	Name    string
+	Email   string
+	Roles   []string
 }
 
 // Token is the payload we deliver to users when they authenticate.
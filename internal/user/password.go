@@ -0,0 +1,303 @@
+package user
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"inventory-optimisation-server/internal/platform/web"
+
+	"github.com/pkg/errors"
+)
+
+// bcryptMaxLength is the number of bytes bcrypt will consider when hashing a
+// password. Anything past this is silently ignored by the algorithm.
+const bcryptMaxLength = 72
+
+// PasswordPolicy describes the rules a password must satisfy before it can be
+// stored for a user.
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	DisallowEmail bool
+
+	// Breached is consulted last, if set, to reject passwords that are known
+	// to have appeared in a data breach.
+	Breached *HashList
+}
+
+// Check validates the password against the policy. The email is the address
+// of the user the password belongs to. Any violations are returned as a
+// web.InvalidError against the password field.
+func (p PasswordPolicy) Check(email, password string) error {
+	var inv web.InvalidError
+
+	fail := func(tag string) {
+		inv = append(inv, web.Invalid{Fld: "password", Err: tag})
+	}
+
+	maxLength := p.MaxLength
+	if maxLength <= 0 || maxLength > bcryptMaxLength {
+		maxLength = bcryptMaxLength
+	}
+
+	if len([]rune(password)) < p.MinLength {
+		fail(fmt.Sprintf("min=%d", p.MinLength))
+	}
+	if len(password) > maxLength {
+		fail(fmt.Sprintf("max=%d", maxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r), unicode.IsSymbol(r), unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		fail("upper")
+	}
+	if p.RequireLower && !lower {
+		fail("lower")
+	}
+	if p.RequireDigit && !digit {
+		fail("digit")
+	}
+	if p.RequireSymbol && !symbol {
+		fail("symbol")
+	}
+
+	if p.DisallowEmail && containsEmail(password, email) {
+		fail("contains_email")
+	}
+
+	// Only pay for the breach lookup when everything else passed.
+	if inv == nil && p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return errors.Wrap(err, "checking breached passwords")
+		}
+		if breached {
+			fail("breached")
+		}
+	}
+
+	if inv != nil {
+		return inv
+	}
+
+	return nil
+}
+
+// containsEmail reports whether the password contains the email address or
+// the local part of it, ignoring case.
+func containsEmail(password, email string) bool {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+
+	password = strings.ToLower(password)
+	if strings.Contains(password, email) {
+		return true
+	}
+
+	if i := strings.Index(email, "@"); i > 0 {
+		return strings.Contains(password, email[:i])
+	}
+
+	return false
+}
+
+// HashList is an offline set of breached password hashes. The hashes are
+// looked up in the file by binary search so even the full Pwned Passwords
+// list, hundreds of millions of hashes, is never loaded into memory.
+type HashList struct {
+	f *os.File
+
+	// start is the offset of the first hash, past any comments, and size
+	// the length of the file.
+	start int64
+	size  int64
+}
+
+// maxHashLine is longer than any valid line of a hash list.
+const maxHashLine = 128
+
+// NewHashList opens a list of SHA-1 password hashes in the named file. Each
+// line holds an upper or lower case hex hash optionally followed by a colon
+// and an occurrence count, e.g. "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3".
+// The lines must be sorted by hash, as in the Pwned Passwords download
+// ordered by hash. Lines starting with # are only allowed before the first
+// hash.
+func NewHashList(path string) (*HashList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening hash list")
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "opening hash list")
+	}
+
+	hl := HashList{
+		f:    f,
+		size: fi.Size(),
+	}
+
+	// Skip the comments at the top and check the first hash so a file in
+	// the wrong format is caught now rather than missing every lookup.
+	for hl.start < hl.size {
+		line, next, err := hl.line(hl.start)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			hl.start = next
+			continue
+		}
+		if _, err := parseHash(line); err != nil {
+			f.Close()
+			return nil, err
+		}
+		break
+	}
+
+	return &hl, nil
+}
+
+// Close closes the file of the list.
+func (hl *HashList) Close() error {
+	return hl.f.Close()
+}
+
+// Contains reports whether the password appears in the list. It is safe for
+// concurrent use.
+func (hl *HashList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// Every line starting in [lo, hi) may hold the hash. lo is always the
+	// start of a line.
+	lo, hi := hl.start, hl.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		at, err := hl.lineStart(mid)
+		if err != nil {
+			return false, err
+		}
+		if at >= hi {
+			hi = mid
+			continue
+		}
+
+		line, next, err := hl.line(at)
+		if err != nil {
+			return false, err
+		}
+
+		// Blank lines can only trail the hashes.
+		if line == "" {
+			hi = at
+			continue
+		}
+
+		h, err := parseHash(line)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case h == hash:
+			return true, nil
+		case h < hash:
+			lo = next
+		default:
+			hi = at
+		}
+	}
+
+	return false, nil
+}
+
+// lineStart returns the offset of the first line starting at or after off.
+func (hl *HashList) lineStart(off int64) (int64, error) {
+	if off <= hl.start {
+		return hl.start, nil
+	}
+
+	// The line starts after the first newline from the byte before off.
+	b := make([]byte, maxHashLine)
+	n, err := hl.f.ReadAt(b, off-1)
+	if err != nil && err != io.EOF {
+		return 0, errors.Wrap(err, "reading hash list")
+	}
+
+	i := bytes.IndexByte(b[:n], '\n')
+	if i < 0 {
+		if n < len(b) {
+			return hl.size, nil
+		}
+		return 0, errors.Errorf("hash list line at %d is too long", off)
+	}
+	return off + int64(i), nil
+}
+
+// line returns the line starting at off, without its line ending, and the
+// offset of the next line.
+func (hl *HashList) line(off int64) (string, int64, error) {
+	b := make([]byte, maxHashLine)
+	n, err := hl.f.ReadAt(b, off)
+	if err != nil && err != io.EOF {
+		return "", 0, errors.Wrap(err, "reading hash list")
+	}
+	b = b[:n]
+
+	next := off + int64(n)
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+		next = off + int64(i) + 1
+	} else if n == maxHashLine {
+		return "", 0, errors.Errorf("hash list line at %d is too long", off)
+	}
+
+	return strings.TrimSpace(string(b)), next, nil
+}
+
+// parseHash returns the upper case hash of a line of a hash list.
+func parseHash(line string) (string, error) {
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+
+	hash := strings.ToUpper(line)
+	if len(hash) != sha1.Size*2 {
+		return "", errors.Errorf("hash list: invalid hash %q", line)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", errors.Errorf("hash list: invalid hash %q", line)
+	}
+
+	return hash, nil
+}
@@ -0,0 +1,185 @@
+package user_test
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"inventory-optimisation-server/internal/platform/web"
+	"inventory-optimisation-server/internal/user"
+
+	"github.com/pkg/errors"
+)
+
+// TestPasswordPolicy validates the password rules applied to new passwords.
+func TestPasswordPolicy(t *testing.T) {
+	const (
+		success = "\u2713"
+		failed  = "\u2717"
+	)
+
+	dir, err := ioutil.TempDir("", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// SHA-1 of "Password1234", in the range download format.
+	list := filepath.Join(dir, "breached.txt")
+	if err := ioutil.WriteFile(list, []byte("# breached\n5B96672AE7709EAB297550CAE362D5BEE468C57D:12\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	hl, err := user.NewHashList(list)
+	if err != nil {
+		t.Fatalf("\t%s\tShould be able to load the hash list : %s.", failed, err)
+	}
+
+	policy := user.PasswordPolicy{
+		MinLength:     10,
+		MaxLength:     72,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		DisallowEmail: true,
+		Breached:      hl,
+	}
+
+	tt := []struct {
+		name     string
+		email    string
+		password string
+		want     []string
+	}{
+		{"valid", "bill@ardanlabs.com", "Gophers4Ever", nil},
+		{"single char", "bill@ardanlabs.com", "a", []string{"min=10", "upper", "digit"}},
+		{"too long", "bill@ardanlabs.com", "Aa1" + strings.Repeat("x", 70), []string{"max=72"}},
+		{"no classes", "bill@ardanlabs.com", "gopherslovego", []string{"upper", "digit"}},
+		{"email", "bill@ardanlabs.com", "Bill@ArdanLabs.com1", []string{"contains_email"}},
+		{"local part", "bill@ardanlabs.com", "MyNameIsBill99", []string{"contains_email"}},
+		{"breached", "bill@ardanlabs.com", "Password1234", []string{"breached"}},
+	}
+
+	t.Log("Given the need to validate passwords against a policy.")
+	{
+		for i, tc := range tt {
+			t.Logf("\tTest %d:\tWhen checking a %s password.", i, tc.name)
+			{
+				err := policy.Check(tc.email, tc.password)
+				if tc.want == nil {
+					if err != nil {
+						t.Fatalf("\t%s\tShould accept the password : %s.", failed, err)
+					}
+					t.Logf("\t%s\tShould accept the password.", success)
+					continue
+				}
+
+				inv, ok := errors.Cause(err).(web.InvalidError)
+				if !ok {
+					t.Fatalf("\t%s\tShould receive a web.InvalidError : %v.", failed, err)
+				}
+				t.Logf("\t%s\tShould receive a web.InvalidError.", success)
+
+				var got []string
+				for _, v := range inv {
+					got = append(got, v.Err)
+				}
+				if len(got) != len(tc.want) {
+					t.Log("\t\tGot :", got)
+					t.Log("\t\tWant:", tc.want)
+					t.Fatalf("\t%s\tShould report the expected violations.", failed)
+				}
+				for j := range got {
+					if got[j] != tc.want[j] {
+						t.Log("\t\tGot :", got)
+						t.Log("\t\tWant:", tc.want)
+						t.Fatalf("\t%s\tShould report the expected violations.", failed)
+					}
+				}
+				t.Logf("\t%s\tShould report the expected violations.", success)
+			}
+		}
+	}
+}
+
+// TestHashList validates breached passwords are found in a sorted hash list
+// of any size.
+func TestHashList(t *testing.T) {
+	const (
+		success = "\u2713"
+		failed  = "\u2717"
+	)
+
+	dir, err := ioutil.TempDir("", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Build a list in the format of the Pwned Passwords download ordered by
+	// hash, with a comment on top and a trailing blank line.
+	var breached, hashes []string
+	for i := 0; i < 1000; i++ {
+		p := fmt.Sprintf("password%d", i)
+		sum := sha1.Sum([]byte(p))
+		breached = append(breached, p)
+		hashes = append(hashes, fmt.Sprintf("%X:%d\r\n", sum, i+1))
+	}
+	sort.Strings(hashes)
+
+	list := filepath.Join(dir, "breached.txt")
+	if err := ioutil.WriteFile(list, []byte("# breached\n"+strings.Join(hashes, "")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Given the need to look up passwords in a large breach list.")
+	{
+		hl, err := user.NewHashList(list)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to open the hash list : %s.", failed, err)
+		}
+		defer hl.Close()
+		t.Logf("\t%s\tShould be able to open the hash list.", success)
+
+		t.Log("\tWhen looking up every breached password.")
+		{
+			for _, p := range breached {
+				ok, err := hl.Contains(p)
+				if err != nil || !ok {
+					t.Fatalf("\t%s\tShould find %s : %v %v.", failed, p, ok, err)
+				}
+			}
+			t.Logf("\t%s\tShould find every password.", success)
+		}
+
+		t.Log("\tWhen looking up passwords that were not breached.")
+		{
+			for i := 0; i < 1000; i++ {
+				p := fmt.Sprintf("Gophers%d", i)
+				ok, err := hl.Contains(p)
+				if err != nil || ok {
+					t.Fatalf("\t%s\tShould not find %s : %v %v.", failed, p, ok, err)
+				}
+			}
+			t.Logf("\t%s\tShould not find them.", success)
+		}
+	}
+
+	t.Log("Given a file that is not a hash list.")
+	{
+		bad := filepath.Join(dir, "bad.txt")
+		if err := ioutil.WriteFile(bad, []byte("password\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := user.NewHashList(bad); err == nil {
+			t.Fatalf("\t%s\tShould refuse to open it.", failed)
+		}
+		t.Logf("\t%s\tShould refuse to open it.", success)
+	}
+}
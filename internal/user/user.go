@@ -3,13 +3,14 @@ package user
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"time"
 
 	"inventory-optimisation-server/internal/platform/auth"
 	"inventory-optimisation-server/internal/platform/db"
+	"inventory-optimisation-server/internal/platform/web"
 
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/bcrypt"
 	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -18,36 +19,92 @@ const usersCollection = "users"
 
 var (
 	// ErrNotFound abstracts the mgo not found error.
-	ErrNotFound = errors.New("Entity not found")
+	ErrNotFound = web.NewError("user_not_found", http.StatusNotFound, "User not found")
 
 	// ErrInvalidID occurs when an ID is not in a valid form.
-	ErrInvalidID = errors.New("ID is not in its proper form")
+	ErrInvalidID = web.NewError("user_invalid_id", http.StatusBadRequest, "User ID is not in its proper form")
 
 	// ErrAuthenticationFailure occurs when a user attempts to authenticate but
 	// anything goes wrong.
-	ErrAuthenticationFailure = errors.New("Authentication failed")
+	ErrAuthenticationFailure = web.NewError("authentication_failed", http.StatusUnauthorized, "Authentication failed")
 
 	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
-	ErrForbidden = errors.New("Attempted action is not allowed")
+	ErrForbidden = web.NewError("user_forbidden", http.StatusForbidden, "Attempted action is not allowed")
+
+	// ErrEmailTaken occurs when an external identity asserts an email
+	// address that belongs to another user.
+	ErrEmailTaken = web.NewError("user_email_taken", http.StatusConflict, "Email address belongs to another user")
 )
 
-// List retrieves a list of existing users from the database.
-func List(ctx context.Context, dbConn *db.DB) ([]User, error) {
+// live restricts a query to users that have not been soft deleted unless
+// includeDeleted is set.
+func live(q bson.M, includeDeleted bool) bson.M {
+	if !includeDeleted {
+		q["deleted_at"] = bson.M{"$exists": false}
+	}
+	return q
+}
+
+// versioned restricts a query to a version of a user unless version is
+// web.AnyVersion. Users stored before they were versioned have no version
+// and are treated as version 0.
+func versioned(q bson.M, version int) bson.M {
+	switch version {
+	case web.AnyVersion:
+	case 0:
+		q["version"] = bson.M{"$in": []interface{}{0, nil}}
+	default:
+		q["version"] = version
+	}
+	return q
+}
+
+// unmatched explains why a conditional update of a live user matched
+// nothing. Either the user does not exist or it changed since the client
+// retrieved the version it sent.
+func unmatched(ctx context.Context, dbConn *db.DB, id string, version int) error {
+	if version == web.AnyVersion {
+		return ErrNotFound
+	}
+
+	q := live(bson.M{"_id": bson.ObjectIdHex(id)}, false)
+
+	var n int
+	f := func(collection *mgo.Collection) error {
+		var err error
+		n, err = collection.Find(q).Count()
+		return err
+	}
+	if err := dbConn.Execute(ctx, usersCollection, f); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("db.users.count(%s)", db.Query(q)))
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+	return web.ErrPreconditionFailed
+}
+
+// List retrieves a list of existing users from the database. Soft deleted
+// users are only returned when includeDeleted is set.
+func List(ctx context.Context, dbConn *db.DB, includeDeleted bool) ([]User, error) {
 
 	u := []User{}
+	q := live(bson.M{}, includeDeleted)
 
 	f := func(collection *mgo.Collection) error {
-		return collection.Find(nil).All(&u)
+		return collection.Find(q).All(&u)
 	}
 	if err := dbConn.Execute(ctx, usersCollection, f); err != nil {
-		return nil, errors.Wrap(err, "db.users.find()")
+		return nil, errors.Wrap(err, fmt.Sprintf("db.users.find(%s)", db.Query(q)))
 	}
 
 	return u, nil
 }
 
-// Retrieve gets the specified user from the database.
-func Retrieve(ctx context.Context, claims auth.Claims, dbConn *db.DB, id string) (*User, error) {
+// Retrieve gets the specified user from the database. A soft deleted user is
+// only returned when includeDeleted is set.
+func Retrieve(ctx context.Context, claims auth.Claims, dbConn *db.DB, id string, includeDeleted bool) (*User, error) {
 
 	if !bson.IsObjectIdHex(id) {
 		return nil, ErrInvalidID
@@ -58,7 +115,7 @@ func Retrieve(ctx context.Context, claims auth.Claims, dbConn *db.DB, id string)
 		return nil, ErrForbidden
 	}
 
-	q := bson.M{"_id": bson.ObjectIdHex(id)}
+	q := live(bson.M{"_id": bson.ObjectIdHex(id)}, includeDeleted)
 
 	var u *User
 	f := func(collection *mgo.Collection) error {
@@ -75,15 +132,15 @@ func Retrieve(ctx context.Context, claims auth.Claims, dbConn *db.DB, id string)
 }
 
 // Create inserts a new user into the database.
-func Create(ctx context.Context, dbConn *db.DB, nu *NewUser, now time.Time) (*User, error) {
+func Create(ctx context.Context, dbConn *db.DB, hasher Hasher, nu *NewUser, now time.Time) (*User, error) {
 
 	// Mongo truncates times to milliseconds when storing. We and do the same
 	// here so the value we return is consistent with what we store.
 	now = now.Truncate(time.Millisecond)
 
-	pw, err := bcrypt.GenerateFromPassword([]byte(nu.Password), bcrypt.DefaultCost)
+	pw, err := hasher.Hash(nu.Password)
 	if err != nil {
-		return nil, errors.Wrap(err, "generating password hash")
+		return nil, err
 	}
 
 	u := User{
@@ -92,6 +149,7 @@ func Create(ctx context.Context, dbConn *db.DB, nu *NewUser, now time.Time) (*Us
 		Email:        nu.Email,
 		PasswordHash: pw,
 		Roles:        nu.Roles,
+		Version:      1,
 		DateCreated:  now,
 		DateModified: now,
 	}
@@ -99,55 +157,47 @@ func Create(ctx context.Context, dbConn *db.DB, nu *NewUser, now time.Time) (*Us
 	f := func(collection *mgo.Collection) error {
 		return collection.Insert(&u)
 	}
-	if err := dbConn.Execute(ctx, usersCollection, f); err != nil {
+	if err := dbConn.ExecuteWrite(ctx, usersCollection, f); err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("db.users.insert(%s)", db.Query(&u)))
 	}
 
 	return &u, nil
 }
 
-// Update replaces a user document in the database.
-func Update(ctx context.Context, dbConn *db.DB, id string, upd *UpdateUser, now time.Time) error {
+// Update replaces a user document in the database. The user is only changed
+// if it is still at the version, pass web.AnyVersion to change it whatever
+// its version.
+func Update(ctx context.Context, dbConn *db.DB, hasher Hasher, id string, upd *UpdateUser, version int, now time.Time) error {
 
 	if !bson.IsObjectIdHex(id) {
 		return ErrInvalidID
 	}
 
-	fields := make(bson.M)
-
-	if upd.Name != nil {
-		fields["name"] = *upd.Name
+	fields := bson.M{
+		"name":  upd.Name,
+		"email": upd.Email,
+		"roles": upd.Roles,
 	}
-	if upd.Email != nil {
-		fields["email"] = *upd.Email
-	}
-	if upd.Roles != nil {
-		fields["roles"] = upd.Roles
-	}
-	if upd.Password != nil {
-		pw, err := bcrypt.GenerateFromPassword([]byte(*upd.Password), bcrypt.DefaultCost)
+
+	if upd.Password != "" {
+		pw, err := hasher.Hash(upd.Password)
 		if err != nil {
-			return errors.Wrap(err, "generating password hash")
+			return err
 		}
 		fields["password_hash"] = pw
 	}
 
-	// If there's nothing to update we can quit early.
-	if len(fields) == 0 {
-		return nil
-	}
-
 	fields["date_modified"] = now
 
-	m := bson.M{"$set": fields}
-	q := bson.M{"_id": bson.ObjectIdHex(id)}
+	m := bson.M{"$set": fields, "$inc": bson.M{"version": 1}}
+	q := versioned(live(bson.M{"_id": bson.ObjectIdHex(id)}, false), version)
 
 	f := func(collection *mgo.Collection) error {
 		return collection.Update(q, m)
 	}
-	if err := dbConn.Execute(ctx, usersCollection, f); err != nil {
+	if err := dbConn.ExecuteWrite(ctx, usersCollection, f); err != nil {
 		if err == mgo.ErrNotFound {
-			return ErrNotFound
+			return unmatched(ctx, dbConn, id, version)
 		}
 		return errors.Wrap(err, fmt.Sprintf("db.customers.update(%s, %s)", db.Query(q), db.Query(m)))
 	}
@@ -155,28 +205,196 @@ func Update(ctx context.Context, dbConn *db.DB, id string, upd *UpdateUser, now
 	return nil
 }
 
-// Delete removes a user from the database.
-func Delete(ctx context.Context, dbConn *db.DB, id string) error {
+// Delete marks a user as deleted. The user is hidden from every query until
+// it is restored or purged. Like Update it only applies to the version.
+func Delete(ctx context.Context, dbConn *db.DB, id string, version int, now time.Time) error {
 
 	if !bson.IsObjectIdHex(id) {
 		return ErrInvalidID
 	}
 
-	q := bson.M{"_id": bson.ObjectIdHex(id)}
+	now = now.Truncate(time.Millisecond)
+
+	m := bson.M{"$set": bson.M{"deleted_at": now, "date_modified": now}, "$inc": bson.M{"version": 1}}
+	q := versioned(live(bson.M{"_id": bson.ObjectIdHex(id)}, false), version)
 
 	f := func(collection *mgo.Collection) error {
-		return collection.Remove(q)
+		return collection.Update(q, m)
 	}
-	if err := dbConn.Execute(ctx, usersCollection, f); err != nil {
+	if err := dbConn.ExecuteWrite(ctx, usersCollection, f); err != nil {
+		if err == mgo.ErrNotFound {
+			return unmatched(ctx, dbConn, id, version)
+		}
+		return errors.Wrap(err, fmt.Sprintf("db.users.update(%s, %s)", db.Query(q), db.Query(m)))
+	}
+
+	return nil
+}
+
+// Restore brings back a user that was previously deleted.
+func Restore(ctx context.Context, dbConn *db.DB, id string, now time.Time) error {
+
+	if !bson.IsObjectIdHex(id) {
+		return ErrInvalidID
+	}
+
+	now = now.Truncate(time.Millisecond)
+
+	m := bson.M{"$unset": bson.M{"deleted_at": ""}, "$set": bson.M{"date_modified": now}, "$inc": bson.M{"version": 1}}
+	q := bson.M{"_id": bson.ObjectIdHex(id), "deleted_at": bson.M{"$exists": true}}
+
+	f := func(collection *mgo.Collection) error {
+		return collection.Update(q, m)
+	}
+	if err := dbConn.ExecuteWrite(ctx, usersCollection, f); err != nil {
 		if err == mgo.ErrNotFound {
 			return ErrNotFound
 		}
-		return errors.Wrap(err, fmt.Sprintf("db.users.remove(%s)", db.Query(q)))
+		return errors.Wrap(err, fmt.Sprintf("db.users.update(%s, %s)", db.Query(q), db.Query(m)))
 	}
 
 	return nil
 }
 
+// Purge permanently removes users that were deleted before the specified
+// time. It returns the number of users removed.
+func Purge(ctx context.Context, dbConn *db.DB, before time.Time) (int, error) {
+
+	q := bson.M{"deleted_at": bson.M{"$lt": before}}
+
+	var info *mgo.ChangeInfo
+	f := func(collection *mgo.Collection) error {
+		var err error
+		info, err = collection.RemoveAll(q)
+		return err
+	}
+	if err := dbConn.ExecuteWrite(ctx, usersCollection, f); err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("db.users.remove(%s)", db.Query(q)))
+	}
+
+	return info.Removed, nil
+}
+
+// emailTaken reports whether a live user other than the one with the id
+// has the email address.
+func emailTaken(ctx context.Context, dbConn *db.DB, email string, id bson.ObjectId) (bool, error) {
+
+	q := live(bson.M{"email": email, "_id": bson.M{"$ne": id}}, false)
+
+	var n int
+	f := func(collection *mgo.Collection) error {
+		var err error
+		n, err = collection.Find(q).Count()
+		return err
+	}
+	if err := dbConn.Execute(ctx, usersCollection, f); err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("db.users.count(%s)", db.Query(q)))
+	}
+
+	return n > 0, nil
+}
+
+// Provision finds the user linked to an external identity, creating it on
+// first login. The name, email and roles are refreshed from the identity
+// provider on every login. A deleted user is not brought back.
+//
+// The identity is never linked to an existing user by email, an email
+// address used by another user fails with ErrEmailTaken. Callers must only
+// pass addresses the identity provider has verified.
+func Provision(ctx context.Context, dbConn *db.DB, eu *ExternalUser, now time.Time) (*User, error) {
+
+	now = now.Truncate(time.Millisecond)
+
+	q := bson.M{"external_issuer": eu.Issuer, "external_subject": eu.Subject}
+
+	var u *User
+	f := func(collection *mgo.Collection) error {
+		return collection.Find(q).One(&u)
+	}
+	err := dbConn.Execute(ctx, usersCollection, f)
+
+	switch {
+	case err == mgo.ErrNotFound:
+		id := bson.NewObjectId()
+
+		taken, err := emailTaken(ctx, dbConn, eu.Email, id)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, ErrEmailTaken
+		}
+
+		u = &User{
+			ID:              id,
+			Name:            eu.Name,
+			Email:           eu.Email,
+			Roles:           eu.Roles,
+			ExternalIssuer:  eu.Issuer,
+			ExternalSubject: eu.Subject,
+			Version:         1,
+			DateCreated:     now,
+			DateModified:    now,
+		}
+
+		f := func(collection *mgo.Collection) error {
+			return collection.Insert(u)
+		}
+		if err := dbConn.ExecuteWrite(ctx, usersCollection, f); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("db.users.insert(%s)", db.Query(u)))
+		}
+
+		return u, nil
+
+	case err != nil:
+		return nil, errors.Wrap(err, fmt.Sprintf("db.users.find(%s)", db.Query(q)))
+	}
+
+	if u.DeletedAt != nil {
+		return nil, ErrForbidden
+	}
+
+	if eu.Email != u.Email {
+		taken, err := emailTaken(ctx, dbConn, eu.Email, u.ID)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, ErrEmailTaken
+		}
+	}
+
+	u.Name = eu.Name
+	u.Email = eu.Email
+	u.Roles = eu.Roles
+	u.Version++
+	u.DateModified = now
+
+	m := bson.M{"$set": bson.M{"name": u.Name, "email": u.Email, "roles": u.Roles, "date_modified": now}, "$inc": bson.M{"version": 1}}
+	q = bson.M{"_id": u.ID}
+
+	f = func(collection *mgo.Collection) error {
+		return collection.Update(q, m)
+	}
+	if err := dbConn.ExecuteWrite(ctx, usersCollection, f); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("db.users.update(%s, %s)", db.Query(q), db.Query(m)))
+	}
+
+	return u, nil
+}
+
+// NewToken generates a token for an already authenticated user.
+func NewToken(tknGen TokenGenerator, u *User, now time.Time) (Token, error) {
+	claims := auth.NewClaims(u.ID.Hex(), u.Roles, now, time.Hour)
+
+	tkn, err := tknGen.GenerateToken(claims)
+	if err != nil {
+		return Token{}, errors.Wrap(err, "generating token")
+	}
+
+	return Token{Token: tkn}, nil
+}
+
 // TokenGenerator is the behavior we need in our Authenticate to generate
 // tokens for authenticated users.
 type TokenGenerator interface {
@@ -186,10 +404,14 @@ type TokenGenerator interface {
 // Authenticate finds a user by their email and verifies their password. On
 // success it returns a Token that can be used to authenticate in the future.
 //
-// The key, keyID, and alg are required for generating the token.
-func Authenticate(ctx context.Context, dbConn *db.DB, tknGen TokenGenerator, now time.Time, email, password string) (Token, error) {
+// If the stored hash was made with weaker settings than the hasher is
+// configured for, the password is rehashed and saved so work factors can be
+// raised without forcing password resets.
+func Authenticate(ctx context.Context, dbConn *db.DB, tknGen TokenGenerator, hasher Hasher, now time.Time, email, password string) (Token, error) {
 
-	q := bson.M{"email": email}
+	// Users provisioned from an identity provider have no password and
+	// can only log in through it.
+	q := live(bson.M{"email": email, "external_subject": bson.M{"$exists": false}}, false)
 
 	var u *User
 	f := func(collection *mgo.Collection) error {
@@ -205,20 +427,28 @@ func Authenticate(ctx context.Context, dbConn *db.DB, tknGen TokenGenerator, now
 		return Token{}, errors.Wrap(err, fmt.Sprintf("db.users.find(%s)", db.Query(q)))
 	}
 
-	// Compare the provided password with the saved hash. Use the bcrypt
-	// comparison function so it is cryptographically secure.
-	if err := bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)); err != nil {
+	// Compare the provided password with the saved hash. The hasher uses a
+	// constant time comparison so it is cryptographically secure.
+	if err := hasher.Compare(u.PasswordHash, password); err != nil {
 		return Token{}, ErrAuthenticationFailure
 	}
 
-	// If we are this far the request is valid. Create some claims for the user
-	// and generate their token.
-	claims := auth.NewClaims(u.ID.Hex(), u.Roles, now, time.Hour)
-
-	tkn, err := tknGen.GenerateToken(claims)
-	if err != nil {
-		return Token{}, errors.Wrap(err, "generating token")
+	// The password is known good so this is our only chance to upgrade the
+	// stored hash. A failure here should not fail the login, the next
+	// successful login will try again.
+	if hasher.NeedsRehash(u.PasswordHash) {
+		if pw, err := hasher.Hash(password); err == nil {
+			m := bson.M{"$set": bson.M{"password_hash": pw}}
+			q := bson.M{"_id": u.ID}
+
+			f := func(collection *mgo.Collection) error {
+				return collection.Update(q, m)
+			}
+			dbConn.ExecuteWrite(ctx, usersCollection, f)
+		}
 	}
 
-	return Token{Token: tkn}, nil
+	// If we are this far the request is valid. Create some claims for the user
+	// and generate their token.
+	return NewToken(tknGen, u, now)
 }
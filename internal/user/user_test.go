@@ -8,6 +8,7 @@ import (
 
 	"github.com/ardanlabs/service/internal/platform/auth"
 	"github.com/ardanlabs/service/internal/platform/tests"
+	"github.com/ardanlabs/service/internal/platform/web"
 	"github.com/ardanlabs/service/internal/user"
 	"github.com/google/go-cmp/cmp"
 	"github.com/pkg/errors"
@@ -53,13 +54,13 @@ func TestUser(t *testing.T) {
 				PasswordConfirm: "gophers",
 			}
 
-			u, err := user.Create(ctx, dbConn, &nu, now)
+			u, err := user.Create(ctx, dbConn, user.Hasher{}, &nu, now)
 			if err != nil {
 				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to create user.", tests.Success)
 
-			savedU, err := user.Retrieve(ctx, claims, dbConn, u.ID.Hex())
+			savedU, err := user.Retrieve(ctx, claims, dbConn, u.ID.Hex(), false)
 			if err != nil {
 				t.Fatalf("\t%s\tShould be able to retrieve user by ID: %s.", tests.Failed, err)
 			}
@@ -71,39 +72,60 @@ func TestUser(t *testing.T) {
 			t.Logf("\t%s\tShould get back the same user.", tests.Success)
 
 			upd := user.UpdateUser{
-				Name:  tests.StringPointer("Jacob Walker"),
-				Email: tests.StringPointer("jacob@ardanlabs.com"),
+				Name:  "Jacob Walker",
+				Email: "jacob@ardanlabs.com",
+				Roles: []string{},
 			}
 
-			if err := user.Update(ctx, dbConn, u.ID.Hex(), &upd, now); err != nil {
+			if err := user.Update(ctx, dbConn, user.Hasher{}, u.ID.Hex(), &upd, u.Version, now); err != nil {
 				t.Fatalf("\t%s\tShould be able to update user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to update user.", tests.Success)
 
-			savedU, err = user.Retrieve(ctx, claims, dbConn, u.ID.Hex())
+			savedU, err = user.Retrieve(ctx, claims, dbConn, u.ID.Hex(), false)
 			if err != nil {
 				t.Fatalf("\t%s\tShould be able to retrieve user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to retrieve user.", tests.Success)
 
-			if savedU.Name != *upd.Name {
+			if savedU.Name != upd.Name {
 				t.Log("\t\tGot :", savedU.Name)
-				t.Log("\t\tWant:", *upd.Name)
+				t.Log("\t\tWant:", upd.Name)
 				t.Errorf("\t%s\tShould be able to see updates to LastName.", tests.Failed)
 			} else {
 				t.Logf("\t%s\tShould be able to see updates to LastName.", tests.Success)
 			}
 
-			if err := user.Delete(ctx, dbConn, u.ID.Hex()); err != nil {
+			if err := user.Update(ctx, dbConn, user.Hasher{}, u.ID.Hex(), &upd, u.Version, now); errors.Cause(err) != web.ErrPreconditionFailed {
+				t.Fatalf("\t%s\tShould NOT be able to update a stale version : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould NOT be able to update a stale version.", tests.Success)
+
+			if err := user.Delete(ctx, dbConn, u.ID.Hex(), savedU.Version, now); err != nil {
 				t.Fatalf("\t%s\tShould be able to delete user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to delete user.", tests.Success)
 
-			savedU, err = user.Retrieve(ctx, claims, dbConn, u.ID.Hex())
+			savedU, err = user.Retrieve(ctx, claims, dbConn, u.ID.Hex(), false)
 			if errors.Cause(err) != user.ErrNotFound {
 				t.Fatalf("\t%s\tShould NOT be able to retrieve user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould NOT be able to retrieve user.", tests.Success)
+
+			if _, err := user.Retrieve(ctx, claims, dbConn, u.ID.Hex(), true); err != nil {
+				t.Fatalf("\t%s\tShould be able to retrieve deleted user : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to retrieve deleted user.", tests.Success)
+
+			if err := user.Restore(ctx, dbConn, u.ID.Hex(), now); err != nil {
+				t.Fatalf("\t%s\tShould be able to restore user : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to restore user.", tests.Success)
+
+			if _, err := user.Retrieve(ctx, claims, dbConn, u.ID.Hex(), false); err != nil {
+				t.Fatalf("\t%s\tShould be able to retrieve restored user : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to retrieve restored user.", tests.Success)
 		}
 	}
 }
@@ -141,14 +163,14 @@ func TestAuthenticate(t *testing.T) {
 
 			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
 
-			u, err := user.Create(ctx, dbConn, &nu, now)
+			u, err := user.Create(ctx, dbConn, user.Hasher{}, &nu, now)
 			if err != nil {
 				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to create user.", tests.Success)
 
 			var tknGen mockTokenGenerator
-			tkn, err := user.Authenticate(ctx, dbConn, tknGen, now, "anna@ardanlabs.com", "goroutines")
+			tkn, err := user.Authenticate(ctx, dbConn, tknGen, user.Hasher{}, now, "anna@ardanlabs.com", "goroutines")
 			if err != nil {
 				t.Fatalf("\t%s\tShould be able to generate a token : %s.", tests.Failed, err)
 			}
@@ -162,7 +184,19 @@ func TestAuthenticate(t *testing.T) {
 			}
 			t.Logf("\t%s\tToken should indicate the specified user and time were used.", tests.Success)
 
-			if err := user.Delete(ctx, dbConn, u.ID.Hex()); err != nil {
+			eu := user.ExternalUser{
+				Issuer:  "https://idp.ardanlabs.com",
+				Subject: "idp|1234",
+				Name:    "Anna Walker",
+				Email:   "anna@ardanlabs.com",
+				Roles:   []string{auth.RoleUser},
+			}
+			if _, err := user.Provision(ctx, dbConn, &eu, now); errors.Cause(err) != user.ErrEmailTaken {
+				t.Fatalf("\t%s\tShould not provision an identity with the email of another user : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not provision an identity with the email of another user.", tests.Success)
+
+			if err := user.Delete(ctx, dbConn, u.ID.Hex(), u.Version, now); err != nil {
 				t.Fatalf("\t%s\tShould be able to delete user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to delete user.", tests.Success)